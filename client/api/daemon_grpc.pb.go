@@ -28,8 +28,10 @@ type DaemonClient interface {
 	GetProxyProfiles(ctx context.Context, in *Request, opts ...grpc.CallOption) (*GetProxyProfilesResponse, error)
 	NotifyProxy(ctx context.Context, in *NotifyRequest, opts ...grpc.CallOption) (*NotifyResponse, error)
 	PullImage(ctx context.Context, in *ImageReference, opts ...grpc.CallOption) (*ImagePullResponse, error)
+	WatchPull(ctx context.Context, in *WatchPullRequest, opts ...grpc.CallOption) (Daemon_WatchPullClient, error)
 	SetOptimizer(ctx context.Context, in *OptimizeRequest, opts ...grpc.CallOption) (*OptimizeResponse, error)
 	ReportTraces(ctx context.Context, in *ReportTracesRequest, opts ...grpc.CallOption) (*ReportTracesResponse, error)
+	QueryProxy(ctx context.Context, in *ProxyQueryRequest, opts ...grpc.CallOption) (*ProxyQueryResponse, error)
 }
 
 type daemonClient struct {
@@ -94,6 +96,38 @@ func (c *daemonClient) PullImage(ctx context.Context, in *ImageReference, opts .
 	return out, nil
 }
 
+func (c *daemonClient) WatchPull(ctx context.Context, in *WatchPullRequest, opts ...grpc.CallOption) (Daemon_WatchPullClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Daemon_ServiceDesc.Streams[0], "/api.Daemon/WatchPull", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &daemonWatchPullClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Daemon_WatchPullClient interface {
+	Recv() (*PullProgress, error)
+	grpc.ClientStream
+}
+
+type daemonWatchPullClient struct {
+	grpc.ClientStream
+}
+
+func (x *daemonWatchPullClient) Recv() (*PullProgress, error) {
+	m := new(PullProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *daemonClient) SetOptimizer(ctx context.Context, in *OptimizeRequest, opts ...grpc.CallOption) (*OptimizeResponse, error) {
 	out := new(OptimizeResponse)
 	err := c.cc.Invoke(ctx, "/api.Daemon/SetOptimizer", in, out, opts...)
@@ -112,6 +146,15 @@ func (c *daemonClient) ReportTraces(ctx context.Context, in *ReportTracesRequest
 	return out, nil
 }
 
+func (c *daemonClient) QueryProxy(ctx context.Context, in *ProxyQueryRequest, opts ...grpc.CallOption) (*ProxyQueryResponse, error) {
+	out := new(ProxyQueryResponse)
+	err := c.cc.Invoke(ctx, "/api.Daemon/QueryProxy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DaemonServer is the server API for Daemon service.
 // All implementations must embed UnimplementedDaemonServer
 // for forward compatibility
@@ -122,8 +165,10 @@ type DaemonServer interface {
 	GetProxyProfiles(context.Context, *Request) (*GetProxyProfilesResponse, error)
 	NotifyProxy(context.Context, *NotifyRequest) (*NotifyResponse, error)
 	PullImage(context.Context, *ImageReference) (*ImagePullResponse, error)
+	WatchPull(*WatchPullRequest, Daemon_WatchPullServer) error
 	SetOptimizer(context.Context, *OptimizeRequest) (*OptimizeResponse, error)
 	ReportTraces(context.Context, *ReportTracesRequest) (*ReportTracesResponse, error)
+	QueryProxy(context.Context, *ProxyQueryRequest) (*ProxyQueryResponse, error)
 	mustEmbedUnimplementedDaemonServer()
 }
 
@@ -149,12 +194,18 @@ func (UnimplementedDaemonServer) NotifyProxy(context.Context, *NotifyRequest) (*
 func (UnimplementedDaemonServer) PullImage(context.Context, *ImageReference) (*ImagePullResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PullImage not implemented")
 }
+func (UnimplementedDaemonServer) WatchPull(*WatchPullRequest, Daemon_WatchPullServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPull not implemented")
+}
 func (UnimplementedDaemonServer) SetOptimizer(context.Context, *OptimizeRequest) (*OptimizeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetOptimizer not implemented")
 }
 func (UnimplementedDaemonServer) ReportTraces(context.Context, *ReportTracesRequest) (*ReportTracesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReportTraces not implemented")
 }
+func (UnimplementedDaemonServer) QueryProxy(context.Context, *ProxyQueryRequest) (*ProxyQueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryProxy not implemented")
+}
 func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
 
 // UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
@@ -276,6 +327,27 @@ func _Daemon_PullImage_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_WatchPull_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPullRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DaemonServer).WatchPull(m, &daemonWatchPullServer{stream})
+}
+
+type Daemon_WatchPullServer interface {
+	Send(*PullProgress) error
+	grpc.ServerStream
+}
+
+type daemonWatchPullServer struct {
+	grpc.ServerStream
+}
+
+func (x *daemonWatchPullServer) Send(m *PullProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _Daemon_SetOptimizer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(OptimizeRequest)
 	if err := dec(in); err != nil {
@@ -312,6 +384,24 @@ func _Daemon_ReportTraces_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_QueryProxy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProxyQueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).QueryProxy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.Daemon/QueryProxy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).QueryProxy(ctx, req.(*ProxyQueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Daemon_ServiceDesc is the grpc.ServiceDesc for Daemon service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -351,7 +441,17 @@ var Daemon_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ReportTraces",
 			Handler:    _Daemon_ReportTraces_Handler,
 		},
+		{
+			MethodName: "QueryProxy",
+			Handler:    _Daemon_QueryProxy_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPull",
+			Handler:       _Daemon_WatchPull_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "client/api/daemon.proto",
 }
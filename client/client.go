@@ -15,9 +15,11 @@ import (
 	iofs "io/fs"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -68,6 +70,9 @@ type Client struct {
 	cliServer   *grpc.Server
 	cliListener net.Listener
 
+	// metrics
+	metricsServer *http.Server
+
 	// layer
 	layerMapLock sync.Mutex
 	layerMap     map[string]*mountPoint
@@ -107,57 +112,141 @@ func getDistributionSource(cfg string) string {
 	return fmt.Sprintf("starlight.mc256.dev/distribution.source.%s", cfg)
 }
 
+// newestImage returns the most recently created image in the list, or nil if the list is empty
+func newestImage(list []containerd.Image) containerd.Image {
+	var newest containerd.Image
+	for _, i := range list {
+		if newest == nil || i.Metadata().CreatedAt.After(newest.Metadata().CreatedAt) {
+			newest = i
+		}
+	}
+	return newest
+}
+
+// pickClosestImage returns the image with the largest overlap, the newest one wins if there is a tie.
+// If none of the images has any overlap, it returns the newest image.
+func pickClosestImage(list []containerd.Image, overlaps []int64) containerd.Image {
+	var (
+		closest containerd.Image
+		most    int64
+	)
+	for idx, i := range list {
+		if overlaps[idx] <= 0 {
+			continue
+		}
+		if overlaps[idx] > most ||
+			(overlaps[idx] == most && i.Metadata().CreatedAt.After(closest.Metadata().CreatedAt)) {
+			closest, most = i, overlaps[idx]
+		}
+	}
+	if closest == nil {
+		return newestImage(list)
+	}
+	return closest
+}
+
 func (c *Client) findImage(ctr *containerd.Client, filter string) (img containerd.Image, err error) {
 	var list []containerd.Image
 	list, err = ctr.ListImages(c.ctx, filter)
 	if err != nil {
 		return nil, err
 	}
-	if len(list) == 0 {
-		return nil, nil
+	// get the newest image
+	return newestImage(list), nil
+}
+
+// layerOverlap returns the total size of the layers of the image that are in the requested layers
+func (c *Client) layerOverlap(cs content.Store, img containerd.Image, requested map[string]bool) (int64, error) {
+	buf, err := content.ReadBlob(c.ctx, cs, img.Target())
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to read manifest")
 	}
-	if len(list) == 1 {
-		return list[0], nil
+	var man v1.Manifest
+	if err = json.Unmarshal(buf, &man); err != nil {
+		return 0, errors.Wrapf(err, "failed to parse manifest")
 	}
-	newest := list[0]
-	nt := newest.Metadata().CreatedAt
-	for _, i := range list {
-		cur := i.Metadata().CreatedAt
-		if cur.After(nt) {
-			newest = i
-			nt = cur
+	overlap := int64(0)
+	for _, l := range man.Layers {
+		if requested[l.Digest.String()] {
+			overlap += l.Size
 		}
 	}
-	// get the newest image
-	return newest, nil
+	return overlap, nil
+}
+
+// findClosestImage chooses the image that shares the most layers (in bytes) with the requested image,
+// so that the delta image is the smallest. The layers of the requested image are queried from the proxy.
+// It falls back to the newest image if the proxy does not know the requested image.
+func (c *Client) findClosestImage(ctr *containerd.Client, list []containerd.Image,
+	ref, platform, proxyCfg string) containerd.Image {
+	if len(list) <= 1 {
+		return newestImage(list)
+	}
+
+	pc, _ := c.cfg.getProxy(proxyCfg)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		log.G(c.ctx).WithError(err).Warn("failed to connect to proxy, choose the newest base image")
+		return newestImage(list)
+	}
+	layers, err := p.GetLayers(ref, platform)
+	if err != nil {
+		log.G(c.ctx).WithError(err).Warn("failed to get layers from proxy, choose the newest base image")
+		return newestImage(list)
+	}
+	requested := make(map[string]bool, len(layers))
+	for _, l := range layers {
+		requested[l] = true
+	}
+
+	cs := ctr.ContentStore()
+	overlaps := make([]int64, len(list))
+	for idx, img := range list {
+		if overlaps[idx], err = c.layerOverlap(cs, img, requested); err != nil {
+			log.G(c.ctx).
+				WithField("image", img.Name()).
+				WithError(err).
+				Warn("failed to compare layers with base image candidate")
+		}
+	}
+
+	closest := pickClosestImage(list, overlaps)
+	log.G(c.ctx).
+		WithField("ref", ref).
+		WithField("base", closest.Name()).
+		WithField("candidates", len(list)).
+		Debug("found closest base image")
+	return closest
 }
 
 // FindBaseImage find the closest available image for the requested image, if user appointed an image, then this
-// function will be used for confirming the appointed image is available in the local storage
-func (c *Client) FindBaseImage(ctr *containerd.Client, base, ref string) (img containerd.Image, err error) {
-	var baseFilter string
-	if base == "" {
-		sp := strings.Split(ref, ":")
-		if len(sp) > 1 {
-			tag := sp[len(sp)-1]
-			baseFilter = strings.TrimSuffix(ref, tag)
-			baseFilter = getImageFilter(baseFilter, true)
-		} else {
-			return nil, fmt.Errorf("invalid image reference: %s, missing tag", ref)
+// function will be used for confirming the appointed image is available in the local storage.
+// Otherwise, it chooses among the completed images of the same repository the one sharing the most layers with
+// the requested image.
+func (c *Client) FindBaseImage(ctr *containerd.Client, base, ref, platform, proxyCfg string) (img containerd.Image, err error) {
+	if base != "" {
+		img, err = c.findImage(ctr, getImageFilter(base, true))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find base image for %s", ref)
 		}
-	} else {
-		baseFilter = getImageFilter(base, true)
+		if img == nil {
+			return nil, fmt.Errorf("failed to find appointed base image %s", base)
+		}
+		return img, nil
+	}
+
+	sp := strings.Split(ref, ":")
+	if len(sp) <= 1 {
+		return nil, fmt.Errorf("invalid image reference: %s, missing tag", ref)
 	}
+	tag := sp[len(sp)-1]
+	baseFilter := getImageFilter(strings.TrimSuffix(ref, tag), true)
 
-	img, err = c.findImage(ctr, baseFilter)
+	list, err := ctr.ListImages(c.ctx, baseFilter)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to find base image for %s", ref)
 	}
-	if img == nil && base != "" {
-		return nil, fmt.Errorf("failed to find appointed base image %s", base)
-	}
-
-	return img, nil
+	return c.findClosestImage(ctr, list, ref, platform, proxyCfg), nil
 }
 
 // -----------------------------------------------------------------------------
@@ -251,6 +340,21 @@ func (c *Client) ScanExistingFilesystems() {
 	return
 }
 
+// completedLayers returns the digests of the layers that have been completely extracted on this client
+func (c *Client) completedLayers() []string {
+	c.layerMapLock.Lock()
+	defer c.layerMapLock.Unlock()
+
+	res := make([]string, 0, len(c.layerMap))
+	for d := range c.layerMap {
+		if _, err := os.Stat(filepath.Join(c.GetFilesystemPath(d), "completed.json")); err == nil {
+			res = append(res, d)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
 // -----------------------------------------------------------------------------
 // Image Pulling
 
@@ -290,6 +394,71 @@ func (c *Client) handleManifest(buf *bytes.Buffer) (manifest *v1.Manifest, b []b
 	return manifest, man, nil
 }
 
+// verifyDeltaHeader checks the manifest against the Digest header, the config against the manifest and
+// the Starlight header against the Starlight-Digest header of the delta image
+func verifyDeltaHeader(res *common.DeltaImageMetadata, manifest *v1.Manifest, man, con, sta []byte) error {
+	if err := verifyBlobDigest("manifest", res.Digest, man); err != nil {
+		return err
+	}
+	if err := verifyBlobDigest("config", manifest.Config.Digest.String(), con); err != nil {
+		return err
+	}
+	return verifyBlobDigest("starlight header", res.StarlightDigest, sta)
+}
+
+func verifyBlobDigest(name, expected string, b []byte) error {
+	d, err := digest.Parse(expected)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s digest %q", name, expected)
+	}
+	if actual := d.Algorithm().FromBytes(b); actual != d {
+		return fmt.Errorf("%w: %s digest is %s, expected %s", ErrContentIntegrity, name, actual, d)
+	}
+	return nil
+}
+
+// verifySignature checks the signature attestation passed by the proxy with the keys of the daemon,
+// it does nothing if no key is configured. Only the manifest digest is signed, the Starlight header
+// (and the content digests in it) is not bound to the signature.
+func (c *Client) verifySignature(manifestDigest string, signature *common.SignatureAttestation) error {
+	v, err := common.NewSignatureVerifier(c.cfg.SignatureKeys)
+	if err != nil || v == nil {
+		return err
+	}
+	var signatures []*common.SignatureAttestation
+	if signature != nil {
+		signatures = append(signatures, signature)
+	}
+	if _, err = v.Verify(manifestDigest, signatures); err != nil {
+		return err
+	}
+	log.G(c.ctx).WithField("manifest", manifestDigest).Debug("verified signature")
+	return nil
+}
+
+// skipVerify returns true if the delta image header of the proxy is not checked against the digests.
+// The header is always checked if signature keys are configured, otherwise a signature of the expected
+// manifest digest would vouch for whatever manifest the proxy sends.
+func (c *Client) skipVerify(pc *ProxyConfig) bool {
+	if !pc.InsecureSkipVerify {
+		return false
+	}
+	if len(c.cfg.SignatureKeys) != 0 {
+		log.G(c.ctx).Warn("insecure_skip_verify is ignored because signature keys are configured")
+		return false
+	}
+	return true
+}
+
+// manifestMediaType returns the media type of the manifest for the containerd image.
+// The mediaType field is optional in OCI image manifests but always set in Docker manifests.
+func manifestMediaType(manifest *v1.Manifest) string {
+	if manifest.MediaType != "" {
+		return manifest.MediaType
+	}
+	return v1.MediaTypeImageManifest
+}
+
 // storeManifest saves the manifest in the content store with necessary labels
 func (c *Client) storeManifest(cs content.Store, cfgName, d, ref, cfgd, sld string, man []byte) (err error) {
 	pd := digest.Digest(d)
@@ -415,12 +584,26 @@ func (c *Client) storeStarlightHeader(cs content.Store, cfgName, ref, sld string
 	return nil
 }
 
-func (c *Client) Notify(proxyCfg string, reference name.Reference, insecure bool) error {
-	pc, _ := c.cfg.getProxy(proxyCfg)
+// newStarlightProxy creates a client of the proxy with the credentials and TLS settings in the proxy profile
+func (c *Client) newStarlightProxy(pc *ProxyConfig) (*proxy.StarlightProxy, error) {
 	p := proxy.NewStarlightProxy(c.ctx, pc.Protocol, pc.Address)
 	if pc.Username != "" {
 		p.SetAuth(pc.Username, pc.Password)
 	}
+	if pc.hasTLS() {
+		if err := p.SetTLS(pc.CACertificate, pc.ClientCertificate, pc.ClientKey); err != nil {
+			return nil, errors.Wrapf(err, "failed to load TLS configuration for proxy %s", pc.Address)
+		}
+	}
+	return p, nil
+}
+
+func (c *Client) Notify(proxyCfg string, reference name.Reference, insecure bool) error {
+	pc, _ := c.cfg.getProxy(proxyCfg)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		return err
+	}
 
 	// send message
 	if err := p.Notify(reference, insecure); err != nil {
@@ -432,9 +615,9 @@ func (c *Client) Notify(proxyCfg string, reference name.Reference, insecure bool
 
 func (c *Client) Ping(proxyCfg string) (int64, string, string, error) {
 	pc, _ := c.cfg.getProxy(proxyCfg)
-	p := proxy.NewStarlightProxy(c.ctx, pc.Protocol, pc.Address)
-	if pc.Username != "" {
-		p.SetAuth(pc.Username, pc.Password)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		return -1, "", "", err
 	}
 
 	// send message
@@ -449,9 +632,9 @@ func (c *Client) Ping(proxyCfg string) (int64, string, string, error) {
 func (c *Client) UploadTraces(proxyCfg string, tc *fs.TraceCollection) error {
 	// connect to proxy
 	pc, _ := c.cfg.getProxy(proxyCfg)
-	p := proxy.NewStarlightProxy(c.ctx, pc.Protocol, pc.Address)
-	if pc.Username != "" {
-		p.SetAuth(pc.Username, pc.Password)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		return err
 	}
 
 	// upload traces
@@ -468,6 +651,30 @@ func (c *Client) UploadTraces(proxyCfg string, tc *fs.TraceCollection) error {
 	return nil
 }
 
+// QueryProxy calls the read-only admin API of the proxy. query is "repositories", "tags" (of the repository)
+// or "image" (the layers of the reference, for the platform of the daemon if platform is empty).
+func (c *Client) QueryProxy(proxyCfg, query, repository, reference, platform string) (interface{}, error) {
+	pc, _ := c.cfg.getProxy(proxyCfg)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch query {
+	case "repositories":
+		return p.ListRepositories()
+	case "tags":
+		return p.ListTags(repository)
+	case "image":
+		if platform == "" {
+			platform = platforms.DefaultString()
+		}
+		return p.GetImageInfo(reference, platform)
+	default:
+		return nil, fmt.Errorf("unknown query %q", query)
+	}
+}
+
 type PullFinishedMessage struct {
 	img  *images.Image
 	meta *common.DeltaImageMetadata
@@ -496,7 +703,7 @@ func (c *Client) pullImageGrpc(ns, base, ref, proxy string, ret *chan PullFinish
 
 	// find base image
 	var baseImg containerd.Image
-	baseImg, err = c.FindBaseImage(ctr, base, ref)
+	baseImg, err = c.FindBaseImage(ctr, base, ref, platforms.DefaultString(), proxy)
 	if err != nil {
 		*ret <- PullFinishedMessage{nil, nil, "", errors.Wrapf(err, "failed to identify base image")}
 		return
@@ -520,6 +727,7 @@ func (c *Client) PullImage(
 	// init vars
 	is := ctr.ImageService()
 	localCtx := context.Background()
+	start := time.Now()
 
 	// check local image
 	reqFilter := getImageFilter(ref, false)
@@ -557,9 +765,10 @@ func (c *Client) PullImage(
 
 	// connect to proxy
 	pc, pcn := c.cfg.getProxy(proxyCfg)
-	p := proxy.NewStarlightProxy(c.ctx, pc.Protocol, pc.Address)
-	if pc.Username != "" {
-		p.SetAuth(pc.Username, pc.Password)
+	p, err := c.newStarlightProxy(pc)
+	if err != nil {
+		*ready <- PullFinishedMessage{nil, nil, "", errors.Wrapf(err, "failed to connect to proxy")}
+		return
 	}
 
 	baseRef := ""
@@ -567,12 +776,17 @@ func (c *Client) PullImage(
 		baseRef = fmt.Sprintf("%s@%s", base.Name(), base.Target().Digest)
 	}
 
+	// reuse the contents of all the other images on this worker
+	p.SetAvailableLayers(c.completedLayers())
+
 	// pull image
 	body, res, err := p.DeltaImage(baseRef, ref, platform, disableEarlyStart)
 	if err != nil {
 		*ready <- PullFinishedMessage{nil, nil, "", errors.Wrapf(err, "failed to pull image %s", ref)}
 		return
 	}
+	received := new(int64)
+	body = newCountingReadCloser(body, ref, received)
 	defer func() {
 		if body != nil {
 			err = body.Close()
@@ -637,13 +851,6 @@ func (c *Client) PullImage(
 		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to handle manifest")}
 		return
 	}
-	err = c.storeManifest(cs, pcn, res.Digest, ref,
-		manifest.Config.Digest.String(), res.StarlightDigest,
-		man)
-	if err != nil {
-		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to store manifest")}
-		return
-	}
 
 	// compute orignal image size using manifest
 	res.OriginalLength = res.ManifestSize
@@ -663,11 +870,6 @@ func (c *Client) PullImage(
 		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to handle config")}
 		return
 	}
-	err = c.storeConfig(cs, pcn, ref, manifest.Config.Digest, con)
-	if err != nil {
-		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to store config")}
-		return
-	}
 
 	// starlight header
 	buf, err = c.readBody(body, res.StarlightHeaderSize)
@@ -680,6 +882,33 @@ func (c *Client) PullImage(
 		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to handle starlight header")}
 		return
 	}
+
+	// nothing is written to the content store until all three match their digests
+	if c.skipVerify(pc) {
+		log.G(c.ctx).
+			WithField("proxy", pcn).
+			Warn("skipped verifying the delta image header")
+	} else if err = verifyDeltaHeader(res, manifest, man, con, sta); err != nil {
+		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to verify delta image")}
+		return
+	}
+	if err = c.verifySignature(res.Digest, star.Signature); err != nil {
+		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to verify signature of %s", ref)}
+		return
+	}
+
+	err = c.storeManifest(cs, pcn, res.Digest, ref,
+		manifest.Config.Digest.String(), res.StarlightDigest,
+		man)
+	if err != nil {
+		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to store manifest")}
+		return
+	}
+	err = c.storeConfig(cs, pcn, ref, manifest.Config.Digest, con)
+	if err != nil {
+		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to store config")}
+		return
+	}
 	err = c.storeStarlightHeader(cs, pcn, ref, res.StarlightDigest, sta)
 	if err != nil {
 		*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to store starlight header")}
@@ -691,7 +920,7 @@ func (c *Client) PullImage(
 	ctrImg, err = is.Create(localCtx, images.Image{
 		Name: ref,
 		Target: v1.Descriptor{
-			MediaType: util.ImageMediaTypeManifestV2,
+			MediaType: manifestMediaType(manifest),
 			Digest:    imageDigest,
 			Size:      int64(len(man)),
 		},
@@ -714,6 +943,13 @@ func (c *Client) PullImage(
 	// 3. create manager
 	// keep going and download layers
 	star.Init(ctr, c, c.ctx, c.cfg, false, manifest, imageConfig, imageDigest)
+	star.imageRef = ref
+	star.received, star.totalBytes = received, res.ContentLength
+
+	// a file accessed before the delta image reaches its content fetches the content from the proxy directly
+	star.SetFetchContent(func(layer string, ranges []common.ByteRange) (io.ReadCloser, error) {
+		return p.FetchContent(ref, platform, layer, ranges)
+	})
 
 	// create manager
 	c.managerMap[res.Digest] = star
@@ -722,6 +958,11 @@ func (c *Client) PullImage(
 		Info("client: added manager")
 	c.managerMapLock.Unlock()
 
+	// report the result to the watchers of the pull
+	defer func() {
+		star.finish(err)
+	}()
+
 	// check optimizer
 	// we should set optimizer before creating the filesystems
 	if c.defaultOptimizer {
@@ -759,6 +1000,7 @@ func (c *Client) PullImage(
 	// close(*ready)
 	//
 	// wola! we are done here.
+	pullDuration.WithLabelValues(ref, "ready").Observe(time.Since(start).Seconds())
 	*ready <- PullFinishedMessage{&ctrImg, res, baseRef, nil}
 
 	// 6. Extract file content
@@ -767,6 +1009,18 @@ func (c *Client) PullImage(
 		WithField("m", res.Digest).
 		Info("start decompressing content")
 
+	// resume the download if the connection to the proxy is interrupted,
+	// the offset of the body in the delta image is the total length minus the length of the body
+	headerLength := res.ContentLength - star.BodyLength
+	star.SetResume(func(offset int64) (io.ReadCloser, error) {
+		rc, err := p.ResumeDeltaImage(baseRef, ref, platform, disableEarlyStart,
+			res.StarlightDigest, headerLength+offset)
+		if err != nil {
+			return nil, err
+		}
+		return newCountingReadCloser(rc, ref, received), nil
+	})
+
 	if err = star.Extract(&body); err != nil {
 		if ready != nil { // second signal
 			*ready <- PullFinishedMessage{nil, nil, baseRef, errors.Wrapf(err, "failed to extract starlight image")}
@@ -777,6 +1031,7 @@ func (c *Client) PullImage(
 	log.G(c.ctx).
 		WithField("m", res.Digest).
 		Info("content decompression completed")
+	pullDuration.WithLabelValues(ref, "complete").Observe(time.Since(start).Seconds())
 
 	// 7. Mark image as completed
 	// mark as completed
@@ -804,6 +1059,42 @@ func (c *Client) PullImage(
 	}
 }
 
+// getImageName returns the name of the image using the manifest, it is used to label the metrics.
+// If there are more than one images, it returns the first one, if there is none, it returns the digest.
+func (c *Client) getImageName(ctr *containerd.Client, manifest digest.Digest) string {
+	list, err := ctr.ListImages(c.ctx, fmt.Sprintf("target.digest==%s", manifest))
+	if err != nil || len(list) == 0 {
+		return manifest.String()
+	}
+	names := make([]string, 0, len(list))
+	for _, img := range list {
+		names = append(names, img.Name())
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// FindManager returns the manager of the image pulled using the reference, the one still pulling is
+// preferred if there are more than one. It returns nil if the image has not been pulled or loaded.
+func (c *Client) FindManager(ref string) *Manager {
+	c.managerMapLock.Lock()
+	defer c.managerMapLock.Unlock()
+
+	var found *Manager
+	for _, m := range c.managerMap {
+		if m.imageRef != ref {
+			continue
+		}
+		select {
+		case <-m.done:
+			found = m
+		default:
+			return m
+		}
+	}
+	return found
+}
+
 // LoadImage loads image manifest from content store to the memory,
 // if it is in memory, return manager directly.
 //
@@ -865,6 +1156,7 @@ func (c *Client) LoadImage(ctr *containerd.Client, manifest digest.Digest) (mana
 
 	// 3. create manager
 	star.Init(ctr, c, c.ctx, c.cfg, true, man, cfg, manifest)
+	star.imageRef = c.getImageName(ctr, manifest)
 
 	// save to cache
 	c.managerMap[manifest.String()] = &star
@@ -913,6 +1205,8 @@ func (c *Client) Close() {
 	if c.cliServer != nil {
 		c.cliServer.Stop()
 	}
+	// metrics server
+	c.stopMetricsServer()
 
 	// filesystems
 	c.managerMapLock.Lock()
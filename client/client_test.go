@@ -8,17 +8,23 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd"
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/platforms"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/mc256/starlight/client/snapshotter"
+	"github.com/mc256/starlight/util"
+	"github.com/mc256/starlight/util/common"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
@@ -181,7 +187,7 @@ func TestClient_FindBaseImage(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	img, err := c.FindBaseImage(client, "", "registry.yuri.moe/starlight/redis:7.0.5")
+	img, err := c.FindBaseImage(client, "", "registry.yuri.moe/starlight/redis:7.0.5", platforms.DefaultString(), "")
 	if err != nil {
 		t.Error(err)
 		return
@@ -208,7 +214,7 @@ func TestClient_PullImageWithUpdate(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	base, err := c.FindBaseImage(client, "", "registry.yuri.moe/starlight/redis:7.0.5")
+	base, err := c.FindBaseImage(client, "", "registry.yuri.moe/starlight/redis:7.0.5", platforms.DefaultString(), "")
 	if err != nil {
 		t.Error(err)
 		return
@@ -399,3 +405,112 @@ func TestInsecureReference(t *testing.T) {
 	n2, _ := name.ParseReference("172.31.92.41:5000/redis:6.2.2-starlight", name.Insecure)
 	fmt.Println(n2)
 }
+
+type fakeImage struct {
+	containerd.Image
+	name    string
+	created time.Time
+}
+
+func (f *fakeImage) Name() string {
+	return f.name
+}
+
+func (f *fakeImage) Metadata() images.Image {
+	return images.Image{Name: f.name, CreatedAt: f.created}
+}
+
+func TestPickClosestImage(t *testing.T) {
+	now := time.Now()
+	list := []containerd.Image{
+		&fakeImage{name: "redis:6", created: now.Add(-2 * time.Hour)},
+		&fakeImage{name: "redis:7.0.4", created: now.Add(-time.Hour)},
+		&fakeImage{name: "redis:7.0.5", created: now},
+	}
+
+	cases := []struct {
+		overlaps []int64
+		expected string
+	}{
+		{[]int64{0, 0, 0}, "redis:7.0.5"},
+		{[]int64{100, 20, 0}, "redis:6"},
+		{[]int64{100, 100, 0}, "redis:7.0.4"},
+		{[]int64{0, 10, 10}, "redis:7.0.5"},
+	}
+	for _, c := range cases {
+		if img := pickClosestImage(list, c.overlaps); img.Name() != c.expected {
+			t.Errorf("%v: expected %s but got %s", c.overlaps, c.expected, img.Name())
+		}
+	}
+	if newestImage(nil) != nil {
+		t.Error("expected nil for empty list")
+	}
+}
+
+func TestManifestMediaType(t *testing.T) {
+	cases := []struct {
+		manifest string
+		expected string
+	}{
+		{`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json"}`, util.ImageMediaTypeManifestV2},
+		{`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`, v1.MediaTypeImageManifest},
+		{`{"schemaVersion":2}`, v1.MediaTypeImageManifest},
+	}
+	for _, c := range cases {
+		var m v1.Manifest
+		if err := json.Unmarshal([]byte(c.manifest), &m); err != nil {
+			t.Fatal(err)
+		}
+		if mt := manifestMediaType(&m); mt != c.expected {
+			t.Errorf("%s: expected %s but got %s", c.manifest, c.expected, mt)
+		}
+	}
+}
+
+func TestVerifyDeltaHeader(t *testing.T) {
+	con := []byte(`{"architecture":"amd64","os":"linux"}`)
+	man := []byte(fmt.Sprintf(`{"schemaVersion":2,"config":{"digest":"%s","size":%d}}`, digest.FromBytes(con), len(con)))
+	sta := []byte(`{"s":null,"d":null}`)
+	var manifest v1.Manifest
+	if err := json.Unmarshal(man, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	res := &common.DeltaImageMetadata{
+		Digest:          digest.FromBytes(man).String(),
+		StarlightDigest: digest.FromBytes(sta).String(),
+	}
+	if err := verifyDeltaHeader(res, &manifest, man, con, sta); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := []byte("tampered")
+	for name, err := range map[string]error{
+		"manifest":         verifyDeltaHeader(res, &manifest, tampered, con, sta),
+		"config":           verifyDeltaHeader(res, &manifest, man, tampered, sta),
+		"starlight header": verifyDeltaHeader(res, &manifest, man, con, tampered),
+	} {
+		if !errors.Is(err, ErrContentIntegrity) {
+			t.Errorf("%s: expected integrity error but got %v", name, err)
+		}
+	}
+
+	if err := verifyDeltaHeader(&common.DeltaImageMetadata{Digest: "sha256:abc"}, &manifest, man, con, sta); err == nil {
+		t.Error("expected error for an invalid digest")
+	}
+}
+
+func TestClient_SkipVerify(t *testing.T) {
+	c := &Client{ctx: context.Background(), cfg: &Configuration{}}
+	if !c.skipVerify(&ProxyConfig{InsecureSkipVerify: true}) {
+		t.Error("expected the header not to be verified")
+	}
+	if c.skipVerify(&ProxyConfig{}) {
+		t.Error("expected the header to be verified")
+	}
+
+	// the signature only covers the manifest digest
+	c.cfg.SignatureKeys = []string{"key.pem"}
+	if c.skipVerify(&ProxyConfig{InsecureSkipVerify: true}) {
+		t.Error("expected the header to be verified if signature keys are configured")
+	}
+}
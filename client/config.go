@@ -19,6 +19,20 @@ type ProxyConfig struct {
 	// Auth
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+
+	// TLS, CA bundle to verify the proxy (system roots if empty), and client certificate for mutual TLS
+	CACertificate     string `json:"ca_cert,omitempty"`
+	ClientCertificate string `json:"client_cert,omitempty"`
+	ClientKey         string `json:"client_key,omitempty"`
+
+	// InsecureSkipVerify disables checking the manifest, config and Starlight header of the delta images
+	// against their digests, it should only be used for debugging. It is ignored if SignatureKeys is set,
+	// because the signature only covers the manifest digest.
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+func (pc *ProxyConfig) hasTLS() bool {
+	return pc.CACertificate != "" || pc.ClientCertificate != "" || pc.ClientKey != ""
 }
 
 type Configuration struct {
@@ -41,6 +55,24 @@ type Configuration struct {
 	FileSystemRoot string `json:"fs_root"`
 
 	Proxies map[string]*ProxyConfig `json:"configs"`
+
+	// ResumeAttempts is the number of times the daemon tries to resume an interrupted delta image download
+	// for the same content, set to 0 to disable resuming
+	ResumeAttempts int `json:"resume_attempts"`
+
+	// OnDemandFetchDelay is the time in milliseconds a file access waits for the delta image before the daemon
+	// fetches the content of the file from the proxy out of order, set to 0 to disable on-demand fetching
+	OnDemandFetchDelay int `json:"on_demand_fetch_delay"`
+
+	// SignatureKeys are the PEM encoded public keys trusted to sign the images (cosign-style signatures).
+	// If it is set, the daemon only pulls the images with a signature attestation signed by one of the keys.
+	// The signature covers the manifest and the config, but not the file contents: they are checked against
+	// the digests in the Starlight header, which is built by the proxy, so the proxy must be trusted.
+	SignatureKeys []string `json:"signature_keys,omitempty"`
+
+	// MetricsAddress is the address of the HTTP listener serving the Prometheus metrics and the status of
+	// the daemon (e.g. ":8081"), leave it empty to disable
+	MetricsAddress string `json:"metrics_address,omitempty"`
 }
 
 func (c *Configuration) getProxy(name string) (pc *ProxyConfig, key string) {
@@ -138,6 +170,9 @@ func NewConfig() *Configuration {
 		TracesDir:      "/var/lib/starlight/traces",
 		Namespace:      "default",
 		ClientId:       uuid.New().String(),
+		ResumeAttempts: 3,
+
+		OnDemandFetchDelay: 200,
 
 		Proxies: map[string]*ProxyConfig{
 			"starlight-shared": {
@@ -0,0 +1,123 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// partialFile serves the reads of a file that is still being extracted. A read blocks until the
+// chunks covering the requested range are on disk, instead of waiting for the entire file.
+type partialFile struct {
+	mu   sync.Mutex
+	fd   int
+	path string
+	size int64
+	file ReceivedFile
+
+	// log records the access to the file with the time the first read has waited for the chunks,
+	// or without waiting when it is released before any read
+	log    func(access, complete time.Time)
+	opened time.Time
+	logged bool
+}
+
+var _ = (fs.FileReader)((*partialFile)(nil))
+
+func (f *partialFile) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	if off >= f.size || len(dest) == 0 {
+		return fuse.ReadResultData(nil), 0
+	}
+	size := int64(len(dest))
+	if off+size > f.size {
+		size = f.size - off
+	}
+	access := time.Now()
+	f.file.WaitForRange(off, size)
+	f.logAccess(access, time.Now())
+
+	fd, errno := f.open()
+	if errno != 0 {
+		return nil, errno
+	}
+	n, err := syscall.Pread(fd, dest[:size], off)
+	if err != nil {
+		return nil, fs.ToErrno(err)
+	}
+	return fuse.ReadResultData(dest[:n]), 0
+}
+
+// logAccess logs the first access to the file
+func (f *partialFile) logAccess(access, complete time.Time) {
+	f.mu.Lock()
+	if f.logged || f.log == nil {
+		f.mu.Unlock()
+		return
+	}
+	f.logged = true
+	f.mu.Unlock()
+	f.log(access, complete)
+}
+
+// open opens the extracted file, it can only be opened after the first chunk has been written
+func (f *partialFile) open() (int, syscall.Errno) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fd >= 0 {
+		return f.fd, 0
+	}
+	fd, err := syscall.Open(f.path, syscall.O_RDONLY, 0)
+	if err != nil {
+		return -1, fs.ToErrno(err)
+	}
+	f.fd = fd
+	return fd, 0
+}
+
+var _ = (fs.FileReleaser)((*partialFile)(nil))
+
+func (f *partialFile) Release(ctx context.Context) syscall.Errno {
+	f.logAccess(f.opened, f.opened)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fd >= 0 {
+		err := syscall.Close(f.fd)
+		f.fd = -1
+		return fs.ToErrno(err)
+	}
+	return 0
+}
+
+func newPartialFile(path string, file ReceivedFile, log func(access, complete time.Time)) *partialFile {
+	var attr fuse.Attr
+	_ = file.GetAttr(&attr)
+	return &partialFile{
+		fd:     -1,
+		path:   path,
+		size:   int64(attr.Size),
+		file:   file,
+		log:    log,
+		opened: time.Now(),
+	}
+}
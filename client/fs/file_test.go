@@ -0,0 +1,134 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// growingFile is a file of which the first ready bytes are extracted
+type growingFile struct {
+	ReceivedFile
+	size  int64
+	ready chan int64
+	waits []int64
+}
+
+func (f *growingFile) GetAttr(out *fuse.Attr) syscall.Errno {
+	out.Size = uint64(f.size)
+	return 0
+}
+
+func (f *growingFile) WaitForRange(offset, size int64) {
+	f.waits = append(f.waits, offset+size)
+	for r := range f.ready {
+		if r >= offset+size {
+			return
+		}
+	}
+}
+
+func TestPartialFile_Read(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "content")
+	content := []byte("0123456789abcdef")
+	rf := &growingFile{size: int64(len(content)), ready: make(chan int64, 2)}
+	pf := newPartialFile(p, rf, nil)
+	defer pf.Release(context.Background())
+
+	// first half is extracted
+	if err := os.WriteFile(p, content[:8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf.ready <- 8
+	buf := make([]byte, 4)
+	res, errno := pf.Read(context.Background(), buf, 2)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if b, _ := res.Bytes(nil); string(b) != "2345" {
+		t.Errorf("expected 2345 but got %s", b)
+	}
+
+	// the read of the second half blocks until it is extracted
+	done := make(chan string)
+	go func() {
+		buf := make([]byte, 100)
+		res, _ := pf.Read(context.Background(), buf, 12)
+		b, _ := res.Bytes(nil)
+		done <- string(b)
+	}()
+	select {
+	case <-done:
+		t.Fatal("read should wait for the chunk")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := os.WriteFile(p, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rf.ready <- 16
+	if b := <-done; b != "cdef" {
+		t.Errorf("expected cdef but got %s", b)
+	}
+	if rf.waits[1] != 16 {
+		t.Errorf("expected the read to be clipped to the file size, waited for %d", rf.waits[1])
+	}
+}
+
+func TestPartialFile_LogWait(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "content")
+	content := []byte("0123456789abcdef")
+	rf := &growingFile{size: int64(len(content)), ready: make(chan int64, 2)}
+	var waits []time.Duration
+	pf := newPartialFile(p, rf, func(access, complete time.Time) {
+		waits = append(waits, complete.Sub(access))
+	})
+
+	// the first read blocks until the file is extracted
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = os.WriteFile(p, content, 0644)
+		rf.ready <- 16
+	}()
+	if _, errno := pf.Read(context.Background(), make([]byte, 4), 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	rf.ready <- 16
+	if _, errno := pf.Read(context.Background(), make([]byte, 4), 4); errno != 0 {
+		t.Fatal(errno)
+	}
+	pf.Release(context.Background())
+	if len(waits) != 1 || waits[0] < 50*time.Millisecond {
+		t.Errorf("expected the wait of the first read to be logged once but got %v", waits)
+	}
+
+	// opened without reading
+	waits = nil
+	pf = newPartialFile(p, rf, func(access, complete time.Time) {
+		waits = append(waits, complete.Sub(access))
+	})
+	pf.Release(context.Background())
+	if len(waits) != 1 || waits[0] != 0 {
+		t.Errorf("expected the access to be logged on release but got %v", waits)
+	}
+}
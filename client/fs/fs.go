@@ -34,6 +34,10 @@ type ReceivedFile interface {
 	GetRealPath() string
 	WaitForReady()
 
+	// WaitForRange blocks until the bytes in [offset, offset+size) of the file are available,
+	// it returns earlier than WaitForReady if the file is large and the range is in the first chunks
+	WaitForRange(offset, size int64)
+
 	// IsReferencingRequestedImage returns stack number where the actual content located
 	// if the file is available in the local filesystem then yes is false
 	IsReferencingRequestedImage() (stack int64, yes bool)
@@ -189,11 +193,25 @@ func (n *StarlightFsNode) Open(ctx context.Context, flags uint32) (fs.FileHandle
 	}
 
 	access := time.Now()
+	name := n.GetName()
+	if !n.IsReady() && flags&(syscall.O_WRONLY|syscall.O_RDWR) == 0 {
+		// read-only, the reads are served as soon as the chunks they need are extracted,
+		// the time the first read waits for them is logged
+		log.G(ctx).WithFields(logrus.Fields{
+			"f":  name,
+			"_s": n.instance.stack,
+			"_r": r,
+		}).Trace("open partial")
+
+		return newPartialFile(r, n.ReceivedFile, func(access, complete time.Time) {
+			n.log(name, access, complete)
+		}), fuse.FOPEN_KEEP_CACHE, 0
+	}
+
 	if !n.IsReady() {
 		n.WaitForReady()
 	}
 	complete := time.Now()
-	name := n.GetName()
 	n.log(name, access, complete)
 
 	log.G(ctx).WithFields(logrus.Fields{
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -43,6 +44,8 @@ func (s *StarlightDaemonAPIServer) AddProxyProfile(ctx context.Context, req *pb.
 		Address:  req.Address,
 		Username: req.Username,
 		Password: req.Password,
+
+		InsecureSkipVerify: req.InsecureSkipVerify,
 	}
 	if err := s.client.cfg.SaveConfig(); err != nil {
 		log.G(s.client.ctx).WithError(err).Errorf("failed to save config")
@@ -70,6 +73,8 @@ func (s *StarlightDaemonAPIServer) GetProxyProfiles(ctx context.Context, req *pb
 			Name:     name,
 			Protocol: proxy.Protocol,
 			Address:  proxy.Address,
+
+			InsecureSkipVerify: proxy.InsecureSkipVerify,
 		})
 	}
 	return &pb.GetProxyProfilesResponse{
@@ -152,6 +157,69 @@ func (s *StarlightDaemonAPIServer) PullImage(ctx context.Context, ref *pb.ImageR
 	}, nil
 }
 
+// WatchPull streams the progress of the image being pulled until the pull completes or fails
+func (s *StarlightDaemonAPIServer) WatchPull(req *pb.WatchPullRequest, stream pb.Daemon_WatchPullServer) error {
+	log.G(s.client.ctx).WithFields(logrus.Fields{
+		"ref": req.Reference,
+	}).Debug("grpc: watch pull")
+
+	m := s.client.FindManager(req.Reference)
+	if m == nil {
+		return stream.Send(&pb.PullProgress{
+			Reference: req.Reference,
+			Completed: true,
+			Success:   false,
+			Message:   fmt.Sprintf("image %s is not being pulled", req.Reference),
+		})
+	}
+
+	interval := time.Duration(req.Interval) * time.Millisecond
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	_, _, lastReceived, _ := m.Progress()
+	last := time.Now()
+	progress := func() *pb.PullProgress {
+		extracted, total, received, totalBytes := m.Progress()
+		now := time.Now()
+		rate := 0.0
+		if d := now.Sub(last).Seconds(); d > 0 {
+			rate = float64(received-lastReceived) / d
+		}
+		last, lastReceived = now, received
+		return &pb.PullProgress{
+			Reference:         req.Reference,
+			Manifest:          m.manifestDigest.String(),
+			ExtractedContents: extracted,
+			TotalContents:     total,
+			ReceivedBytes:     received,
+			TotalBytes:        totalBytes,
+			Rate:              rate,
+		}
+	}
+
+	for {
+		select {
+		case <-m.Done():
+			p := progress()
+			p.Completed, p.Success, p.Message = true, true, "ok"
+			if err := m.PullError(); err != nil {
+				p.Success, p.Message = false, err.Error()
+			}
+			return stream.Send(p)
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+			if err := stream.Send(progress()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func (s *StarlightDaemonAPIServer) SetOptimizer(ctx context.Context, req *pb.OptimizeRequest) (*pb.OptimizeResponse, error) {
 	okRes, failRes := make(map[string]string), make(map[string]string)
 	log.G(s.client.ctx).WithFields(logrus.Fields{
@@ -284,6 +352,35 @@ func (s *StarlightDaemonAPIServer) PingTest(ctx context.Context, req *pb.PingReq
 	}, nil
 }
 
+func (s *StarlightDaemonAPIServer) QueryProxy(ctx context.Context, req *pb.ProxyQueryRequest) (*pb.ProxyQueryResponse, error) {
+	log.G(s.client.ctx).WithFields(logrus.Fields{
+		"profile": req.ProxyConfig,
+		"query":   req.Query,
+	}).Debug("grpc: query proxy")
+
+	res, err := s.client.QueryProxy(req.ProxyConfig, req.Query, req.Repository, req.Reference, req.Platform)
+	if err != nil {
+		return &pb.ProxyQueryResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		return &pb.ProxyQueryResponse{
+			Success: false,
+			Message: err.Error(),
+		}, nil
+	}
+
+	return &pb.ProxyQueryResponse{
+		Success: true,
+		Message: "ok",
+		Result:  buf,
+	}, nil
+}
+
 func newStarlightDaemonAPIServer(client *Client) *StarlightDaemonAPIServer {
 	c := &StarlightDaemonAPIServer{client: client}
 	return c
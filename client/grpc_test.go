@@ -0,0 +1,82 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/mc256/starlight/client/api"
+	"github.com/mc256/starlight/util/receive"
+	"google.golang.org/grpc"
+)
+
+type fakeWatchPullServer struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*pb.PullProgress
+}
+
+func (f *fakeWatchPullServer) Send(p *pb.PullProgress) error {
+	f.sent = append(f.sent, p)
+	return nil
+}
+
+func (f *fakeWatchPullServer) Context() context.Context {
+	return f.ctx
+}
+
+func TestStarlightDaemonAPIServer_WatchPull(t *testing.T) {
+	received := int64(0)
+	m := &Manager{imageRef: "redis:7", received: &received, totalBytes: 1000, done: make(chan interface{})}
+	m.Contents = []*receive.Content{{}, {}}
+	c := &Client{ctx: ctx, cfg: NewConfig(), managerMap: map[string]*Manager{"sha256:aaaa": m}}
+	s := newStarlightDaemonAPIServer(c)
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		atomic.StoreInt64(&received, 400)
+		atomic.StoreInt64(&m.extracted, 1)
+		time.Sleep(30 * time.Millisecond)
+		m.finish(errors.New("connection reset"))
+	}()
+
+	stream := &fakeWatchPullServer{ctx: context.Background()}
+	if err := s.WatchPull(&pb.WatchPullRequest{Reference: "redis:7", Interval: 10}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) < 2 {
+		t.Fatalf("expected progress updates but got %d", len(stream.sent))
+	}
+	last := stream.sent[len(stream.sent)-1]
+	if !last.Completed || last.Success || last.Message != "connection reset" ||
+		last.TotalContents != 2 || last.ExtractedContents != 1 || last.ReceivedBytes != 400 || last.TotalBytes != 1000 {
+		t.Errorf("unexpected final progress %v", last)
+	}
+
+	// unknown image
+	stream = &fakeWatchPullServer{ctx: context.Background()}
+	if err := s.WatchPull(&pb.WatchPullRequest{Reference: "mariadb:10"}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.sent) != 1 || !stream.sent[0].Completed || stream.sent[0].Success {
+		t.Errorf("expected failure for unknown image but got %v", stream.sent)
+	}
+}
@@ -7,7 +7,6 @@ package client
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,6 +16,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/containerd/containerd"
@@ -25,6 +25,7 @@ import (
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/mc256/starlight/client/fs"
 	"github.com/mc256/starlight/client/snapshotter"
+	"github.com/mc256/starlight/util/common"
 	"github.com/mc256/starlight/util/receive"
 	"github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/identity"
@@ -32,6 +33,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrContentIntegrity is returned if the digest of an extracted chunk or content does not match the ToC,
+// the delta image could have been corrupted or tampered with
+var ErrContentIntegrity = errors.New("content integrity check failed")
+
 // Manager should be unmarshalled from a json file and then Populate() should be called to populate other fields
 type Manager struct {
 	receive.DeltaBundle
@@ -65,6 +70,27 @@ type Manager struct {
 	operator *snapshotter.Operator
 
 	fs map[int64]*fs.Instance
+
+	// resume re-opens the delta image body at the given offset (relative to the beginning of the body),
+	// it allows Extract to continue after the connection to the proxy is interrupted
+	resume func(offset int64) (io.ReadCloser, error)
+	// fetch requests byte ranges of a compressed layer of the requested image from the proxy,
+	// it allows the contents to be extracted out of order
+	fetch func(layer string, ranges []common.ByteRange) (io.ReadCloser, error)
+
+	// imageRef is the image reference used to label the metrics
+	imageRef string
+	// extracted is the number of contents that have been extracted, use atomic to access it
+	extracted int64
+	// received is the number of bytes of the delta image received from the proxy, use atomic to access it.
+	// It is nil if the image is loaded from the content store.
+	received   *int64
+	totalBytes int64
+
+	// done is closed once the pull has completed or failed, pullErr is the reason of the failure
+	done     chan interface{}
+	doneOnce sync.Once
+	pullErr  error
 }
 
 func (m *Manager) String() string {
@@ -100,6 +126,8 @@ func (m *Manager) LookUpFile(stack int64, filename string) fs.ReceivedFile {
 }
 
 func (m *Manager) LogTrace(stack int64, filename string, access, complete time.Time) {
+	openWaitDuration.WithLabelValues(m.imageRef).Observe(complete.Sub(access).Seconds())
+
 	m.tracerLock.Lock()
 	defer m.tracerLock.Unlock()
 	if m.tracer != nil {
@@ -120,66 +148,41 @@ func (m *Manager) Extract(r *io.ReadCloser) (err error) {
 		return fmt.Errorf("cannot call extract if it has completed")
 	}
 
-	var (
-		p, pp string
-	)
 	// Extract Contents
 	for i, c := range m.Contents {
-		// skip the layer if it already exists
-		if m.ignoreStack(c.Stack) {
-			totalCompressedSize := int64(0)
-			for _, ch := range c.Chunks {
-				totalCompressedSize += ch.CompressedSize
-			}
-			if n, err := io.CopyN(io.Discard, *r, totalCompressedSize); err != nil || n != totalCompressedSize {
-				return errors.Wrapf(err, "failed to discard %d bytes", totalCompressedSize)
+		for attempt := 0; ; attempt++ {
+			retryable, err := m.extractContent(i, c, r)
+			if err == nil {
+				atomic.AddInt64(&m.extracted, 1)
+				break
 			}
-			continue
-		}
-
-		// regular extraction
-		p = m.GetPathByStack(c.Stack)
-		if err := os.MkdirAll(filepath.Join(p, c.GetBaseDir()), 0755); err != nil {
-			return errors.Wrapf(err, "failed to create directory %s", filepath.Join(p, c.GetBaseDir()))
-		}
-		pp = c.GetPath()
-		err = func() error {
-			f, err := os.Create(filepath.Join(p, pp))
-			isClosed := false
-			defer func() {
-				if !isClosed {
-					_ = f.Close()
-				}
-			}()
-			if err != nil {
-				return errors.Wrapf(err, "failed to create file %s", filepath.Join(p, c.GetPath()))
+			if errors.Is(err, ErrContentIntegrity) {
+				log.G(m.ctx).
+					WithField("content", i).
+					WithField("digest", c.Digest).
+					WithError(err).
+					Error("delta image is corrupted")
+				m.markIncomplete(c)
+				return err
 			}
-			for idx, ch := range c.Chunks {
-				b := bytes.NewBuffer(make([]byte, 0, ch.CompressedSize))
-				if n, err := io.CopyN(b, *r, ch.CompressedSize); err != nil || n != ch.CompressedSize {
-					return errors.Wrapf(err, "failed to read content %d-%d at %d", i, idx, c.Offset)
-				}
-				gr, err := gzip.NewReader(b)
-				if err != nil {
-					return errors.Wrapf(err, "failed to create gzip reader for content %d-%d at %d", i, idx, c.Offset)
-				}
-				if _, err := io.CopyN(f, gr, ch.ChunkSize); err != nil {
-					return errors.Wrapf(err, "failed to write content %d-%d at %d", i, idx, c.Offset)
-				}
+			if !retryable || m.resume == nil || attempt >= m.cfg.ResumeAttempts {
+				return err
 			}
-			_ = f.Close()
-			isClosed = true
-			close(c.Signal) // send out signal that this content is ready
-			return nil
-		}()
 
-		if err != nil {
-			return err
+			log.G(m.ctx).
+				WithField("content", i).
+				WithField("offset", c.Offset).
+				WithField("attempt", attempt+1).
+				WithError(err).
+				Warn("delta image interrupted, resuming")
+
+			_ = (*r).Close()
+			body, rerr := m.resume(c.Offset)
+			if rerr != nil {
+				return errors.Wrapf(rerr, "failed to resume delta image at %d (%v)", c.Offset, err)
+			}
+			*r = body
 		}
-		log.G(m.ctx).
-			WithField("l", p).
-			WithField("f", pp).
-			Trace("extracted")
 	}
 
 	complete := time.Now()
@@ -198,6 +201,263 @@ func (m *Manager) Extract(r *io.ReadCloser) (err error) {
 	return nil
 }
 
+// extractContent reads content c from the delta image body and saves it to the local filesystem.
+// retryable is true if the error is caused by reading the body, in that case the content can be
+// extracted again after resuming the body at c.Offset.
+func (m *Manager) extractContent(i int, c *receive.Content, r *io.ReadCloser) (retryable bool, err error) {
+	// skip the layer if it already exists
+	if m.ignoreStack(c.Stack) {
+		totalCompressedSize := int64(0)
+		for _, ch := range c.Chunks {
+			totalCompressedSize += ch.CompressedSize
+		}
+		if n, err := io.CopyN(io.Discard, *r, totalCompressedSize); err != nil || n != totalCompressedSize {
+			return true, errors.Wrapf(err, "failed to discard %d bytes", totalCompressedSize)
+		}
+		return false, nil
+	}
+
+	// regular extraction
+	f, err := m.openContent(c)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	for idx, ch := range c.Chunks {
+		if c.IsChunkReady(idx) {
+			// extracted before the body was resumed, or fetched on demand
+			if n, err := io.CopyN(io.Discard, *r, ch.CompressedSize); err != nil || n != ch.CompressedSize {
+				return true, errors.Wrapf(err, "failed to skip content %d-%d at %d", i, idx, c.Offset)
+			}
+			continue
+		}
+		b := bytes.NewBuffer(make([]byte, 0, ch.CompressedSize))
+		if n, err := io.CopyN(b, *r, ch.CompressedSize); err != nil || n != ch.CompressedSize {
+			return true, errors.Wrapf(err, "failed to read content %d-%d at %d", i, idx, c.Offset)
+		}
+		if err = m.writeChunk(f, c, idx, b.Bytes()); err != nil {
+			return false, errors.Wrapf(err, "failed to extract content %d-%d at %d", i, idx, c.Offset)
+		}
+	}
+	if !c.IsReady() {
+		if err = m.verifyContent(c); err != nil {
+			return false, errors.Wrapf(err, "failed to verify content %d at %d", i, c.Offset)
+		}
+		c.SetReady() // send out signal that this content is ready
+	}
+
+	log.G(m.ctx).
+		WithField("l", m.GetPathByStack(c.Stack)).
+		WithField("f", c.GetPath()).
+		Trace("extracted")
+	return false, nil
+}
+
+// openContent opens the file of the content for writing. The file is not truncated, the chunks extracted
+// before an interruption or fetched on demand could have been read already.
+func (m *Manager) openContent(c *receive.Content) (*os.File, error) {
+	p := m.GetPathByStack(c.Stack)
+	if err := os.MkdirAll(filepath.Join(p, c.GetBaseDir()), 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory %s", filepath.Join(p, c.GetBaseDir()))
+	}
+	f, err := os.OpenFile(filepath.Join(p, c.GetPath()), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create file %s", filepath.Join(p, c.GetPath()))
+	}
+	return f, nil
+}
+
+// writeChunk decompresses chunk idx of the content and writes it to f, the chunk is skipped if it has
+// already been extracted by the delta image or an on-demand fetch
+func (m *Manager) writeChunk(f *os.File, c *receive.Content, idx int, compressed []byte) error {
+	if c.IsChunkReady(idx) {
+		return nil
+	}
+	ch := c.Chunks[idx]
+	buf, err := common.DecompressChunk(ch.Compression, compressed, ch.ChunkSize)
+	if err != nil {
+		return err
+	}
+	if err = verifyDigest(ch.ChunkDigest, bytes.NewReader(buf)); err != nil {
+		return errors.Wrapf(err, "chunk at %d", ch.ChunkOffset)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if c.IsChunkReady(idx) {
+		return nil
+	}
+	if _, err = f.WriteAt(buf, ch.ChunkOffset); err != nil {
+		return errors.Wrapf(err, "failed to write chunk")
+	}
+	c.SetChunkReady(idx) // the chunk can be read before the rest of the content
+	return nil
+}
+
+// verifyContent checks the digest of the entire content once all of its chunks are on disk
+func (m *Manager) verifyContent(c *receive.Content) error {
+	f, err := os.Open(filepath.Join(m.GetPathByStack(c.Stack), c.GetPath()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to open content")
+	}
+	defer f.Close()
+	return verifyDigest(c.Digest, f)
+}
+
+// verifyDigest returns ErrContentIntegrity if the digest of r does not match expected.
+// The digests are empty if the layer was converted by older versions, they are not verified.
+func verifyDigest(expected string, r io.Reader) error {
+	if expected == "" {
+		return nil
+	}
+	d, err := digest.Parse(expected)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse digest %q", expected)
+	}
+	v := d.Verifier()
+	if _, err = io.Copy(v, r); err != nil {
+		return errors.Wrapf(err, "failed to compute digest")
+	}
+	if !v.Verified() {
+		return fmt.Errorf("%w: expected %s", ErrContentIntegrity, d)
+	}
+	return nil
+}
+
+// markIncomplete removes the corrupted content and the completion marks of the layers being extracted,
+// so that ScanExistingFilesystems removes the layer directories instead of loading them
+func (m *Manager) markIncomplete(c *receive.Content) {
+	_ = os.Remove(filepath.Join(m.GetPathByStack(c.Stack), c.GetPath()))
+	for idx, layer := range m.Destination.Layers {
+		if m.ignoreStack(int64(idx)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(layer.Local, "completed.json")); err != nil && !os.IsNotExist(err) {
+			log.G(m.ctx).
+				WithField("layer", layer.Hash).
+				WithError(err).
+				Warn("failed to mark layer as incomplete")
+		}
+	}
+}
+
+// fetchContent fetches the chunks of content i that have not been extracted from the proxy, so that a file
+// accessed before the delta image reaches it does not wait for the entire delta image.
+// The delta image skips these chunks once it reaches the content.
+func (m *Manager) fetchContent(i int, c *receive.Content) {
+	idxs := make([]int, 0, len(c.Chunks))
+	ranges := make([]common.ByteRange, 0, len(c.Chunks))
+	for idx, ch := range c.Chunks {
+		if !c.IsChunkReady(idx) {
+			idxs = append(idxs, idx)
+			ranges = append(ranges, common.ByteRange{Offset: ch.Offset, Length: ch.CompressedSize})
+		}
+	}
+	if len(idxs) == 0 {
+		return
+	}
+
+	start := time.Now()
+	err := func() error {
+		body, err := m.fetch(m.Destination.Layers[c.Stack].Hash, ranges)
+		if err != nil {
+			return errors.Wrapf(err, "failed to request content")
+		}
+		defer body.Close()
+
+		f, err := m.openContent(c)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		for _, idx := range idxs {
+			b := make([]byte, c.Chunks[idx].CompressedSize)
+			if _, err = io.ReadFull(body, b); err != nil {
+				return errors.Wrapf(err, "failed to read content %d-%d", i, idx)
+			}
+			if err = m.writeChunk(f, c, idx, b); err != nil {
+				return errors.Wrapf(err, "failed to extract content %d-%d", i, idx)
+			}
+		}
+		if c.IsAllChunksReady() {
+			if err = m.verifyContent(c); err != nil {
+				return err
+			}
+			c.SetReady()
+		}
+		return nil
+	}()
+
+	if err != nil {
+		onDemandFetches.WithLabelValues(m.imageRef, "failure").Inc()
+		// not fatal, the delta image delivers the content eventually
+		log.G(m.ctx).
+			WithField("content", i).
+			WithError(err).
+			Warn("failed to fetch content on demand")
+		return
+	}
+	onDemandFetches.WithLabelValues(m.imageRef, "success").Inc()
+	log.G(m.ctx).
+		WithField("content", i).
+		WithField("chunks", len(idxs)).
+		WithField("duration", time.Since(start)).
+		Debug("fetched content on demand")
+}
+
+// finish marks the pull as completed, err is nil if the pull succeeded
+func (m *Manager) finish(err error) {
+	m.doneOnce.Do(func() {
+		m.pullErr = err
+		close(m.done)
+	})
+}
+
+// Done returns a channel that is closed once the pull has completed or failed
+func (m *Manager) Done() <-chan interface{} {
+	return m.done
+}
+
+// PullError returns the reason of the failure, it should be called after Done is closed
+func (m *Manager) PullError() error {
+	return m.pullErr
+}
+
+// Progress returns the number of extracted contents and the number of bytes received
+func (m *Manager) Progress() (extracted, total, received, totalBytes int64) {
+	extracted, total = atomic.LoadInt64(&m.extracted), int64(len(m.Contents))
+	if m.received == nil {
+		return extracted, total, m.BodyLength, m.BodyLength
+	}
+	return extracted, total, atomic.LoadInt64(m.received), m.totalBytes
+}
+
+// SetFetchContent sets the function that fetches byte ranges of a compressed layer of the requested image from
+// the proxy. A file access that waits longer than the configured delay fetches the content of the file with it,
+// so that the order of the contents in the delta image is a hint rather than a hard dependency.
+func (m *Manager) SetFetchContent(fetch func(layer string, ranges []common.ByteRange) (io.ReadCloser, error)) {
+	m.fetch = fetch
+	if m.cfg == nil || m.cfg.OnDemandFetchDelay <= 0 {
+		return
+	}
+	delay := time.Duration(m.cfg.OnDemandFetchDelay) * time.Millisecond
+	for i, c := range m.Contents {
+		if m.ignoreStack(c.Stack) || c.Signal == nil {
+			continue
+		}
+		i, c := i, c
+		c.SetOnDemand(delay, func() { m.fetchContent(i, c) })
+	}
+}
+
+// SetResume sets the function that re-opens the delta image body at an offset (relative to the
+// beginning of the body), Extract uses it to resume an interrupted download.
+func (m *Manager) SetResume(resume func(offset int64) (io.ReadCloser, error)) {
+	m.resume = resume
+}
+
 func (m *Manager) PrepareDirectories(c *Client) error {
 	// create directories
 	m.completedStack = make([]bool, len(m.stackSerialMap))
@@ -301,16 +561,30 @@ func (m *Manager) Init(ctr *containerd.Client, client *Client, ctx context.Conte
 			m.layers[layer.Serial] = layer
 		}
 	}
+	// other layers on the client, the requested files could reference their contents
+	for _, layer := range m.Available {
+		if _, has := m.layers[layer.Serial]; has {
+			continue
+		}
+		layer.Local = m.getDirectory(layer.Hash)
+		m.layers[layer.Serial] = layer
+	}
+
+	m.done = make(chan interface{})
+	if ready {
+		m.extracted = int64(len(m.Contents))
+		m.finish(nil)
+	}
 
 	// create a list of signals
 	if !ready {
 		for _, content := range m.Contents {
-			content.Signal = make(chan interface{})
+			content.InitSignals()
 		}
 		// create filesystem template
 		for _, f := range m.RequestedFiles {
 			if f.InPayload() {
-				f.Ready = &m.Contents[f.PayloadOrder].Signal
+				f.SetContent(m.Contents[f.PayloadOrder])
 			} else {
 				f.Ready = nil
 			}
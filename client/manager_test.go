@@ -6,6 +6,8 @@
 package client
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -18,6 +20,8 @@ import (
 	"github.com/containerd/containerd"
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/mc256/starlight/client/fs"
+	"github.com/mc256/starlight/util/common"
+	"github.com/mc256/starlight/util/receive"
 	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
@@ -81,6 +85,134 @@ func TestManager_Extract(t *testing.T) {
 
 }
 
+// newTestContentManager creates a manager extracting a content of two chunks from one layer,
+// it returns the compressed layer as well
+func newTestContentManager(t *testing.T, content []byte) (*Manager, *receive.Content, []byte) {
+	layer := bytes.NewBuffer(nil)
+	c := &receive.Content{
+		Stack:  0,
+		Digest: digest.FromBytes(content).String(),
+	}
+	for _, part := range [][]byte{content[:len(content)/2], content[len(content)/2:]} {
+		offset := int64(layer.Len())
+		zw := gzip.NewWriter(layer)
+		_, _ = zw.Write(part)
+		_ = zw.Close()
+		c.Chunks = append(c.Chunks, &receive.FileChunk{
+			Offset:         offset,
+			ChunkOffset:    int64(len(c.Chunks) * (len(content) / 2)),
+			ChunkSize:      int64(len(part)),
+			CompressedSize: int64(layer.Len()) - offset,
+			ChunkDigest:    digest.FromBytes(part).String(),
+		})
+	}
+	c.Size = int64(layer.Len())
+	c.InitSignals()
+
+	l := &receive.ImageLayer{Serial: 1, Hash: "sha256:" + fmt.Sprintf("%064x", 2), Local: t.TempDir()}
+	m := &Manager{
+		ctx:            ctx,
+		cfg:            &Configuration{OnDemandFetchDelay: 1},
+		layers:         map[int64]*receive.ImageLayer{1: l},
+		stackSerialMap: []int64{1},
+		completedStack: []bool{false},
+	}
+	m.Destination = &receive.Image{Serial: 1, Layers: []*receive.ImageLayer{l}}
+	m.Contents = []*receive.Content{c}
+	return m, c, layer.Bytes()
+}
+
+func TestManager_FetchContent(t *testing.T) {
+	content := bytes.Repeat([]byte("starlight"), 200)
+	m, c, layer := newTestContentManager(t, content)
+	l := m.Destination.Layers[0]
+	dir := l.Local
+
+	fetched := 0
+	m.SetFetchContent(func(hash string, ranges []common.ByteRange) (io.ReadCloser, error) {
+		fetched++
+		if hash != l.Hash {
+			return nil, fmt.Errorf("unexpected layer %s", hash)
+		}
+		buf := bytes.NewBuffer(nil)
+		for _, r := range ranges {
+			buf.Write(layer[r.Offset:r.End()])
+		}
+		return io.NopCloser(buf), nil
+	})
+
+	// the file is accessed before the delta image reaches it
+	f := &receive.ReferencedFile{}
+	f.SetContent(c)
+	done := make(chan interface{})
+	go func() {
+		f.WaitForReady()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("content should have been fetched on demand")
+	}
+	if fetched != 1 {
+		t.Errorf("expected 1 fetch but got %d", fetched)
+	}
+
+	// the delta image skips the content
+	body := io.NopCloser(bytes.NewReader(layer))
+	if _, err := m.extractContent(0, c, &body); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := io.Copy(io.Discard, body); n != 0 {
+		t.Errorf("the content should have been consumed from the body, %d bytes left", n)
+	}
+
+	actual, err := os.ReadFile(filepath.Join(dir, c.GetPath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, content) {
+		t.Error("extracted content does not match")
+	}
+}
+
+func TestManager_ExtractIntegrity(t *testing.T) {
+	content := bytes.Repeat([]byte("starlight"), 200)
+
+	m, c, layer := newTestContentManager(t, content)
+	body := io.NopCloser(bytes.NewReader(layer))
+	if err := m.Extract(&body); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsReady() {
+		t.Error("content should be ready")
+	}
+
+	for _, corrupt := range []func(c *receive.Content){
+		func(c *receive.Content) { c.Chunks[1].ChunkDigest = digest.FromString("x").String() },
+		func(c *receive.Content) { c.Digest = digest.FromString("x").String() },
+	} {
+		m, c, layer = newTestContentManager(t, content)
+		corrupt(c)
+		completed := filepath.Join(m.Destination.Layers[0].Local, "completed.json")
+		_ = os.WriteFile(completed, []byte("{}"), 0644)
+
+		body = io.NopCloser(bytes.NewReader(layer))
+		if err := m.Extract(&body); !errors.Is(err, ErrContentIntegrity) {
+			t.Fatalf("expected integrity error but got %v", err)
+		}
+		if c.IsReady() {
+			t.Error("corrupted content should not be ready")
+		}
+		if _, err := os.Stat(filepath.Join(m.Destination.Layers[0].Local, c.GetPath())); !os.IsNotExist(err) {
+			t.Error("corrupted content should be removed")
+		}
+		if _, err := os.Stat(completed); !os.IsNotExist(err) {
+			t.Error("layer should be marked as incomplete")
+		}
+	}
+}
+
 func TestManager_Init(t *testing.T) {
 	t.Skip("for dev only")
 	cfg, _, _, _ := LoadConfig("/root/daemon.json")
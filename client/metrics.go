@@ -0,0 +1,239 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "starlight_daemon"
+
+var (
+	pullDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "pull_duration_seconds",
+		Help:      "Time to pull an image, stage is ready when the container could start and complete when all contents are extracted.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"image", "stage"})
+
+	receivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "received_bytes_total",
+		Help:      "Number of bytes of delta images received from the proxy.",
+	}, []string{"image"})
+
+	openWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "open_wait_seconds",
+		Help:      "Time a file open (or fsync) blocks waiting for the content of the file to be extracted.",
+		Buckets:   append([]float64{0}, prometheus.ExponentialBuckets(0.001, 4, 10)...),
+	}, []string{"image"})
+
+	onDemandFetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "on_demand_fetches_total",
+		Help:      "Number of contents fetched out of order because a file was accessed before the delta image reached it.",
+	}, []string{"image", "result"})
+
+	managersDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "managers"),
+		"Number of image managers in memory.", []string{"image"}, nil)
+	mountsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "mounts"),
+		"Number of mounted Starlight filesystems.", []string{"image"}, nil)
+	snapshotsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "snapshots"),
+		"Number of snapshots using the Starlight filesystems.", []string{"image"}, nil)
+	contentsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "contents"),
+		"Number of file contents in the delta image.", []string{"image"}, nil)
+	extractedContentsDesc = prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "extracted_contents"),
+		"Number of file contents that have been extracted.", []string{"image"}, nil)
+)
+
+// countingReadCloser counts the bytes received for the image
+type countingReadCloser struct {
+	io.ReadCloser
+	counter  prometheus.Counter
+	received *int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	atomic.AddInt64(r.received, int64(n))
+	return n, err
+}
+
+// newCountingReadCloser counts the bytes read from r in the metrics and in received
+func newCountingReadCloser(r io.ReadCloser, ref string, received *int64) io.ReadCloser {
+	return &countingReadCloser{ReadCloser: r, counter: receivedBytes.WithLabelValues(ref), received: received}
+}
+
+// ManagerStatus is the status of an image manager reported by the status endpoint
+type ManagerStatus struct {
+	Image             string `json:"image"`
+	Manifest          string `json:"manifest"`
+	Contents          int    `json:"contents"`
+	ExtractedContents int64  `json:"extracted_contents"`
+	Mounts            int    `json:"mounts"`
+	Snapshots         int    `json:"snapshots"`
+}
+
+// Status returns the status of the image managers, sorted by image reference
+func (c *Client) Status() []*ManagerStatus {
+	c.managerMapLock.Lock()
+	status := make(map[*Manager]*ManagerStatus, len(c.managerMap))
+	for _, m := range c.managerMap {
+		status[m] = &ManagerStatus{
+			Image:             m.imageRef,
+			Manifest:          m.manifestDigest.String(),
+			Contents:          len(m.Contents),
+			ExtractedContents: atomic.LoadInt64(&m.extracted),
+		}
+	}
+	c.managerMapLock.Unlock()
+
+	c.layerMapLock.Lock()
+	for _, mp := range c.layerMap {
+		if mp.manager == nil {
+			continue
+		}
+		s, has := status[mp.manager]
+		if !has {
+			continue
+		}
+		if mp.fs != nil {
+			s.Mounts++
+		}
+		s.Snapshots += len(mp.snapshots)
+	}
+	c.layerMapLock.Unlock()
+
+	res := make([]*ManagerStatus, 0, len(status))
+	for _, s := range status {
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Image == res[j].Image {
+			return res[i].Manifest < res[j].Manifest
+		}
+		return res[i].Image < res[j].Image
+	})
+	return res
+}
+
+// clientCollector reports the managers and mounting points of the client
+type clientCollector struct {
+	c *Client
+}
+
+func (cc *clientCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managersDesc
+	ch <- mountsDesc
+	ch <- snapshotsDesc
+	ch <- contentsDesc
+	ch <- extractedContentsDesc
+}
+
+func (cc *clientCollector) Collect(ch chan<- prometheus.Metric) {
+	type counts struct {
+		managers, mounts, snapshots, contents, extracted float64
+	}
+	images := make(map[string]*counts)
+	for _, s := range cc.c.Status() {
+		t, has := images[s.Image]
+		if !has {
+			t = &counts{}
+			images[s.Image] = t
+		}
+		t.managers++
+		t.mounts += float64(s.Mounts)
+		t.snapshots += float64(s.Snapshots)
+		t.contents += float64(s.Contents)
+		t.extracted += float64(s.ExtractedContents)
+	}
+	for image, t := range images {
+		ch <- prometheus.MustNewConstMetric(managersDesc, prometheus.GaugeValue, t.managers, image)
+		ch <- prometheus.MustNewConstMetric(mountsDesc, prometheus.GaugeValue, t.mounts, image)
+		ch <- prometheus.MustNewConstMetric(snapshotsDesc, prometheus.GaugeValue, t.snapshots, image)
+		ch <- prometheus.MustNewConstMetric(contentsDesc, prometheus.GaugeValue, t.contents, image)
+		ch <- prometheus.MustNewConstMetric(extractedContentsDesc, prometheus.GaugeValue, t.extracted, image)
+	}
+}
+
+// metricsHandler creates a registry with the daemon metrics
+func (c *Client) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		pullDuration, receivedBytes, openWaitDuration, onDemandFetches,
+		&clientCollector{c: c},
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+func (c *Client) statusHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(c.Status()); err != nil {
+		log.G(c.ctx).WithError(err).Error("failed to write status")
+	}
+}
+
+// StartMetricsServer serves the Prometheus metrics (/metrics) and the status of the managers (/status)
+// at the configured address in the background. It does nothing if the address is empty.
+func (c *Client) StartMetricsServer() {
+	if c.cfg.MetricsAddress == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metricsHandler())
+	mux.HandleFunc("/status", c.statusHandler)
+	c.metricsServer = &http.Server{
+		Addr:              c.cfg.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.G(c.ctx).
+		WithField("address", c.cfg.MetricsAddress).
+		Info("starlight metrics service started")
+
+	// the server is created before serving, so that stopMetricsServer always sees it
+	go func(server *http.Server) {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.G(c.ctx).WithError(err).Errorf("failed to serve metrics")
+		}
+	}(c.metricsServer)
+}
+
+func (c *Client) stopMetricsServer() {
+	if c.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+	_ = c.metricsServer.Shutdown(ctx)
+}
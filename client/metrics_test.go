@@ -0,0 +1,81 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/snapshots"
+	"github.com/mc256/starlight/util/receive"
+)
+
+func TestClient_Metrics(t *testing.T) {
+	m := &Manager{imageRef: "docker.io/library/redis:7", extracted: 1}
+	m.Contents = []*receive.Content{{}, {}, {}}
+	c := &Client{
+		ctx:        ctx,
+		cfg:        NewConfig(),
+		managerMap: map[string]*Manager{"sha256:aaaa": m},
+		layerMap: map[string]*mountPoint{
+			"sha256:1111": {manager: m, snapshots: map[string]*snapshots.Info{"s1": {}, "s2": {}}},
+			"sha256:2222": {manager: m, snapshots: map[string]*snapshots.Info{}},
+		},
+	}
+
+	status := c.Status()
+	if len(status) != 1 || status[0].Contents != 3 || status[0].ExtractedContents != 1 ||
+		status[0].Snapshots != 2 || status[0].Mounts != 0 {
+		t.Fatalf("unexpected status %+v", status[0])
+	}
+
+	now := time.Now()
+	body := newCountingReadCloser(io.NopCloser(bytes.NewReader(make([]byte, 100))), m.imageRef, new(int64))
+	_, _ = io.Copy(io.Discard, body)
+	m.LogTrace(0, "bin/redis-server", now, now)
+
+	rec := httptest.NewRecorder()
+	c.metricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	out := rec.Body.String()
+	for _, expected := range []string{
+		`starlight_daemon_managers{image="docker.io/library/redis:7"} 1`,
+		`starlight_daemon_snapshots{image="docker.io/library/redis:7"} 2`,
+		`starlight_daemon_extracted_contents{image="docker.io/library/redis:7"} 1`,
+		`starlight_daemon_received_bytes_total{image="docker.io/library/redis:7"} 100`,
+		`starlight_daemon_open_wait_seconds_count{image="docker.io/library/redis:7"} 1`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in metrics", expected)
+		}
+	}
+}
+
+func TestClient_StartMetricsServer(t *testing.T) {
+	c := &Client{ctx: ctx, cfg: NewConfig()}
+	c.cfg.MetricsAddress = "127.0.0.1:0"
+
+	// the server can be stopped right after it is started
+	c.StartMetricsServer()
+	if c.metricsServer == nil {
+		t.Fatal("expected the metrics server to be created")
+	}
+	c.stopMetricsServer()
+}
@@ -16,7 +16,8 @@ import (
 )
 
 // addProxyProfile adds a proxy profile to the Starlight daemon configuration
-func addProxyProfile(client pb.DaemonClient, name, protocol, address, username, password string, quiet bool) error {
+func addProxyProfile(client pb.DaemonClient, name, protocol, address, username, password string,
+	insecureSkipVerify, quiet bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
@@ -27,6 +28,8 @@ func addProxyProfile(client pb.DaemonClient, name, protocol, address, username,
 
 		Username: username,
 		Password: password,
+
+		InsecureSkipVerify: insecureSkipVerify,
 	})
 	if err != nil {
 		return err
@@ -83,6 +86,7 @@ func Action(context *cli.Context) (err error) {
 		proxyName,
 		protocol, proxyAddr,
 		username, password,
+		context.Bool("insecure-skip-verify"),
 		context.Bool("quiet"))
 	if err != nil {
 		return err
@@ -106,6 +110,11 @@ func Command() *cli.Command {
 				Value:   "",
 				Usage:   "provide username and password for the proxy server",
 			},
+			&cli.BoolFlag{
+				Name:  "insecure-skip-verify",
+				Value: false,
+				Usage: "do not verify the manifest, config and Starlight header of the delta images against their digests (debugging only)",
+			},
 			&cli.StringFlag{
 				Name:    "quiet",
 				Aliases: []string{"q"},
@@ -31,6 +31,7 @@ import (
 	"github.com/mc256/starlight/cmd/ctr-starlight/auth"
 	"github.com/mc256/starlight/cmd/ctr-starlight/notify"
 	"github.com/mc256/starlight/util"
+	"github.com/mc256/starlight/util/common"
 	"github.com/urfave/cli/v2"
 )
 
@@ -66,11 +67,22 @@ func Action(ctx context.Context, c *cli.Context) error {
 	remoteOptions := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
 
 	// config
+	compression, err := common.ParseCompression(c.String("compression"))
+	if err != nil {
+		return err
+	}
 	convertor, err := util.NewConvertor(ctx, srcImg, slImg, srcOptions, dstOptions, remoteOptions, c.String("platform"))
 	if err != nil {
 		log.G(ctx).WithError(err).Error("illegal image reference")
 		return nil
 	}
+	convertor.SetCompression(compression)
+	if err = convertor.SetChunkingPolicy(util.ChunkingPolicy{
+		ChunkSize:          c.Int64("chunk-size"),
+		LargeFileThreshold: c.Int64("large-file-threshold"),
+	}); err != nil {
+		return err
+	}
 
 	// convert
 	err = convertor.ToStarlightImage()
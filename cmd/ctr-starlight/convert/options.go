@@ -19,6 +19,7 @@
 package convert
 
 import (
+	"github.com/mc256/starlight/util"
 	"github.com/urfave/cli/v2"
 )
 
@@ -44,5 +45,25 @@ var (
 			Value:    "all",
 			Required: false,
 		},
+		&cli.StringFlag{
+			Name: "compression",
+			Usage: "compression of the Starlight layers, 'gzip' (compatible with eStargz) or 'zstd' " +
+				"(smaller and faster to decompress, requires an up-to-date Starlight daemon)",
+			Value:    "gzip",
+			Required: false,
+		},
+		&cli.Int64Flag{
+			Name:     "chunk-size",
+			Usage:    "maximum size of a chunk of a large file in bytes",
+			Value:    util.DefaultChunkingPolicy.ChunkSize,
+			Required: false,
+		},
+		&cli.Int64Flag{
+			Name: "large-file-threshold",
+			Usage: "files larger than this (in bytes) are split into chunks of --chunk-size, " +
+				"smaller files are kept in one chunk",
+			Value:    util.DefaultChunkingPolicy.LargeFileThreshold,
+			Required: false,
+		},
 	}
 )
@@ -37,6 +37,10 @@ func listProxyProfile(client pb.DaemonClient) error {
 
 	// print
 	for _, profile := range resp.Profiles {
+		if profile.InsecureSkipVerify {
+			fmt.Printf("%*s %s://%s (insecure-skip-verify)\n", maxLen+2, fmt.Sprintf("[%s]", profile.Name), profile.Protocol, profile.Address)
+			continue
+		}
 		fmt.Printf("%*s %s://%s\n", maxLen+2, fmt.Sprintf("[%s]", profile.Name), profile.Protocol, profile.Address)
 	}
 
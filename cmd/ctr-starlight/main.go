@@ -30,6 +30,7 @@ import (
 	cmdOptimizer "github.com/mc256/starlight/cmd/ctr-starlight/optimizer"
 	cmdPing "github.com/mc256/starlight/cmd/ctr-starlight/ping"
 	cmdPull "github.com/mc256/starlight/cmd/ctr-starlight/pull"
+	cmdQuery "github.com/mc256/starlight/cmd/ctr-starlight/query"
 	cmdReport "github.com/mc256/starlight/cmd/ctr-starlight/report"
 	cmdReset "github.com/mc256/starlight/cmd/ctr-starlight/reset"
 	cmdVersion "github.com/mc256/starlight/cmd/ctr-starlight/version"
@@ -108,6 +109,7 @@ https://github.com/mc256/starlight
 		cmdReport.Command(),    // 8. upload filesystem traces to starlight proxy
 		cmdReset.Command(),     // !. reset starlight daemon
 		cmdPull.Command(),      // 9. pull starlight image
+		cmdQuery.Command(),     // 10. show what the starlight proxy has indexed
 	}
 
 	return app
@@ -8,16 +8,86 @@ package pull
 import (
 	"context"
 	"fmt"
+	"io"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/mc256/starlight/client/api"
 	"github.com/mc256/starlight/cmd/ctr-starlight/auth"
 	"github.com/urfave/cli/v2"
+	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// watchPull renders the progress of the content extraction until the pull completes
+func watchPull(client pb.DaemonClient, ref string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*30)
+	defer cancel()
+	stream, err := client.WatchPull(ctx, &pb.WatchPullRequest{Reference: ref})
+	if err != nil {
+		return fmt.Errorf("watch pull failed: %v", err)
+	}
+
+	// the latest progress, it is shared with the decorator that renders in another goroutine
+	var (
+		latest atomic.Value
+		last   *pb.PullProgress
+	)
+	p := mpb.NewWithContext(ctx, mpb.WithWidth(40))
+	bar := p.AddBar(0,
+		mpb.PrependDecorators(
+			decor.Name("extracting "),
+			decor.CountersNoUnit("%d / %d", decor.WCSyncWidth),
+		),
+		mpb.AppendDecorators(
+			decor.Percentage(decor.WCSyncSpace),
+			decor.Any(func(decor.Statistics) string {
+				l, ok := latest.Load().(*pb.PullProgress)
+				if !ok {
+					return ""
+				}
+				return fmt.Sprintf(" %.1f / %.1f (%.1f/s)",
+					decor.SizeB1024(l.ReceivedBytes),
+					decor.SizeB1024(l.TotalBytes),
+					decor.SizeB1024(int64(l.Rate)),
+				)
+			}),
+		),
+	)
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			bar.Abort(false)
+			p.Wait()
+			return fmt.Errorf("watch pull failed: %v", err)
+		}
+		last = msg
+		latest.Store(msg)
+		bar.SetTotal(msg.TotalContents, false)
+		bar.SetCurrent(msg.ExtractedContents)
+		if msg.Completed {
+			if msg.Success {
+				bar.SetTotal(msg.TotalContents, true)
+			} else {
+				bar.Abort(false)
+			}
+			break
+		}
+	}
+	p.Wait()
+
+	if last != nil && last.Completed && !last.Success {
+		fmt.Printf("pull image failed: %s\n", last.Message)
+	}
+	return nil
+}
+
 func pullImage(client pb.DaemonClient, ref *pb.ImageReference, quiet bool) error {
 	if ref.DisableEarlyStart {
 		fmt.Printf("early start has been disabled, this may take a while\n")
@@ -49,6 +119,8 @@ func pullImage(client pb.DaemonClient, ref *pb.ImageReference, quiet bool) error
 			}
 		} else {
 			fmt.Printf("%s\n", resp.GetMessage())
+			// the image has been pulled before, nothing to watch
+			return nil
 		}
 
 		if resp.TotalImageSize > -1 {
@@ -62,6 +134,8 @@ func pullImage(client pb.DaemonClient, ref *pb.ImageReference, quiet bool) error
 				float64(skipSize)/float64(resp.OriginalImageSize)*100,
 			)
 		}
+
+		return watchPull(client, ref.Reference)
 	} else {
 		fmt.Printf("pull image failed: %s\n", resp.Message)
 	}
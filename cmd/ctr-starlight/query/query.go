@@ -0,0 +1,172 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	pb "github.com/mc256/starlight/client/api"
+	"github.com/mc256/starlight/cmd/ctr-starlight/auth"
+	"github.com/mc256/starlight/proxy"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// queryProxy asks the daemon to call the admin API of the proxy in the profile and decodes the result
+func queryProxy(c *cli.Context, req *pb.ProxyQueryRequest, result interface{}) error {
+	// Dial to the daemon
+	address := c.String("address")
+	opts := grpc.WithTransportCredentials(insecure.NewCredentials())
+	conn, err := grpc.Dial(address, opts)
+	if err != nil {
+		return fmt.Errorf("failed to connect starlight daemon: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	req.ProxyConfig = c.String("profile")
+	resp, err := pb.NewDaemonClient(conn).QueryProxy(ctx, req)
+	if err != nil {
+		return fmt.Errorf("query starlight proxy server failed: %v", err)
+	}
+	if !resp.Success {
+		return errors.New(resp.GetMessage())
+	}
+	if err = json.Unmarshal(resp.GetResult(), result); err != nil {
+		return fmt.Errorf("failed to parse the result: %v", err)
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func repositoriesAction(c *cli.Context) error {
+	if c.NArg() != 0 {
+		return errors.New("invalid number of arguments")
+	}
+
+	var repos []*proxy.RepositoryInfo
+	if err := queryProxy(c, &pb.ProxyQueryRequest{Query: "repositories"}, &repos); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tTAGS\tIMAGES")
+	for _, r := range repos {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", r.Name, r.Tags, r.Images)
+	}
+	return w.Flush()
+}
+
+func tagsAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("wrong number of arguments, expected repository name")
+	}
+
+	var tags []*proxy.TagInfo
+	if err := queryProxy(c, &pb.ProxyQueryRequest{Query: "tags", Repository: c.Args().First()}, &tags); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TAG\tPLATFORM\tIMAGE\tDIGEST\tREADY\tUPDATED")
+	for _, t := range tags {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			t.Tag, t.Platform, t.Image, t.Digest, formatTime(t.Ready), formatTime(&t.Updated))
+	}
+	return w.Flush()
+}
+
+func imageAction(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("wrong number of arguments, expected container image reference")
+	}
+
+	var img proxy.ImageInfo
+	if err := queryProxy(c, &pb.ProxyQueryRequest{
+		Query:     "image",
+		Reference: c.Args().First(),
+		Platform:  c.String("platform"),
+	}, &img); err != nil {
+		return err
+	}
+
+	fmt.Printf("image:    %s (%d)\n", img.Name, img.Serial)
+	fmt.Printf("digest:   %s\n", img.Digest)
+	fmt.Printf("created:  %s\n", formatTime(img.Created))
+	fmt.Printf("ready:    %s\n", formatTime(img.Ready))
+	fmt.Printf("files:    %d (%d ranked, %.1f%% coverage)\n\n", img.Files, img.RankedFiles, img.RankCoverage*100)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STACK\tLAYER\tDIGEST\tSIZE\tFILES\tRANKED")
+	for _, l := range img.Layers {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\t%d\n", l.StackIndex, l.Serial, l.Digest, l.Size, l.Files, l.RankedFiles)
+	}
+	return w.Flush()
+}
+
+func Command() *cli.Command {
+	cmd := cli.Command{
+		Name:  "query",
+		Usage: "show the repositories, tags and images indexed by the starlight proxy",
+		Subcommands: []*cli.Command{
+			{
+				Name:      "repositories",
+				Aliases:   []string{"repos"},
+				Usage:     "list the repositories with the number of tags and images",
+				Flags:     auth.ProxyFlags,
+				Action:    repositoriesAction,
+				ArgsUsage: "[flags]",
+			},
+			{
+				Name:      "tags",
+				Usage:     "list the tags of a repository with their platforms and the time the images are ready",
+				Flags:     auth.ProxyFlags,
+				Action:    tagsAction,
+				ArgsUsage: "[flags] Repository",
+			},
+			{
+				Name:  "image",
+				Usage: "show the layers of an image with their sizes, file counts and rank coverage",
+				Flags: append([]cli.Flag{
+					&cli.StringFlag{
+						Name:  "platform",
+						Usage: "platform of the image, the platform of the daemon if empty",
+						Value: "",
+					},
+				}, auth.ProxyFlags...),
+				Action:    imageAction,
+				ArgsUsage: "[flags] StarlightImage",
+			},
+		},
+	}
+	return &cmd
+}
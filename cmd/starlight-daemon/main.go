@@ -107,6 +107,11 @@ https://github.com/mc256/starlight
 			Usage:       "path to store uncompress image layers",
 			Required:    false,
 		},
+		&cli.StringFlag{
+			Name:     "metrics",
+			Usage:    "address to serve Prometheus metrics and daemon status, e.g. ':8081' (disabled if empty)",
+			Required: false,
+		},
 		&cli.StringFlag{
 			Name:        "id",
 			DefaultText: cfg.ClientId,
@@ -178,6 +183,9 @@ func DefaultAction(context *cli.Context, cfg *client.Configuration) (err error)
 	if d := context.String("default"); d != "" {
 		cfg.DefaultProxy = d
 	}
+	if a := context.String("metrics"); a != "" {
+		cfg.MetricsAddress = a
+	}
 	parr := context.StringSlice("proxy")
 	if len(parr) != 0 {
 		for _, v := range parr {
@@ -229,6 +237,9 @@ func DefaultAction(context *cli.Context, cfg *client.Configuration) (err error)
 
 	}()
 
+	// Metrics
+	slc.StartMetricsServer()
+
 	wait := make(chan interface{})
 	si := make(chan os.Signal, 1)
 	signal.Notify(si, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
@@ -19,10 +19,12 @@
 package main
 
 import (
+	gocontext "context"
 	"fmt"
 	"os"
 	"regexp"
 	"sync"
+	"time"
 
 	"github.com/containerd/containerd/log"
 	"github.com/mc256/starlight/proxy"
@@ -105,7 +107,7 @@ information about Starlight, please visit our repository at https://github.com/m
 			Aliases:     []string{"db"},
 			EnvVars:     []string{"DB_CONNECTION_STRING"},
 			DefaultText: ProtectPassword(cfg.PostgresConnectionString),
-			Usage:       "use PostgreSQL database backend for storing TOCs",
+			Usage:       "database for storing TOCs, a PostgreSQL connection string or bolt:///path/to/metadata.db for the embedded database",
 			Required:    false,
 		},
 		// ----
@@ -121,14 +123,50 @@ information about Starlight, please visit our repository at https://github.com/m
 	app.Action = func(c *cli.Context) error {
 		return DefaultAction(c, cfg)
 	}
+	app.Commands = []*cli.Command{
+		{
+			Name:  "migrate",
+			Usage: "apply the pending schema migrations of the metadata database",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "print the pending migrations without applying them",
+				},
+				&cli.BoolFlag{
+					Name:  "status",
+					Usage: "print all the migrations and when they were applied",
+				},
+			},
+			Action: MigrateAction,
+		},
+	}
 
 	return app
 }
 
 func DefaultAction(context *cli.Context, cfg *proxy.Configuration) (err error) {
+	c, cfg := loadConfig(context)
+	log.G(c).Infof("default backend registry: %s", cfg.DefaultRegistry)
+
+	httpServerExitDone := &sync.WaitGroup{}
+	httpServerExitDone.Add(1)
+
+	if _, err = proxy.NewServer(c, httpServerExitDone, cfg); err != nil {
+		log.G(c).WithError(err).Error("failed to start proxy server")
+		return err
+	}
+
+	wait := make(chan interface{})
+	<-wait
+	return nil
+}
+
+// loadConfig loads the configuration file and applies the CLI options
+func loadConfig(context *cli.Context) (c gocontext.Context, cfg *proxy.Configuration) {
 	var (
-		p  string
-		ne bool
+		p   string
+		ne  bool
+		err error
 	)
 
 	config := context.String("config")
@@ -137,7 +175,7 @@ func DefaultAction(context *cli.Context, cfg *proxy.Configuration) (err error) {
 	if l := context.String("log-level"); l != "" {
 		cfg.LogLevel = l
 	}
-	c := util.ConfigLoggerWithLevel(cfg.LogLevel)
+	c = util.ConfigLoggerWithLevel(cfg.LogLevel)
 	log.G(c).
 		WithField("version", util.Version).
 		Info("starlight-proxy")
@@ -173,14 +211,39 @@ func DefaultAction(context *cli.Context, cfg *proxy.Configuration) (err error) {
 	if r := context.String("registry"); r != "" {
 		cfg.DefaultRegistry = r
 	}
-	log.G(c).Infof("default backend registry: %s", cfg.DefaultRegistry)
+	return c, cfg
+}
 
-	httpServerExitDone := &sync.WaitGroup{}
-	httpServerExitDone.Add(1)
+// MigrateAction applies the pending schema migrations, or prints them with --dry-run or --status
+func MigrateAction(context *cli.Context) error {
+	c, cfg := loadConfig(context)
 
-	_, _ = proxy.NewServer(c, httpServerExitDone, cfg)
+	db, err := proxy.NewDatabase(c, cfg.PostgresConnectionString)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	wait := make(chan interface{})
-	<-wait
+	var ms []*proxy.Migration
+	if context.Bool("status") {
+		ms, err = db.Migrations()
+	} else {
+		ms, err = db.Migrate(context.Bool("dry-run"))
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(ms) == 0 {
+		fmt.Println("database schema is up to date")
+		return nil
+	}
+	for _, m := range ms {
+		applied := "pending"
+		if m.Applied != nil {
+			applied = m.Applied.Format(time.RFC3339)
+		}
+		fmt.Printf("%4d  %-25s  %s\n", m.Version, applied, m.Description)
+	}
 	return nil
 }
@@ -0,0 +1,178 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/sirupsen/logrus"
+)
+
+// RepositoryInfo summarizes a repository indexed by the proxy
+type RepositoryInfo struct {
+	Name   string `json:"name"`
+	Tags   int64  `json:"tags"`
+	Images int64  `json:"images"`
+}
+
+// TagInfo is a tag of a platform and the image it points to
+type TagInfo struct {
+	Name     string     `json:"name"`
+	Tag      string     `json:"tag"`
+	Platform string     `json:"platform"`
+	Image    int64      `json:"image"`
+	Digest   string     `json:"digest"`
+	Ready    *time.Time `json:"ready,omitempty"`
+	Updated  time.Time  `json:"updated"`
+}
+
+// LayerInfo is a layer of an image, RankedFiles is the number of files that have been ranked by the
+// uploaded traces
+type LayerInfo struct {
+	StackIndex  int64  `json:"stackIndex"`
+	Serial      int64  `json:"serial"`
+	Digest      string `json:"digest"`
+	Size        int64  `json:"size"`
+	Files       int64  `json:"files"`
+	RankedFiles int64  `json:"rankedFiles"`
+}
+
+// ImageInfo is an image and its layers from bottom to top
+type ImageInfo struct {
+	Serial  int64        `json:"serial"`
+	Name    string       `json:"name"`
+	Digest  string       `json:"digest"`
+	Created *time.Time   `json:"created,omitempty"`
+	Ready   *time.Time   `json:"ready,omitempty"`
+	Layers  []*LayerInfo `json:"layers"`
+
+	Files       int64 `json:"files"`
+	RankedFiles int64 `json:"rankedFiles"`
+	// RankCoverage is the fraction of the files that have been ranked
+	RankCoverage float64 `json:"rankCoverage"`
+}
+
+// summarize computes the totals of the layers
+func (i *ImageInfo) summarize() {
+	i.Files, i.RankedFiles = 0, 0
+	for _, l := range i.Layers {
+		i.Files += l.Files
+		i.RankedFiles += l.RankedFiles
+	}
+	i.RankCoverage = 0
+	if i.Files > 0 {
+		i.RankCoverage = float64(i.RankedFiles) / float64(i.Files)
+	}
+}
+
+// adminRepositories lists the repositories the user has access to
+func (a *Server) adminRepositories(w http.ResponseWriter, req *http.Request) {
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "repositories", "ip": a.getIpAddress(req)}).Info("request received")
+
+	user, ok := a.authorize(w, req, nil)
+	if !ok {
+		return
+	}
+
+	repos, err := a.db.ListRepositories()
+	if err != nil {
+		log.G(a.ctx).WithError(err).Error("failed to list repositories")
+		a.error(w, req, err.Error())
+		return
+	}
+
+	res := &ApiResponse{
+		Status:       "OK",
+		Code:         http.StatusOK,
+		Message:      "Starlight Proxy",
+		Repositories: make([]*RepositoryInfo, 0, len(repos)),
+	}
+	for _, r := range repos {
+		if a.auth == nil || a.auth.Allowed(user, req.URL.Path, []string{r.Name}) {
+			res.Repositories = append(res.Repositories, r)
+		}
+	}
+	a.respond(w, req, res)
+}
+
+// adminTags lists the tags of a repository with their platforms
+func (a *Server) adminTags(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "tags", "ip": a.getIpAddress(req)}).Info("request received")
+
+	repo := q.Get("repository")
+	if repo == "" {
+		a.error(w, req, "missing parameters")
+		return
+	}
+
+	repos := a.repositories(repo)
+	if _, ok := a.authorize(w, req, repos); !ok {
+		return
+	}
+
+	tags, err := a.db.ListTags(repos[0])
+	if err != nil {
+		log.G(a.ctx).WithError(err).Error("failed to list tags")
+		a.error(w, req, err.Error())
+		return
+	}
+
+	a.respond(w, req, &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		Tags:    tags,
+	})
+}
+
+// adminImage shows the layers of an image with their sizes, file counts and rank coverage
+func (a *Server) adminImage(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "image", "ip": a.getIpAddress(req)}).Info("request received")
+
+	ref, plt := q.Get("ref"), q.Get("platform")
+	if ref == "" || plt == "" {
+		a.error(w, req, "missing parameters")
+		return
+	}
+
+	if _, ok := a.authorize(w, req, a.repositories(ref)); !ok {
+		return
+	}
+
+	_, serial, err := a.imageSerial(ref, plt)
+	if err != nil {
+		a.error(w, req, err.Error())
+		return
+	}
+	info, err := a.db.GetImageInfo(serial)
+	if err != nil {
+		log.G(a.ctx).WithError(err).Error("failed to load image")
+		a.error(w, req, err.Error())
+		return
+	}
+
+	a.respond(w, req, &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		Image:   info,
+	})
+}
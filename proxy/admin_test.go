@@ -0,0 +1,53 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestServer_Admin(t *testing.T) {
+	a, d := newTestServer(t)
+	insertTaggedImage(t, d, "admin/app", "sha256:app", []string{"sha256:layer"}, "v1")
+	request := func(handler http.HandlerFunc, target string) (int, *ApiResponse) {
+		return serve(t, handler, http.MethodGet, target, nil)
+	}
+
+	if code, res := request(a.adminRepositories, "/starlight/admin/repositories"); code != http.StatusOK ||
+		len(res.Repositories) != 1 || *res.Repositories[0] != (RepositoryInfo{Name: "admin/app", Tags: 1, Images: 1}) {
+		t.Errorf("unexpected repositories %d %v", code, res.Repositories)
+	}
+	if code, res := request(a.adminTags, "/starlight/admin/tags?repository=admin/app"); code != http.StatusOK ||
+		len(res.Tags) != 2 || res.Tags[0].Tag != "v1" || res.Tags[0].Digest != "sha256:app" {
+		t.Errorf("unexpected tags %d %v", code, res.Tags)
+	}
+	if code, _ := request(a.adminTags, "/starlight/admin/tags"); code != http.StatusBadRequest {
+		t.Errorf("expected bad request but got %d", code)
+	}
+	code, res := request(a.adminImage, "/starlight/admin/image?ref=admin/app:v1&platform=linux/amd64")
+	if code != http.StatusOK || res.Image == nil || len(res.Image.Layers) != 1 {
+		t.Fatalf("unexpected image %d %+v", code, res)
+	}
+	if l := res.Image.Layers[0]; l.Digest != "sha256:layer" || l.Size != 10 || l.Files != 1 || l.RankedFiles != 0 ||
+		res.Image.RankCoverage != 0 {
+		t.Errorf("unexpected layer %+v", l)
+	}
+	if code, _ := request(a.adminImage, "/starlight/admin/image?ref=admin/app:v2&platform=linux/amd64"); code != http.StatusBadRequest {
+		t.Errorf("expected bad request for an unknown tag but got %d", code)
+	}
+}
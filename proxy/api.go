@@ -19,6 +19,7 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/containerd/containerd/log"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/mc256/starlight/util"
 	"github.com/mc256/starlight/util/common"
 	"github.com/sirupsen/logrus"
 )
@@ -45,6 +47,9 @@ type StarlightProxy struct {
 	client *http.Client
 
 	auth url.Userinfo
+
+	// availableLayers are the digests of the layers on the client, the delta images reuse their contents
+	availableLayers []string
 }
 
 func (a *StarlightProxy) Ping() (int64, string, string, error) {
@@ -147,6 +152,137 @@ func (a *StarlightProxy) Notify(ref name.Reference, insecure bool) error {
 	return nil
 }
 
+// GetLayers returns the digests of the compressed layers of the image on the proxy, from bottom to top
+func (a *StarlightProxy) GetLayers(ref, platform string) ([]string, error) {
+	u := url.URL{
+		Scheme: a.protocol,
+		Host:   a.serverAddress,
+		Path:   path.Join("starlight", "layers"),
+	}
+	q := u.Query()
+	q.Set("ref", ref)
+	q.Set("platform", platform)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(a.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if pwd, isSet := a.auth.Password(); isSet {
+		req.SetBasicAuth(a.auth.Username(), pwd)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res ApiResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to parse response from proxy (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server error (status %d): %s", resp.StatusCode, res.Error)
+	}
+	return res.Layers, nil
+}
+
+// admin sends a GET request to the read-only admin API of the proxy
+func (a *StarlightProxy) admin(endpoint string, q url.Values) (*ApiResponse, error) {
+	u := url.URL{
+		Scheme:   a.protocol,
+		Host:     a.serverAddress,
+		Path:     path.Join("starlight", "admin", endpoint),
+		RawQuery: q.Encode(),
+	}
+	req, err := http.NewRequestWithContext(a.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if pwd, isSet := a.auth.Password(); isSet {
+		req.SetBasicAuth(a.auth.Username(), pwd)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var res ApiResponse
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("failed to parse response from proxy (status %d): %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server error (status %d): %s", resp.StatusCode, res.Error)
+	}
+	return &res, nil
+}
+
+// ListRepositories returns the repositories indexed by the proxy
+func (a *StarlightProxy) ListRepositories() ([]*RepositoryInfo, error) {
+	res, err := a.admin("repositories", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Repositories, nil
+}
+
+// ListTags returns the tags of the repository and the images they point to
+func (a *StarlightProxy) ListTags(repository string) ([]*TagInfo, error) {
+	res, err := a.admin("tags", url.Values{"repository": {repository}})
+	if err != nil {
+		return nil, err
+	}
+	return res.Tags, nil
+}
+
+// GetImageInfo returns the layers of the image with their sizes, file counts and rank coverage
+func (a *StarlightProxy) GetImageInfo(ref, platform string) (*ImageInfo, error) {
+	res, err := a.admin("image", url.Values{"ref": {ref}, "platform": {platform}})
+	if err != nil {
+		return nil, err
+	}
+	if res.Image == nil {
+		return nil, fmt.Errorf("image %s not found in the response", ref)
+	}
+	return res.Image, nil
+}
+
+// FetchContent returns the requested byte ranges of the compressed layer concatenated in the order of ranges,
+// the client uses it to fetch the chunks of a file that is accessed before the delta image reaches it
+func (a *StarlightProxy) FetchContent(ref, platform, layer string, ranges []common.ByteRange) (io.ReadCloser, error) {
+	u := url.URL{
+		Scheme: a.protocol,
+		Host:   a.serverAddress,
+		Path:   path.Join("starlight", "content"),
+	}
+	q := u.Query()
+	q.Set("ref", ref)
+	q.Set("platform", platform)
+	q.Set("layer", layer)
+	q.Set("ranges", common.FormatByteRanges(ranges))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(a.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if pwd, isSet := a.auth.Password(); isSet {
+		req.SetBasicAuth(a.auth.Username(), pwd)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var res ApiResponse
+		if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return nil, fmt.Errorf("failed to parse response from proxy (status %d): %v", resp.StatusCode, err)
+		}
+		return nil, fmt.Errorf("server error (status %d): %s", resp.StatusCode, res.Error)
+	}
+	return resp.Body, nil
+}
+
 func parseNumber(k, s string) (int64, error) {
 	if s == "" {
 		return 0, fmt.Errorf("header %s not found", k)
@@ -162,10 +298,7 @@ func getHeaderInt64(h *http.Header, k string) (int64, error) {
 	return parseNumber(k, h.Get(k))
 }
 
-func (a *StarlightProxy) DeltaImage(from, to, platform string, disableEarlyStart bool) (
-	reader io.ReadCloser,
-	metadata *common.DeltaImageMetadata,
-	err error) {
+func (a *StarlightProxy) newDeltaImageRequest(from, to, platform string, disableEarlyStart bool) (*http.Request, error) {
 	u := url.URL{
 		Scheme: a.protocol,
 		Host:   a.serverAddress,
@@ -181,18 +314,46 @@ func (a *StarlightProxy) DeltaImage(from, to, platform string, disableEarlyStart
 	}
 	u.RawQuery = q.Encode()
 
+	// the available layers are sent in the body, a worker could have hundreds of them
+	method, body := http.MethodGet, []byte(nil)
+	if len(a.availableLayers) > 0 {
+		buf, err := json.Marshal(&DeltaRequest{Layers: a.availableLayers})
+		if err != nil {
+			return nil, err
+		}
+		method, body = http.MethodPost, buf
+	}
+
+	req, err := http.NewRequestWithContext(a.ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if pwd, isSet := a.auth.Password(); isSet {
+		req.SetBasicAuth(a.auth.Username(), pwd)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return req, nil
+}
+
+func (a *StarlightProxy) DeltaImage(from, to, platform string, disableEarlyStart bool) (
+	reader io.ReadCloser,
+	metadata *common.DeltaImageMetadata,
+	err error) {
+
 	log.G(a.ctx).WithFields(logrus.Fields{
 		"from":     from,
 		"to":       to,
 		"platform": platform,
 	}).Info("request delta image")
 
-	var req *http.Request
-	req, err = http.NewRequestWithContext(a.ctx, "GET", u.String(), nil)
-	if pwd, isSet := a.auth.Password(); isSet {
-		req.SetBasicAuth(a.auth.Username(), pwd)
+	req, err := a.newDeltaImageRequest(from, to, platform, disableEarlyStart)
+	if err != nil {
+		return nil, nil, err
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
 	resp, err := a.client.Do(req)
 	if err != nil {
 		return nil, nil, err
@@ -267,6 +428,57 @@ func (a *StarlightProxy) DeltaImage(from, to, platform string, disableEarlyStart
 	return resp.Body, res, nil
 }
 
+// ResumeDeltaImage requests the rest of the delta image starting from offset (in the entire response,
+// including the header), it is used to resume an interrupted DeltaImage download.
+// starlightDigest is the Starlight-Digest of the delta image received earlier, if the proxy has a different
+// delta image for the same request, ResumeDeltaImage returns an error instead of the new delta image.
+func (a *StarlightProxy) ResumeDeltaImage(from, to, platform string, disableEarlyStart bool,
+	starlightDigest string, offset int64) (io.ReadCloser, error) {
+
+	log.G(a.ctx).WithFields(logrus.Fields{
+		"from":     from,
+		"to":       to,
+		"platform": platform,
+		"offset":   offset,
+	}).Info("resume delta image")
+
+	req, err := a.newDeltaImageRequest(from, to, platform, disableEarlyStart)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	req.Header.Set("If-Range", fmt.Sprintf(`"%s"`, starlightDigest))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("delta image has changed since the last request, cannot resume")
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		response, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		log.G(a.ctx).
+			WithFields(logrus.Fields{
+				"code":     fmt.Sprintf("%d", resp.StatusCode),
+				"version":  resp.Header.Get("Starlight-Version"),
+				"response": strings.TrimSpace(string(response)),
+			}).
+			WithError(err).
+			Error("server error")
+		return nil, fmt.Errorf("server error: %s", strings.TrimSpace(string(response)))
+	}
+
+	if d := resp.Header.Get("Starlight-Digest"); d != starlightDigest {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("delta image digest mismatch, expected %s but got %s", starlightDigest, d)
+	}
+
+	return resp.Body, nil
+}
+
 func (a *StarlightProxy) Report(body io.Reader) error {
 	u := url.URL{
 		Scheme: a.protocol,
@@ -303,10 +515,29 @@ func (a *StarlightProxy) Report(body io.Reader) error {
 	return nil
 }
 
+// SetAvailableLayers tells the proxy the layers (digests of the compressed layers) that are available on the
+// client besides the layers of the base image, so that the delta images do not include their contents
+func (a *StarlightProxy) SetAvailableLayers(layers []string) {
+	a.availableLayers = layers
+}
+
 func (a *StarlightProxy) SetAuth(username, password string) {
 	a.auth = *url.UserPassword(username, password)
 }
 
+// SetTLS verifies the proxy with the CA bundle (system roots if caFile is empty), and presents the client
+// certificate to the proxy if certFile and keyFile are set (mutual TLS). The files are reloaded once they change.
+func (a *StarlightProxy) SetTLS(caFile, certFile, keyFile string) error {
+	r, err := util.NewTLSReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = r.ClientConfig()
+	a.client.Transport = t
+	return nil
+}
+
 func NewStarlightProxy(ctx context.Context, protocol, server string) *StarlightProxy {
 	return &StarlightProxy{
 		ctx:           ctx,
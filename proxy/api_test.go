@@ -7,6 +7,9 @@ package proxy
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
 	"testing"
 )
 
@@ -18,3 +21,32 @@ func TestStarlightProxy_Ping(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestStarlightProxy_NewDeltaImageRequest(t *testing.T) {
+	proxy := NewStarlightProxy(context.TODO(), "http", "localhost:8090")
+
+	req, err := proxy.newDeltaImageRequest("", "starlight/redis:6.2.7", "linux/amd64", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodGet || req.ContentLength != 0 {
+		t.Errorf("expected a GET request without body but got %s (%d bytes)", req.Method, req.ContentLength)
+	}
+
+	layers := make([]string, 500)
+	for i := range layers {
+		layers[i] = fmt.Sprintf("sha256:%064x", i)
+	}
+	proxy.SetAvailableLayers(layers)
+	req, err = proxy.newDeltaImageRequest("", "starlight/redis:6.2.7", "linux/amd64", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPost || len(req.URL.String()) > 256 {
+		t.Errorf("expected the layers in the body but got %s %s", req.Method, req.URL)
+	}
+	var body DeltaRequest
+	if err = json.NewDecoder(req.Body).Decode(&body); err != nil || len(body.Layers) != len(layers) {
+		t.Errorf("expected %d layers in the body but got %d (%v)", len(layers), len(body.Layers), err)
+	}
+}
@@ -0,0 +1,515 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AnonymousUser matches the requests without credentials in AuthRule.Users
+	AnonymousUser = "anonymous"
+	// AnyUser matches any authenticated user in AuthRule.Users,
+	// any endpoint in AuthRule.Endpoints and any repository in AuthRule.Repositories
+	AnyUser = "*"
+
+	// allowed clock skew when validating the time claims of a JWT
+	jwtLeeway = 60 * time.Second
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type AuthRule struct {
+	// Users the rule applies to, "*" matches any authenticated user and "anonymous" matches
+	// the requests without credentials
+	Users []string `json:"users"`
+	// Endpoints the users could access (e.g. "/starlight/delta"), "*" matches all endpoints
+	Endpoints []string `json:"endpoints"`
+	// Repositories the users could access, it supports shell patterns (e.g. "library/*"), "*" matches all
+	// repositories. The repository name is the image name stored in the database, images from registries other than
+	// the default registry are prefixed with the registry (e.g. "docker.io/library/redis").
+	Repositories []string `json:"repositories"`
+}
+
+func (r *AuthRule) allows(user, endpoint string, repositories []string) bool {
+	if !matchAny(r.Users, user, func(p, v string) bool {
+		return p == v || (p == AnyUser && v != AnonymousUser)
+	}) {
+		return false
+	}
+	if !matchAny(r.Endpoints, endpoint, func(p, v string) bool {
+		return p == AnyUser || p == v
+	}) {
+		return false
+	}
+	for _, repo := range repositories {
+		if !matchAny(r.Repositories, repo, func(p, v string) bool {
+			if p == AnyUser {
+				return true
+			}
+			m, err := path.Match(p, v)
+			return err == nil && m
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(patterns []string, v string, match func(p, v string) bool) bool {
+	for _, p := range patterns {
+		if match(p, v) {
+			return true
+		}
+	}
+	return false
+}
+
+type AuthConfiguration struct {
+	// Htpasswd is the path to a htpasswd file (bcrypt or SHA1 hashes) for basic authentication
+	Htpasswd string `json:"htpasswd,omitempty"`
+
+	// Jwks is the path to a JSON Web Key Set file, bearer tokens signed by these keys are accepted,
+	// the subject of the token is the user name
+	Jwks        string `json:"jwks,omitempty"`
+	JwtIssuer   string `json:"jwt_issuer,omitempty"`
+	JwtAudience string `json:"jwt_audience,omitempty"`
+
+	// Rules grants access to the endpoints, the request is denied if no rule allows it
+	Rules []*AuthRule `json:"rules"`
+}
+
+// Authenticator identifies the user of a request
+type Authenticator interface {
+	// Authenticate returns the user name if the request carries valid credentials for this authenticator.
+	// ok is false if the request does not carry credentials that this authenticator understands.
+	Authenticate(req *http.Request) (user string, ok bool, err error)
+	// Challenge is the WWW-Authenticate header for unauthenticated requests
+	Challenge() string
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// htpasswd
+
+type HtpasswdAuthenticator struct {
+	users map[string]string
+}
+
+func (h *HtpasswdAuthenticator) Authenticate(req *http.Request) (user string, ok bool, err error) {
+	user, password, ok := req.BasicAuth()
+	if !ok {
+		return "", false, nil
+	}
+	hash, has := h.users[user]
+	if !has {
+		return "", true, ErrInvalidCredentials
+	}
+
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return "", true, ErrInvalidCredentials
+		}
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		if subtle.ConstantTimeCompare([]byte(hash[5:]), []byte(base64.StdEncoding.EncodeToString(sum[:]))) != 1 {
+			return "", true, ErrInvalidCredentials
+		}
+	default:
+		return "", true, fmt.Errorf("unsupported password hash for user %s", user)
+	}
+	return user, true, nil
+}
+
+func (h *HtpasswdAuthenticator) Challenge() string {
+	return `Basic realm="starlight-proxy"`
+}
+
+func NewHtpasswdAuthenticator(p string) (*HtpasswdAuthenticator, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open htpasswd file")
+	}
+	defer f.Close()
+
+	h := &HtpasswdAuthenticator{users: make(map[string]string)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sp := strings.SplitN(line, ":", 2)
+		if len(sp) != 2 {
+			return nil, fmt.Errorf("failed to parse htpasswd line %q", line)
+		}
+		h.users[sp[0]] = sp[1]
+	}
+	if err = sc.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read htpasswd file")
+	}
+	return h, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// JWT + JWKS
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+}
+
+func (c *jwtClaims) hasAudience(aud string) bool {
+	var single string
+	if json.Unmarshal(c.Audience, &single) == nil {
+		return single == aud
+	}
+	var multiple []string
+	if json.Unmarshal(c.Audience, &multiple) == nil {
+		for _, a := range multiple {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type JwksAuthenticator struct {
+	keys     map[string]crypto.PublicKey
+	issuer   string
+	audience string
+}
+
+func (j *JwksAuthenticator) Authenticate(req *http.Request) (user string, ok bool, err error) {
+	h := req.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false, nil
+	}
+	claims, err := j.verify(strings.TrimSpace(h[7:]))
+	if err != nil {
+		return "", true, errors.Wrapf(ErrInvalidCredentials, "%v", err)
+	}
+	return claims.Subject, true, nil
+}
+
+func (j *JwksAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "malformed token header")
+	}
+	if err = json.Unmarshal(buf, &header); err != nil {
+		return nil, errors.Wrapf(err, "malformed token header")
+	}
+
+	key, has := j.keys[header.Kid]
+	if !has && header.Kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			key, has = k, true
+		}
+	}
+	if !has {
+		return nil, fmt.Errorf("unknown key %q", header.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "malformed token signature")
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := &jwtClaims{}
+	if buf, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, errors.Wrapf(err, "malformed token claims")
+	}
+	if err = json.Unmarshal(buf, claims); err != nil {
+		return nil, errors.Wrapf(err, "malformed token claims")
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == nil || now.After(time.Unix(*claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0).Add(-jwtLeeway)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	if j.issuer != "" && claims.Issuer != j.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if j.audience != "" && !claims.hasAudience(j.audience) {
+		return nil, fmt.Errorf("unexpected audience")
+	}
+	if claims.Subject == "" || claims.Subject == AnonymousUser {
+		return nil, fmt.Errorf("invalid subject %q", claims.Subject)
+	}
+	return claims, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	var h crypto.Hash
+	switch alg[2:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, h, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, h, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		if alg[:2] == "ES" {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if len(sig) != 2*size {
+				return fmt.Errorf("invalid signature length")
+			}
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if !ecdsa.Verify(k, digest, r, s) {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("algorithm %s does not match the key", alg)
+}
+
+func (j *JwksAuthenticator) Challenge() string {
+	return `Bearer realm="starlight-proxy"`
+}
+
+func NewJwksAuthenticator(p, issuer, audience string) (*JwksAuthenticator, error) {
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read jwks file")
+	}
+	var set struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err = json.Unmarshal(buf, &set); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse jwks file")
+	}
+
+	j := &JwksAuthenticator{
+		keys:     make(map[string]crypto.PublicKey),
+		issuer:   issuer,
+		audience: audience,
+	}
+	for _, k := range set.Keys {
+		pk, err := k.publicKey()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse key %q", k.Kid)
+		}
+		j.keys[k.Kid] = pk
+	}
+	return j, nil
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// Client Certificate
+
+// CertificateAuthenticator uses the common name of the verified client certificate (mutual TLS) as the user name
+type CertificateAuthenticator struct{}
+
+func (c *CertificateAuthenticator) Authenticate(req *http.Request) (user string, ok bool, err error) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", false, nil
+	}
+	user = req.TLS.VerifiedChains[0][0].Subject.CommonName
+	if user == "" || user == AnonymousUser {
+		return "", true, ErrInvalidCredentials
+	}
+	return user, true, nil
+}
+
+func (c *CertificateAuthenticator) Challenge() string {
+	return ""
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// Authorizer
+
+// Authorizer authenticates the requests using the configured authenticators and checks the rules
+type Authorizer struct {
+	authenticators []Authenticator
+	rules          []*AuthRule
+}
+
+// Authenticate returns the user of the request, or "anonymous" if the request carries no credentials
+func (a *Authorizer) Authenticate(req *http.Request) (string, error) {
+	for _, au := range a.authenticators {
+		user, ok, err := au.Authenticate(req)
+		if !ok {
+			continue
+		}
+		return user, err
+	}
+	return AnonymousUser, nil
+}
+
+// Allowed returns true if any rule allows the user to access the endpoint and all the repositories
+func (a *Authorizer) Allowed(user, endpoint string, repositories []string) bool {
+	for _, r := range a.rules {
+		if r.allows(user, endpoint, repositories) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authorizer) Challenges() []string {
+	res := make([]string, 0, len(a.authenticators))
+	for _, au := range a.authenticators {
+		if c := au.Challenge(); c != "" {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// NewAuthorizer creates the authenticators in the configuration, additional authenticators are tried
+// after the configured ones
+func NewAuthorizer(cfg *AuthConfiguration, authenticators ...Authenticator) (*Authorizer, error) {
+	a := &Authorizer{
+		authenticators: make([]Authenticator, 0),
+		rules:          cfg.Rules,
+	}
+	if cfg.Htpasswd != "" {
+		h, err := NewHtpasswdAuthenticator(cfg.Htpasswd)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, h)
+	}
+	if cfg.Jwks != "" {
+		j, err := NewJwksAuthenticator(cfg.Jwks, cfg.JwtIssuer, cfg.JwtAudience)
+		if err != nil {
+			return nil, err
+		}
+		a.authenticators = append(a.authenticators, j)
+	}
+	a.authenticators = append(a.authenticators, authenticators...)
+	return a, nil
+}
@@ -0,0 +1,215 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signToken(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		s, err := rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestHtpasswdAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "htpasswd")
+	content := fmt.Sprintf("# users\nalice:%s\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", hash)
+	if err = os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHtpasswdAuthenticator(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "secret", true},
+		{"eve", "secret", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(c.user, c.password)
+		user, ok, err := h.Authenticate(req)
+		if !ok {
+			t.Fatalf("%s: basic auth should be handled", c.user)
+		}
+		if (err == nil) != c.ok || (c.ok && user != c.user) {
+			t.Errorf("%s: expected ok=%v but got user=%q err=%v", c.user, c.ok, user, err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	if _, ok, _ := h.Authenticate(req); ok {
+		t.Error("request without credentials should not be handled")
+	}
+}
+
+func TestJwksAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		},
+	})
+	p := filepath.Join(t.TempDir(), "jwks.json")
+	if err = os.WriteFile(p, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+	j, err := NewJwksAuthenticator(p, "issuer", "starlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": "starlight", "exp": exp}
+	cases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"rsa", signToken(t, "RS256", "rsa", rsaKey, valid), true},
+		{"ec", signToken(t, "ES256", "ec", ecKey, valid), true},
+		{"wrong key", signToken(t, "RS256", "ec", rsaKey, valid), false},
+		{"unknown key", signToken(t, "RS256", "other", rsaKey, valid), false},
+		{"expired", signToken(t, "RS256", "rsa", rsaKey,
+			map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": "starlight", "exp": 1000}), false},
+		{"audience", signToken(t, "RS256", "rsa", rsaKey,
+			map[string]interface{}{"sub": "alice", "iss": "issuer", "aud": []string{"other"}, "exp": exp}), false},
+		{"none", b64([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + b64([]byte(`{"sub":"alice"}`)) + ".", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		user, ok, err := j.Authenticate(req)
+		if !ok {
+			t.Fatalf("%s: bearer token should be handled", c.name)
+		}
+		if (err == nil) != c.ok || (c.ok && user != "alice") {
+			t.Errorf("%s: expected ok=%v but got user=%q err=%v", c.name, c.ok, user, err)
+		}
+	}
+}
+
+func TestAuthorizer_Allowed(t *testing.T) {
+	a, err := NewAuthorizer(&AuthConfiguration{
+		Rules: []*AuthRule{
+			{Users: []string{AnonymousUser, AnyUser}, Endpoints: []string{"/starlight/delta"}, Repositories: []string{"public/*"}},
+			{Users: []string{"alice"}, Endpoints: []string{AnyUser}, Repositories: []string{AnyUser}},
+			{Users: []string{AnyUser}, Endpoints: []string{"/starlight/delta"}, Repositories: []string{"team/*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		user, endpoint string
+		repos          []string
+		ok             bool
+	}{
+		{AnonymousUser, "/starlight/delta", []string{"public/redis"}, true},
+		{AnonymousUser, "/starlight/delta", []string{"team/redis"}, false},
+		{AnonymousUser, "/starlight/notify", []string{"public/redis"}, false},
+		{"bob", "/starlight/delta", []string{"public/redis", "team/redis"}, false},
+		{"bob", "/starlight/delta", []string{"team/redis"}, true},
+		{"bob", "/starlight/report", nil, false},
+		{"alice", "/starlight/notify", []string{"docker.io/library/redis"}, true},
+	}
+	for _, c := range cases {
+		if ok := a.Allowed(c.user, c.endpoint, c.repos); ok != c.ok {
+			t.Errorf("%s %s %v: expected %v", c.user, c.endpoint, c.repos, c.ok)
+		}
+	}
+}
+
+func TestServer_ReportAuth(t *testing.T) {
+	auth, err := NewAuthorizer(&AuthConfiguration{
+		Rules: []*AuthRule{
+			{Users: []string{AnonymousUser}, Endpoints: []string{"/starlight/delta"}, Repositories: []string{AnyUser}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Server{ctx: context.Background(), config: NewConfig(), auth: auth}
+
+	// the request is rejected before the traces are parsed and looked up in the database
+	w := httptest.NewRecorder()
+	a.report(w, httptest.NewRequest("POST", "/starlight/report", strings.NewReader("not a trace")))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized but got %d", w.Code)
+	}
+}
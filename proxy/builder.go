@@ -28,6 +28,9 @@ import (
 	"math"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/containerd/containerd/log"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -41,6 +44,13 @@ import (
 
 ////////////////////////////////////////////////
 
+const (
+	// byte ranges of a layer that are less than rangeCoalesceGap apart are fetched in one request
+	rangeCoalesceGap = 64 * 1024
+	// fetch the entire layer if the delta image needs more than rangeFullLayerRatio of it
+	rangeFullLayerRatio = 0.8
+)
+
 type Builder struct {
 	send.DeltaBundle
 
@@ -49,10 +59,45 @@ type Builder struct {
 
 	manifest, config []byte
 	manifestDigest   string
+	header           *deltaHeader
 
 	disableSorting bool
 
 	unavailableLayers, availableLayers []*send.ImageLayer
+
+	// partial is set if the client requests a byte range of the delta image (resuming a download),
+	// rangeStart and rangeEnd (inclusive) are offsets in the entire response including the header
+	partial              bool
+	rangeStart, rangeEnd int64
+	headerSize           int64
+
+	// fetching tracks the layers being fetched from the registry, the key is the stack index of the layer
+	// in the requested image. WriteBody waits for the layer of each content, so that it could start
+	// sending the contents before all the layers have arrived.
+	fetching map[int64]*layerFetch
+}
+
+type layerFetch struct {
+	done chan interface{}
+	err  error
+}
+
+// waitForLayer blocks until the layer at stack has been fetched, the contents written so far are
+// flushed to the client before waiting
+func (b *Builder) waitForLayer(w http.ResponseWriter, stack int64) error {
+	f, has := b.fetching[stack]
+	if !has {
+		return nil
+	}
+	select {
+	case <-f.done:
+	default:
+		if fl, ok := w.(http.Flusher); ok {
+			fl.Flush()
+		}
+		<-f.done
+	}
+	return f.err
 }
 
 func (b *Builder) String() string {
@@ -62,56 +107,57 @@ func (b *Builder) String() string {
 	return fmt.Sprintf("Builder ()->(%s)", b.Destination.Ref.String())
 }
 
-func (b *Builder) WriteHeader(w http.ResponseWriter, req *http.Request) error {
+// buildHeader compresses the manifest, config and the Starlight header (the delta bundle)
+func (b *Builder) buildHeader() (*deltaHeader, error) {
 	buf := bytes.NewBuffer(make([]byte, 0))
 	cw := util.NewCountWriter(buf)
 
 	// manifest
 	gwManifest, err := gzip.NewWriterLevel(cw, gzip.BestCompression)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_, err = gwManifest.Write(b.manifest)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = gwManifest.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	manifestSize := cw.GetWrittenSize()
 
 	// config
 	gwConfig, err := gzip.NewWriterLevel(cw, gzip.BestCompression)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_, err = gwConfig.Write(b.config)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = gwConfig.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	configSize := cw.GetWrittenSize() - manifestSize
 
 	// header
 	h, err := json.Marshal(b)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	gwHeader, err := gzip.NewWriterLevel(cw, gzip.BestCompression)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	_, err = gwHeader.Write(h)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = gwHeader.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	headerSize := cw.GetWrittenSize() - manifestSize - configSize
 
@@ -125,39 +171,122 @@ func (b *Builder) WriteHeader(w http.ResponseWriter, req *http.Request) error {
 		WithField("_digest", slDigest.String()).
 		Info("generated response header")
 
+	return &deltaHeader{
+		buf:                 buf.Bytes(),
+		manifestSize:        manifestSize,
+		configSize:          configSize,
+		starlightHeaderSize: headerSize,
+		digest:              slDigest,
+	}, nil
+}
+
+func (b *Builder) WriteHeader(w http.ResponseWriter, req *http.Request) (err error) {
+	h := b.header
+	slDigest := h.digest
+
 	// output header
 	//
 	// Content-Length equeals
 	// compressed(Starlight-Header-Size) + compressed(Manifest-Size) + compressed((Config-Size) + Payload-Size
-	httpLength := cw.GetWrittenSize() + b.BodyLength
+	b.headerSize = int64(len(h.buf))
+	httpLength := b.headerSize + b.BodyLength
 
 	header := w.Header()
 	header.Set("Content-Type", "application/octet-stream")
-	header.Set("Content-Length", fmt.Sprintf("%d", httpLength))
-	header.Set("Starlight-Header-Size", fmt.Sprintf("%d", headerSize))
-	header.Set("Manifest-Size", fmt.Sprintf("%d", manifestSize))
-	header.Set("Config-Size", fmt.Sprintf("%d", configSize))
+	header.Set("Starlight-Header-Size", fmt.Sprintf("%d", h.starlightHeaderSize))
+	header.Set("Manifest-Size", fmt.Sprintf("%d", h.manifestSize))
+	header.Set("Config-Size", fmt.Sprintf("%d", h.configSize))
 	header.Set("Digest", b.manifestDigest)
 	header.Set("Starlight-Digest", slDigest.String())
 	header.Set("Starlight-Version", util.Version)
 	header.Set("Content-Disposition", `attachment; filename="starlight.tgz"`)
-	w.WriteHeader(http.StatusOK)
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("ETag", fmt.Sprintf(`"%s"`, slDigest.String()))
+
+	// resumable download
+	// the client could ask for the rest of the delta image using the Range header, and it should also provide
+	// the Starlight-Digest it has received in the If-Range header. If the digest does not match (the delta image
+	// has changed since last time), we send the entire delta image.
+	b.partial = false
+	if rg := req.Header.Get("Range"); rg != "" && matchIfRange(req.Header.Get("If-Range"), slDigest.String()) {
+		start, end, ok, err := parseRange(rg, httpLength)
+		if err != nil {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", httpLength))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return err
+		}
+		if ok {
+			b.partial, b.rangeStart, b.rangeEnd = true, start, end
+		}
+	}
+
+	if !b.partial {
+		header.Set("Content-Length", fmt.Sprintf("%d", httpLength))
+		w.WriteHeader(http.StatusOK)
+		_, err = w.Write(h.buf)
+		return err
+	}
 
-	_, err = w.Write(buf.Bytes())
+	log.G(b.server.ctx).
+		WithField("start", b.rangeStart).
+		WithField("end", b.rangeEnd).
+		WithField("_digest", slDigest.String()).
+		Info("resume delta image")
+
+	header.Set("Content-Length", fmt.Sprintf("%d", b.rangeEnd-b.rangeStart+1))
+	header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", b.rangeStart, b.rangeEnd, httpLength))
+	w.WriteHeader(http.StatusPartialContent)
+	if b.rangeStart < b.headerSize {
+		end := b.headerSize
+		if b.rangeEnd+1 < end {
+			end = b.rangeEnd + 1
+		}
+		_, err = w.Write(h.buf[b.rangeStart:end])
+	}
 	return err
 }
 
 func (b *Builder) WriteBody(w http.ResponseWriter, req *http.Request) error {
 	layers := b.Destination.Layers
 
+	// requested range of the body, [start, end)
+	start, end := int64(0), b.BodyLength
+	if b.partial {
+		start, end = b.rangeStart-b.headerSize, b.rangeEnd-b.headerSize+1
+		if start < 0 {
+			start = 0
+		}
+	}
+
 	// output body
 	for _, c := range b.Contents {
+		// skip contents that are out of the requested range
+		if c.Offset+c.Size <= start || c.Offset >= end {
+			continue
+		}
+		// contents are sorted by rank, send them as soon as their layers arrive
+		if err := b.waitForLayer(w, c.Stack); err != nil {
+			return errors.Wrapf(err, "failed to load layer %d for file [%s]", c.Stack, c.Files[0].Name)
+		}
 		// fmt.Println(c.files[0].Name, len(c.Chunks), c.Stack, len(c.files), c.Offset, c.Size, layers[c.Stack].blob)
 		if layer := layers[c.Stack]; layer.Blob != nil {
 			sr := io.NewSectionReader(layer.Blob.Buffer, 0, layer.UncompressedSize)
+			pos := c.Offset
 			for _, chunk := range c.Chunks {
-				ssr := io.NewSectionReader(sr, chunk.Offset, chunk.CompressedSize)
-				_, err := io.CopyN(w, ssr, chunk.CompressedSize)
+				lo, hi := pos, pos+chunk.CompressedSize
+				pos = hi
+				if hi <= start || lo >= end {
+					continue
+				}
+				if lo < start {
+					lo = start
+				}
+				if hi > end {
+					hi = end
+				}
+				skip := lo - (pos - chunk.CompressedSize)
+				ssr := io.NewSectionReader(sr, chunk.Offset+skip, hi-lo)
+				_, err := io.CopyN(w, ssr, hi-lo)
 				if err != nil {
 					return errors.Wrapf(err, "failed to copy chunk at [%d] for file [%s]", chunk.Offset, c.Files[0].Name)
 				}
@@ -169,51 +298,129 @@ func (b *Builder) WriteBody(w http.ResponseWriter, req *http.Request) error {
 	return nil
 }
 
-func (b *Builder) fetchLayers(cache *send.ImageLayer) error {
-	var (
-		c   *common.LayerCache
-		has bool
-	)
+// matchIfRange returns true if the If-Range header is absent or it matches the Starlight-Digest of
+// the delta image. Both the quoted (ETag) and the unquoted form of the digest are accepted.
+func matchIfRange(ifRange, slDigest string) bool {
+	if ifRange == "" {
+		return true
+	}
+	return strings.Trim(strings.TrimPrefix(ifRange, "W/"), `"`) == slDigest
+}
 
-	b.server.cacheMutex.Lock()
-	if c, has = b.server.cache[cache.Hash]; has {
-		b.server.cacheMutex.Unlock()
+// parseRange parses a single byte range in the Range header (e.g. "bytes=100-" or "bytes=100-199")
+// of a response that has size bytes. The returned end is inclusive.
+// If ok is false, the range should be ignored and the entire response should be sent.
+func parseRange(s string, size int64) (start, end int64, ok bool, err error) {
+	const b = "bytes="
+	if !strings.HasPrefix(s, b) {
+		return 0, 0, false, nil
+	}
+	spec := strings.TrimSpace(s[len(b):])
+	if strings.Contains(spec, ",") {
+		// multiple ranges are not supported, send the entire response instead
+		return 0, 0, false, nil
+	}
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, false, fmt.Errorf("invalid range %q", s)
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// suffix range, the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, fmt.Errorf("invalid range %q", s)
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, fmt.Errorf("range %q not satisfiable", s)
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, fmt.Errorf("invalid range %q", s)
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true, nil
+}
 
-		sub := make(chan error)
-		c.Subscribe(&sub)
-		err := <-sub
-		if err != nil {
-			log.G(b.server.ctx).
-				WithField("layer", cache.String()).
-				Error(errors.Wrapf(err, "failed to load layer"))
-			return err
+// neededRanges returns the coalesced byte ranges of the compressed layers required by the delta image, the key of the
+// map is the stack index of the layer in the requested image
+func (b *Builder) neededRanges() map[int64][]common.ByteRange {
+	ranges := make(map[int64][]common.ByteRange)
+	for _, c := range b.Contents {
+		for _, chunk := range c.Chunks {
+			ranges[c.Stack] = append(ranges[c.Stack], common.ByteRange{
+				Offset: chunk.Offset,
+				Length: chunk.CompressedSize,
+			})
 		}
-		log.G(b.server.ctx).
-			WithField("layer", cache.String()).
-			WithField("shared", true).
-			Info("fetched layer")
+	}
+	for stack, r := range ranges {
+		ranges[stack] = common.CoalesceRanges(r, rangeCoalesceGap)
+	}
+	return ranges
+}
+
+func (b *Builder) fetchLayers(cache *send.ImageLayer, ranges []common.ByteRange) error {
+	var (
+		c      *common.LayerCache
+		shared bool
+		err    error
+	)
+
+	// fetch the entire layer if we need most of it anyway
+	needed := int64(0)
+	for _, r := range ranges {
+		needed += r.Length
+	}
+	if float64(needed) < float64(cache.UncompressedSize)*rangeFullLayerRatio {
+		c, shared, err = b.server.cache.FetchRanges(b.server.ctx, cache, ranges)
 	} else {
-		c = common.NewLayerCache(cache)
-		b.server.cache[cache.Hash] = c
-		b.server.cacheMutex.Unlock()
-
-		if err := c.Load(b.server.ctx); err != nil {
-			log.G(b.server.ctx).
-				WithField("layer", cache.String()).
-				Error(errors.Wrapf(err, "failed to load layer"))
-			return err
-		}
+		c, shared, err = b.server.cache.Fetch(b.server.ctx, cache)
+	}
+	if err != nil {
 		log.G(b.server.ctx).
 			WithField("layer", cache.String()).
-			WithField("shared", false).
-			Info("fetched layer")
+			Error(errors.Wrapf(err, "failed to load layer"))
+		return err
 	}
+	layerFetchCounter.WithLabelValues(strconv.FormatBool(shared)).Inc()
+	log.G(b.server.ctx).
+		WithField("layer", cache.String()).
+		WithField("shared", shared).
+		WithField("ranges", len(ranges)).
+		WithField("needed", needed).
+		Info("fetched layer")
 
 	cache.Blob = c
 
 	return nil
 }
 
+// Release returns the layers fetched by the builder to the layer cache,
+// it should be called once the delta image has been sent
+func (b *Builder) Release() {
+	for _, f := range b.fetching {
+		<-f.done
+	}
+	for _, l := range b.unavailableLayers {
+		if l.Blob != nil {
+			b.server.cache.Release(l.Blob)
+			l.Blob = nil
+		}
+	}
+}
+
 // getImage returns the image with the given reference and the platform.
 // if you need to find out the available image, you should better use getImageByDigest which returns
 // the exact image that is available as tags might be changed but the digest will not change.
@@ -295,6 +502,9 @@ func (b *Builder) getUnavailableLayers() (layers []*send.ImageLayer, err error)
 			dedup[v.Hash] = true
 		}
 	}
+	for _, v := range b.Available {
+		dedup[v.Hash] = true
+	}
 	for _, v := range b.Destination.Layers {
 		if _, has := dedup[v.Hash]; !has {
 			layers = append(layers, v)
@@ -313,6 +523,11 @@ func (b *Builder) computeDelta() error {
 		3. identify the best reference to the file content
 		4. sort file contents
 	*/
+	start := time.Now()
+	defer func() {
+		computeDeltaDuration.Observe(time.Since(start).Seconds())
+	}()
+
 	unavailable, available := make([]*send.ImageLayer, 0), make([]*send.ImageLayer, 0)
 	availableIds := make(map[int64]send.LayerSource)
 
@@ -327,6 +542,11 @@ func (b *Builder) computeDelta() error {
 		}
 	}
 
+	for _, layer := range b.Available {
+		available = append(available, layer)
+		availableIds[layer.Serial] = send.FromSource
+	}
+
 	for _, layer := range b.Destination.Layers {
 		if layer.Available {
 			available = append(available, layer)
@@ -404,8 +624,17 @@ func (b *Builder) computeDelta() error {
 		Info("find requested file contents")
 
 	// 3. identify the best reference to the file content
+	// iterate in a stable order, so that the same request always yields the same delta image (and the same
+	// Starlight-Digest), otherwise the client cannot resume an interrupted download
+	requestedDigests := make([]string, 0, len(deduplicatedRequestedContents))
+	for d := range deduplicatedRequestedContents {
+		requestedDigests = append(requestedDigests, d)
+	}
+	sort.Strings(requestedDigests)
+
 	b.Contents = make([]*send.Content, 0)
-	for d, reqContent := range deduplicatedRequestedContents {
+	for _, d := range requestedDigests {
+		reqContent := deduplicatedRequestedContents[d]
 		if existingContent, has := deduplicatedExistingFiles[d]; has {
 			// found requested file in existing files
 			for _, r := range reqContent.Files {
@@ -430,7 +659,7 @@ func (b *Builder) computeDelta() error {
 	}
 
 	if !b.disableSorting {
-		sort.Sort(send.ByRank(b.Contents))
+		sort.Stable(send.ByRank(b.Contents))
 	} else {
 		log.G(b.server.ctx).WithField("builder", b).Info("sorting disabled as requested")
 	}
@@ -454,6 +683,8 @@ func (b *Builder) computeDelta() error {
 					ChunkOffset:    chunk.ChunkOffset,
 					ChunkSize:      chunk.ChunkSize,
 					CompressedSize: chunk.CompressedSize,
+					Compression:    chunk.Compression,
+					ChunkDigest:    chunk.ChunkDigest,
 				})
 			}
 		} else {
@@ -463,6 +694,8 @@ func (b *Builder) computeDelta() error {
 				ChunkOffset:    c.Files[0].ChunkOffset,
 				ChunkSize:      c.Files[0].ChunkSize,
 				CompressedSize: c.Files[0].CompressedSize,
+				Compression:    c.Files[0].Compression,
+				ChunkDigest:    c.Files[0].ChunkDigest,
 			}}
 		}
 
@@ -477,20 +710,14 @@ func (b *Builder) computeDelta() error {
 		WithField("_step", 3).
 		Info("find the best file content references")
 
+	deltaBodyBytes.Observe(float64(b.BodyLength))
 	return nil
 }
 
-func (b *Builder) Load() error {
-	// Load compressed layers from registry
+// computePlan computes the delta and the header of the delta image
+func (b *Builder) computePlan() (*deltaPlan, error) {
 	var errGrp errgroup.Group
 
-	for _, layer := range b.unavailableLayers {
-		layer := layer
-		errGrp.Go(func() error {
-			return b.fetchLayers(layer)
-		})
-	}
-
 	// Load manifest and config from proxy's database
 	errGrp.Go(func() error {
 		if c, m, d, err := b.getManifestAndConfig(b.Destination.Serial); err != nil {
@@ -503,12 +730,176 @@ func (b *Builder) Load() error {
 		}
 	})
 
+	// signature attestation of the image, the client verifies it with its own keys
+	errGrp.Go(func() error {
+		sig, err := b.server.db.GetImageSignature(b.Destination.Serial)
+		if err != nil || sig == nil {
+			return err
+		}
+		b.Signature = &common.SignatureAttestation{}
+		return json.Unmarshal(sig, b.Signature)
+	})
+
 	// Computer the difference between the requested and existing files
 	errGrp.Go(b.computeDelta)
 
-	// Wait for all jobs to end
 	if err := errGrp.Wait(); err != nil {
-		return errors.Wrapf(err, "failed to load all compressed layer")
+		return nil, errors.Wrapf(err, "failed to compute delta image")
+	}
+
+	header, err := b.buildHeader()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to build delta image header")
+	}
+
+	return &deltaPlan{
+		contents:       b.Contents,
+		requestedFiles: b.RequestedFiles,
+		bodyLength:     b.BodyLength,
+		manifestDigest: b.manifestDigest,
+		header:         header,
+		layers:         b.destinationLayers(),
+	}, nil
+}
+
+// destinationLayers returns the filesystem serials of the destination image
+func (b *Builder) destinationLayers() map[int64]bool {
+	layers := make(map[int64]bool, len(b.Destination.Layers))
+	for _, l := range b.Destination.Layers {
+		layers[l.Serial] = true
+	}
+	return layers
+}
+
+// planKey identifies the plan by the available layers, which are pruned to the ones the delta image depends on
+func (b *Builder) planKey() deltaPlanKey {
+	key := deltaPlanKey{
+		destination: b.Destination.Serial,
+		sorted:      !b.disableSorting,
+	}
+	if b.Source != nil {
+		key.source = b.Source.Serial
+	}
+	serials := make([]int64, 0, len(b.Available))
+	for _, l := range b.Available {
+		serials = append(serials, l.Serial)
+	}
+	sort.Slice(serials, func(i, j int) bool {
+		return serials[i] < serials[j]
+	})
+	available := make([]string, 0, len(serials))
+	for _, l := range serials {
+		available = append(available, strconv.FormatInt(l, 10))
+	}
+	key.available = strings.Join(available, ",")
+	return key
+}
+
+// pruneAvailable keeps the available layers of the destination image and the other available layers that
+// have the contents of the requested files. The delta image only depends on these layers, so the clients
+// that have the same of them share the plan whatever else they have.
+func (b *Builder) pruneAvailable() error {
+	destination := b.destinationLayers()
+	others := make([]*send.ImageLayer, 0, len(b.Available))
+	for _, l := range b.Available {
+		if !destination[l.Serial] {
+			others = append(others, l)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+
+	// the files in the available layers are not requested, same as computeDelta
+	available := make(map[int64]bool)
+	if b.Source != nil {
+		for _, l := range b.Source.Layers {
+			if l.Available {
+				available[l.Serial] = true
+			}
+		}
+	}
+	for _, l := range b.Available {
+		available[l.Serial] = true
+	}
+	for _, l := range b.Destination.Layers {
+		if l.Available {
+			available[l.Serial] = true
+		}
+	}
+
+	files, err := b.server.db.GetFilesWithoutRanks(b.Destination.Serial)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get requested files")
+	}
+	requested := make(map[string]bool)
+	for _, f := range files {
+		if f.Digest != "" && f.Size != 0 && !available[f.FsId] {
+			requested[f.Digest] = true
+		}
+	}
+
+	existing, err := b.server.db.GetUniqueFiles(others)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get existing files")
+	}
+	referenced := make(map[int64]bool)
+	for _, f := range existing {
+		if requested[f.Digest] {
+			referenced[f.FsId] = true
+		}
+	}
+
+	kept := make([]*send.ImageLayer, 0, len(b.Available))
+	for _, l := range b.Available {
+		if destination[l.Serial] || referenced[l.Serial] {
+			kept = append(kept, l)
+		}
+	}
+	b.Available = kept
+	return nil
+}
+
+// plan returns the plan of the delta image, identical requests (e.g. rolling out an update to many workers)
+// share the same plan
+func (b *Builder) plan() (plan *deltaPlan, hit bool, err error) {
+	if err = b.pruneAvailable(); err != nil {
+		return nil, false, err
+	}
+	return b.server.plans.Get(b.planKey(), b.computePlan)
+}
+
+func (b *Builder) Load() error {
+	plan, hit, err := b.plan()
+	if err != nil {
+		return err
+	}
+	if hit {
+		log.G(b.server.ctx).
+			WithField("builder", b).
+			WithField("_digest", plan.header.digest.String()).
+			Debug("found delta plan in cache")
+	}
+	b.Contents = plan.contents
+	b.RequestedFiles = plan.requestedFiles
+	b.BodyLength = plan.bodyLength
+	b.manifestDigest = plan.manifestDigest
+	b.header = plan.header
+
+	// Load compressed layers from registry in the background,
+	// only the byte ranges used by the delta image are needed
+	b.fetching = make(map[int64]*layerFetch)
+	for stack, r := range b.neededRanges() {
+		layer, r := b.Destination.Layers[stack], r
+		if layer.Available {
+			continue
+		}
+		f := &layerFetch{done: make(chan interface{})}
+		b.fetching[stack] = f
+		go func() {
+			defer close(f.done)
+			f.err = b.fetchLayers(layer, r)
+		}()
 	}
 
 	return nil
@@ -543,11 +934,27 @@ func NewBuilder(server *Server, src, dst, plt string, disableSorting bool) (b *B
 		}
 	}
 
-	if b.Source != nil {
-		b.availableLayers = b.Source.Layers
+	if err = b.markAvailableLayers(); err != nil {
+		return nil, err
+	}
+
+	b.disableSorting = disableSorting
+
+	return b, nil
+}
+
+// markAvailableLayers sets the available and unavailable layers using the source image and the other
+// available layers on the client
+func (b *Builder) markAvailableLayers() (err error) {
+	if b.Source != nil || len(b.Available) > 0 {
+		b.availableLayers = make([]*send.ImageLayer, 0, len(b.Available))
+		if b.Source != nil {
+			b.availableLayers = append(b.availableLayers, b.Source.Layers...)
+		}
+		b.availableLayers = append(b.availableLayers, b.Available...)
 		b.unavailableLayers, err = b.getUnavailableLayers()
 		if err != nil {
-			return nil, err
+			return err
 		}
 	} else {
 		b.availableLayers = []*send.ImageLayer{}
@@ -560,8 +967,55 @@ func NewBuilder(server *Server, src, dst, plt string, disableSorting bool) (b *B
 	for _, u := range b.unavailableLayers {
 		u.Available = false
 	}
+	return nil
+}
 
-	b.disableSorting = disableSorting
+// SetAvailable adds the layers of the images (references with digest) and the layers (digests of the
+// compressed layers) to the available layers, so that the delta image reuses the contents of every image on the
+// client, not only the source image. Images and layers unknown to the proxy are ignored.
+func (b *Builder) SetAvailable(images, layers []string) error {
+	known := make(map[int64]bool)
+	if b.Source != nil {
+		for _, l := range b.Source.Layers {
+			known[l.Serial] = true
+		}
+	}
+	for _, l := range b.Available {
+		known[l.Serial] = true
+	}
+	add := func(ls []*send.ImageLayer) {
+		for _, l := range ls {
+			if !known[l.Serial] {
+				known[l.Serial] = true
+				b.Available = append(b.Available, l)
+			}
+		}
+	}
 
-	return b, nil
+	for _, ref := range images {
+		img, err := b.getImageByDigest(ref)
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				log.G(b.server.ctx).WithField("image", ref).Debug("available image not found")
+				continue
+			}
+			return errors.Wrapf(err, "failed to get available image")
+		}
+		add(img.Layers)
+	}
+
+	if len(layers) > 0 {
+		ls, err := b.server.db.GetLayersByDigests(layers)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get available layers")
+		}
+		add(ls)
+	}
+
+	// keep the order stable, so that the same request always yields the same delta image
+	sort.SliceStable(b.Available, func(i, j int) bool {
+		return b.Available[i].Serial < b.Available[j].Serial
+	})
+
+	return b.markAvailableLayers()
 }
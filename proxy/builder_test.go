@@ -19,14 +19,21 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/containerd/containerd/log"
 	"github.com/mc256/starlight/test"
 	"github.com/mc256/starlight/util/common"
+	"github.com/mc256/starlight/util/send"
 )
 
 //
@@ -44,7 +51,7 @@ func InitDatabase() (context.Context, *Configuration, *Server) {
 			Addr: fmt.Sprintf("%s:%d", cfg.ListenAddress, cfg.ListenPort),
 		},
 		config: cfg,
-		cache:  make(map[string]*common.LayerCache),
+		cache:  common.NewLayerCachePool(common.NewMemoryCacheBackend(), cfg.CacheSize),
 	}
 	if db, err := NewDatabase(ctx, cfg.PostgresConnectionString); err != nil {
 		log.G(ctx).Errorf("failed to connect to database: %v\n", err)
@@ -341,3 +348,241 @@ func TestBuilder_WriteBody3(t *testing.T) {
 	}
 	fmt.Println(w.Header())
 }
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		r          string
+		start, end int64
+		ok, err    bool
+	}{
+		{"bytes=0-", 0, 99, true, false},
+		{"bytes=10-19", 10, 19, true, false},
+		{"bytes=10-1000", 10, 99, true, false},
+		{"bytes=-10", 90, 99, true, false},
+		{"bytes=0-1,5-10", 0, 0, false, false},
+		{"items=0-1", 0, 0, false, false},
+		{"bytes=100-", 0, 0, false, true},
+		{"bytes=20-10", 0, 0, false, true},
+		{"bytes=abc", 0, 0, false, true},
+	}
+	for _, c := range cases {
+		start, end, ok, err := parseRange(c.r, 100)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.r, err)
+			continue
+		}
+		if ok != c.ok || start != c.start || end != c.end {
+			t.Errorf("%s: expected (%d, %d, %v) but got (%d, %d, %v)", c.r, c.start, c.end, c.ok, start, end, ok)
+		}
+	}
+}
+
+func TestMatchIfRange(t *testing.T) {
+	d := "sha256:7f6c3c3ea5d2a8bc7b0a0d9e4b4a9f0d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a8f"
+	if !matchIfRange("", d) || !matchIfRange(d, d) || !matchIfRange(`"`+d+`"`, d) {
+		t.Error("If-Range should match")
+	}
+	if matchIfRange(`"sha256:0000"`, d) {
+		t.Error("If-Range should not match")
+	}
+}
+
+func TestBuilder_WriteBodyRange(t *testing.T) {
+	blob := []byte("0123456789ABCDEFGHIJ")
+	layer := &send.ImageLayer{
+		UncompressedSize: int64(len(blob)),
+		Blob: &common.LayerCache{
+			Buffer: io.NewSectionReader(bytes.NewReader(blob), 0, int64(len(blob))),
+		},
+	}
+	b := &Builder{}
+	b.Destination = &send.Image{Layers: []*send.ImageLayer{layer}}
+	b.Contents = []*send.Content{
+		{
+			Files:  []*send.RankedFile{{}},
+			Offset: 0, Size: 7,
+			Chunks: []*send.FileChunk{{Offset: 0, CompressedSize: 4}, {Offset: 10, CompressedSize: 3}},
+		},
+		{
+			Files:  []*send.RankedFile{{}},
+			Offset: 7, Size: 5,
+			Chunks: []*send.FileChunk{{Offset: 15, CompressedSize: 5}},
+		},
+	}
+	b.BodyLength = 12
+	b.headerSize = 3
+
+	cases := []struct {
+		partial    bool
+		start, end int64
+		expected   string
+	}{
+		{false, 0, 0, "0123ABCFGHIJ"},
+		{true, 5, 14, "23ABCFGHIJ"},
+		{true, 5, 8, "23AB"},
+		{true, 10, 11, "FG"},
+		{true, 1, 3, "0"},
+	}
+	for _, c := range cases {
+		b.partial, b.rangeStart, b.rangeEnd = c.partial, c.start, c.end
+		w := httptest.NewRecorder()
+		if err := b.WriteBody(w, &http.Request{}); err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := w.Body.String(); got != c.expected {
+			t.Errorf("range %d-%d: expected %q but got %q", c.start, c.end, c.expected, got)
+		}
+	}
+}
+
+func TestBuilder_WriteBodyPipelined(t *testing.T) {
+	blob := []byte("0123456789")
+	b := &Builder{}
+	b.Destination = &send.Image{Layers: []*send.ImageLayer{{UncompressedSize: int64(len(blob))}, {}}}
+	b.Contents = []*send.Content{
+		{
+			Files: []*send.RankedFile{{}}, Stack: 0,
+			Offset: 0, Size: 4,
+			Chunks: []*send.FileChunk{{Offset: 0, CompressedSize: 4}},
+		},
+		{
+			Files: []*send.RankedFile{{}}, Stack: 1,
+			Offset: 4, Size: 2,
+			Chunks: []*send.FileChunk{{Offset: 0, CompressedSize: 2}},
+		},
+	}
+	b.BodyLength = 6
+
+	// layer 0 arrives later, layer 1 fails
+	b.fetching = map[int64]*layerFetch{
+		0: {done: make(chan interface{})},
+		1: {done: make(chan interface{}), err: fmt.Errorf("registry unavailable")},
+	}
+	close(b.fetching[1].done)
+	go func() {
+		b.Destination.Layers[0].Blob = &common.LayerCache{
+			Buffer: io.NewSectionReader(bytes.NewReader(blob), 0, int64(len(blob))),
+		}
+		close(b.fetching[0].done)
+	}()
+
+	w := httptest.NewRecorder()
+	if err := b.WriteBody(w, &http.Request{}); err == nil {
+		t.Error("expected error for the failed layer")
+	}
+	if got := w.Body.String(); got != "0123" {
+		t.Errorf("expected %q but got %q", "0123", got)
+	}
+}
+
+func TestBuilder_MarkAvailableLayers(t *testing.T) {
+	b := &Builder{}
+	b.Source = &send.Image{Serial: 1, Layers: []*send.ImageLayer{{Serial: 10, Hash: "sha256:a"}}}
+	b.Destination = &send.Image{Serial: 2, Layers: []*send.ImageLayer{
+		{Serial: 10, Hash: "sha256:a"}, {Serial: 11, Hash: "sha256:b"}, {Serial: 12, Hash: "sha256:c"},
+	}}
+	if err := b.markAvailableLayers(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.unavailableLayers) != 2 {
+		t.Fatalf("expected 2 unavailable layers but got %d", len(b.unavailableLayers))
+	}
+
+	// layer b is available from another image on the client
+	if err := b.SetAvailable(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.Available = []*send.ImageLayer{{Serial: 11, Hash: "sha256:b"}}
+	if err := b.markAvailableLayers(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.unavailableLayers) != 1 || b.unavailableLayers[0].Hash != "sha256:c" {
+		t.Fatalf("expected only layer c to be unavailable but got %v", b.unavailableLayers)
+	}
+	if key := b.planKey(); key.available != "11" || key.source != 1 {
+		t.Errorf("unexpected plan key %v", key)
+	}
+}
+
+func TestBuilder_PlanKey(t *testing.T) {
+	server, d := newTestServer(t)
+	digest := func(s string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s)))
+	}
+
+	// the destination image has layers a, b and c, layer shared has the content of the file in layer c
+	contents := map[string]string{"a": "a", "b": "b", "c": "c", "shared": "c", "other1": "x", "other2": "y"}
+	layers := make(map[string]*send.ImageLayer)
+	insert := func(img string, names ...string) int64 {
+		serial, _, err := d.InsertImage("plan/"+img, digest(img), []byte(`{}`), []byte(`{}`), int64(len(names)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, n := range names {
+			fsId, _, err := d.InsertLayer(1, serial, int64(i), digest("layer-"+n), func() (map[string]*common.TraceableEntry, error) {
+				return map[string]*common.TraceableEntry{
+					n + "/file": {TOCEntry: &common.TOCEntry{Name: n + "/file", Type: "reg", Size: 1, Digest: digest(contents[n])}},
+				}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			layers[n] = &send.ImageLayer{Serial: fsId, Hash: digest("layer-" + n)}
+		}
+		return serial
+	}
+	destination := &send.Image{Serial: insert("app", "a", "b", "c")}
+	insert("other", "shared", "other1", "other2")
+	for _, n := range []string{"a", "b", "c"} {
+		l := *layers[n]
+		l.Available = n != "c"
+		destination.Layers = append(destination.Layers, &l)
+	}
+
+	plans := NewDeltaPlanCache(10)
+	server.plans = plans
+	newWorker := func(available ...string) *Builder {
+		b := &Builder{server: server}
+		b.Destination = destination
+		for _, n := range available {
+			b.Available = append(b.Available, layers[n])
+		}
+		if err := b.pruneAvailable(); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	key := func(names ...string) string {
+		serials := make([]string, 0, len(names))
+		for _, n := range names {
+			serials = append(serials, strconv.FormatInt(layers[n].Serial, 10))
+		}
+		return strings.Join(serials, ",")
+	}
+
+	// the contents of the first worker could be referenced, the other layers of the workers are unrelated
+	w1, w2, w3 := newWorker("a", "b", "shared", "other1"), newWorker("a", "b", "other2"), newWorker("b", "a", "other1")
+	if k := w1.planKey(); k.available != key("a", "b", "shared") {
+		t.Errorf("expected the shared layer in the plan key but got %v", k)
+	}
+	if k2, k3 := w2.planKey(), w3.planKey(); k2 != k3 || k2.available != key("a", "b") {
+		t.Fatalf("expected the same plan key but got %v and %v", k2, k3)
+	}
+
+	if _, hit, err := plans.Get(w2.planKey(), func() (*deltaPlan, error) {
+		return &deltaPlan{layers: w2.destinationLayers()}, nil
+	}); err != nil || hit {
+		t.Fatalf("expected a miss but got %v (%v)", hit, err)
+	}
+	if _, hit, err := w3.plan(); err != nil || !hit {
+		t.Errorf("expected a hit for the third worker but got %v (%v)", hit, err)
+	}
+}
+
+func TestSplitQueryValues(t *testing.T) {
+	got := splitQueryValues([]string{"sha256:a,sha256:b", "", " sha256:c ,"})
+	if fmt.Sprint(got) != "[sha256:a sha256:b sha256:c]" {
+		t.Errorf("unexpected values %v", got)
+	}
+}
@@ -21,7 +21,7 @@ type Configuration struct {
 	ListenAddress string `json:"address"`
 	LogLevel      string `json:"log_level"`
 
-	// database
+	// database, a PostgreSQL connection string or "bolt:///path/to/metadata.db" for the embedded database
 	PostgresConnectionString string `json:"postgres"`
 
 	// registry
@@ -34,6 +34,40 @@ type Configuration struct {
 
 	// layer cache timeout (second)
 	CacheTimeout int `json:"cache_timeout"`
+
+	// layer cache backend, "disk" spills the layers to CacheDirectory, "memory" keeps them in memory
+	CacheBackend   string `json:"cache_backend"`
+	CacheDirectory string `json:"cache_dir"`
+	// maximum size of the layer cache in bytes, 0 means unlimited
+	CacheSize int64 `json:"cache_size"`
+
+	// number of computed delta images (the order of the contents and the header) kept in memory,
+	// 0 disables the cache
+	DeltaCacheSize int `json:"delta_cache_size"`
+
+	// authentication and authorization, the API is open to everyone if it is not set
+	Auth *AuthConfiguration `json:"auth,omitempty"`
+	// MetricsPublic exposes /metrics without authentication, otherwise the scraper needs a rule
+	// that allows the "/metrics" endpoint once Auth is set
+	MetricsPublic bool `json:"metrics_public,omitempty"`
+
+	// TLS, the proxy serves HTTPS if the certificate and the key are set.
+	// If TLSClientCA is set, the clients must present a certificate signed by it (mutual TLS).
+	// The files are reloaded once they change.
+	TLSCertificate string `json:"tls_cert,omitempty"`
+	TLSKey         string `json:"tls_key,omitempty"`
+	TLSClientCA    string `json:"tls_client_ca,omitempty"`
+
+	// SignatureKeys are the PEM encoded public keys trusted to sign the images (cosign-style signatures).
+	// If it is set, the proxy refuses to index images without a valid signature.
+	SignatureKeys []string `json:"signature_keys,omitempty"`
+
+	// garbage collection of the metadata database (second), 0 disables the background sweeper.
+	// It keeps the GCKeepTags most recent tags of each repository (0 keeps all) and removes the untagged images
+	// older than GCUntaggedMaxAge seconds (0 keeps them), then the layers no image refers to.
+	GCInterval       int `json:"gc_interval,omitempty"`
+	GCKeepTags       int `json:"gc_keep_tags,omitempty"`
+	GCUntaggedMaxAge int `json:"gc_untagged_max_age,omitempty"`
 }
 
 func LoadConfig(cfgPath string) (c *Configuration, p string, n bool, error error) {
@@ -103,6 +137,10 @@ func NewConfig() *Configuration {
 		//EnableHarborScanner: false,
 		//HarborApiKey:        uuid.New().String(),
 
-		CacheTimeout: 3600,
+		CacheTimeout:   3600,
+		CacheBackend:   "disk",
+		CacheDirectory: "/var/lib/starlight-proxy/cache",
+		CacheSize:      10 * 1024 * 1024 * 1024,
+		DeltaCacheSize: 128,
 	}
 }
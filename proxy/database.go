@@ -12,11 +12,11 @@ import (
 	"fmt"
 	"math"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/containerd/containerd/log"
 	"github.com/google/go-containerregistry/pkg/name"
-	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/lib/pq"
 	"github.com/mc256/starlight/client/fs"
 	"github.com/mc256/starlight/util/common"
@@ -24,15 +24,87 @@ import (
 	"github.com/pkg/errors"
 )
 
-type Database struct {
+// Database stores the metadata of the images indexed by the proxy: manifests, configs, tags, layers and the
+// ToC entries of the files together with their ranks from the uploaded traces.
+// Looking up an image or a layer that does not exist returns sql.ErrNoRows regardless of the backend.
+type Database interface {
+	// InitDatabase applies the pending schema migrations
+	InitDatabase() error
+	Close()
+
+	// Migrations returns the schema migrations of the backend, Applied is nil for the pending ones
+	Migrations() ([]*Migration, error)
+	// Migrate applies the pending migrations in order and returns them. If dryRun is true, the pending
+	// migrations are returned without being applied.
+	Migrate(dryRun bool) ([]*Migration, error)
+
+	// InsertImage adds the image, config and manifest are the raw bytes from the registry.
+	// existing is true if the image has been cached and is ready.
+	InsertImage(image, hash string, config, manifest []byte, layerCount int64) (serial int64, existing bool, err error)
+	SetImageReady(ready bool, serial int64) error
+	SetImageTag(name, tag, platform string, serial int64) error
+	SetImageSignature(serial int64, signature []byte) error
+
+	// InsertLayer adds the stackIndex-th layer of the image. If the filesystem (digest, size) has not been
+	// indexed before, entries is called to load the ToC of the layer and the files are saved in the same
+	// transaction.
+	InsertLayer(size, imageSerial, stackIndex int64, layerDigest string,
+		entries func() (map[string]*common.TraceableEntry, error)) (fsId int64, existing bool, err error)
+
+	GetImage(image, identifier, platform string) (serial int64, err error)
+	GetImageByDigest(image, digest string) (serial int64, err error)
+	GetImageNames(digest string) ([]string, error)
+	GetManifestAndConfig(serial int64) (config, manifest []byte, digest string, err error)
+	GetImageSignature(serial int64) (signature []byte, err error)
+
+	GetLayers(imageSerial int64) ([]*send.ImageLayer, error)
+	GetLayersByDigests(digests []string) ([]*send.ImageLayer, error)
+	GetRoughDeduplicatedLayers(fromSerial, toSerial int64) ([]*send.ImageLayer, error)
+
+	GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, error)
+	UpdateFileRanks(collection *fs.TraceCollection) ([][][]int64, error)
+	GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, error)
+	GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile, error)
+
+	// CollectGarbage removes the tags and the images the retention policy does not keep, then the filesystems
+	// no layer refers to, in one transaction. In a dry run, nothing is removed and the report lists what
+	// would be removed.
+	CollectGarbage(policy RetentionPolicy, dryRun bool) (*GCReport, error)
+
+	// DeleteTags removes the tag of the repository for the platform, or for all the platforms if platform is empty
+	DeleteTags(name, tag, platform string) ([]*GCTag, error)
+	// DeleteImages removes the images of the repository that have the manifest digest, together with their
+	// tags and layers. The filesystems are left to the garbage collector.
+	DeleteImages(name, digest string) (*GCReport, error)
+
+	// ListRepositories returns the repositories ordered by name with the number of their tags and images
+	ListRepositories() ([]*RepositoryInfo, error)
+	// ListTags returns the tags of the repository ordered by tag and platform
+	ListTags(repository string) ([]*TagInfo, error)
+	// GetImageInfo returns the image with its layers, the number of files and how many of them have been ranked
+	GetImageInfo(serial int64) (*ImageInfo, error)
+}
+
+// NewDatabase opens the metadata database. A connection string starting with "bolt://" opens the embedded
+// database file that follows (e.g. "bolt:///var/lib/starlight-proxy/metadata.db"), otherwise it is a
+// PostgreSQL connection string.
+func NewDatabase(ctx context.Context, conStr string) (Database, error) {
+	if strings.HasPrefix(conStr, BoltConnectionPrefix) {
+		return NewBoltDatabase(strings.TrimPrefix(conStr, BoltConnectionPrefix))
+	}
+	return NewPostgresDatabase(ctx, conStr)
+}
+
+// PostgresDatabase is the PostgreSQL backend of the metadata database
+type PostgresDatabase struct {
 	db *sql.DB
 }
 
-func (d *Database) Close() {
+func (d *PostgresDatabase) Close() {
 	_ = d.db.Close()
 }
 
-func NewDatabase(ctx context.Context, conStr string) (*Database, error) {
+func NewPostgresDatabase(ctx context.Context, conStr string) (*PostgresDatabase, error) {
 	var (
 		d   *sql.DB
 		err error
@@ -42,7 +114,7 @@ func NewDatabase(ctx context.Context, conStr string) (*Database, error) {
 		i += 1
 		d, err = sql.Open("postgres", conStr)
 		if err == nil {
-			return &Database{db: d}, nil
+			return &PostgresDatabase{db: d}, nil
 		} else if err != nil {
 			if i > 10 {
 				return nil, err
@@ -55,8 +127,13 @@ func NewDatabase(ctx context.Context, conStr string) (*Database, error) {
 	}
 }
 
-func (d *Database) InitDatabase() error {
-	if _, err := d.db.Exec(`
+// postgresMigrations are the schema migrations of the PostgreSQL backend. The scripts of the released
+// migrations must not be changed, append a new one instead.
+var postgresMigrations = []struct {
+	Migration
+	script string
+}{
+	{Migration{Version: 1, Description: "create tables"}, `
 		create table if not exists image
 		(
 			id       serial,
@@ -144,13 +221,8 @@ func (d *Database) InitDatabase() error {
 		);
 
 		comment on table tag is 'Each row represents a tag where (name, tag, platform) is unique. Each row references to the image table.';
-
-	`); err != nil {
-		return err
-	}
-
-	// create index for the image table
-	if _, err := d.db.Exec(`
+	`},
+	{Migration{Version: 2, Description: "create indexes"}, `
 		CREATE INDEX IF NOT EXISTS _ix_fs
 			ON file USING btree
 			(fs ASC NULLS LAST)
@@ -186,13 +258,147 @@ func (d *Database) InitDatabase() error {
 			ON tag USING btree
 			(name COLLATE pg_catalog."default" ASC NULLS LAST, tag COLLATE pg_catalog."default" ASC NULLS LAST, platform COLLATE pg_catalog."default" ASC NULLS LAST)
 			WITH (deduplicate_items=True);
+	`},
+	{Migration{Version: 3, Description: "add image signature"}, `
+		alter table image add column if not exists signature json;
+
+		comment on column image.signature is 'the verified signature attestation passed to the clients';
+	`},
+	{Migration{Version: 4, Description: "add tag and image timestamps for garbage collection"}, `
+		alter table tag add column if not exists updated timestamp with time zone not null default now();
+		alter table image add column if not exists created timestamp with time zone not null default now();
+
+		comment on column tag.updated is 'the last time the tag was pointed to an image';
+		comment on column image.created is 'the time the image was inserted, ready is null until it is cached';
+	`},
+	{Migration{Version: 5, Description: "add layer filesystem foreign key"}, `
+		update image set ready = null
+		where id in (
+			select L.image from layer AS L
+			where not exists (select 1 from filesystem AS FIS where FIS.id = L.layer)
+		);
+		delete from layer AS L
+		where not exists (select 1 from filesystem AS FIS where FIS.id = L.layer);
+
+		alter table layer add constraint layer_filesystem_fk
+			foreign key (layer) references filesystem;
+
+		create index if not exists _ix_layer
+			on layer (layer);
+	`},
+}
+
+// InitDatabase brings the schema up to date
+func (d *PostgresDatabase) InitDatabase() error {
+	_, err := d.Migrate(false)
+	return err
+}
+
+// Migrations returns the schema migrations, Applied is nil if the migration has not been applied
+func (d *PostgresDatabase) Migrations() ([]*Migration, error) {
+	defined := make([]Migration, 0, len(postgresMigrations))
+	for _, m := range postgresMigrations {
+		defined = append(defined, m.Migration)
+	}
+
+	applied := make(map[int]time.Time)
+	rows, err := d.db.Query(`SELECT version, applied FROM schema_version`)
+	if err != nil {
+		// the database has not been migrated yet
+		var pe *pq.Error
+		if errors.As(err, &pe) && pe.Code == "42P01" {
+			return newMigrations(defined, applied)
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			v  int
+			ts time.Time
+		)
+		if err = rows.Scan(&v, &ts); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan schema version")
+		}
+		applied[v] = ts
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to load schema versions")
+	}
+	return newMigrations(defined, applied)
+}
+
+// Migrate applies the pending migrations in order, each of them in its own transaction
+func (d *PostgresDatabase) Migrate(dryRun bool) ([]*Migration, error) {
+	ms, err := d.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := pendingMigrations(ms)
+	if dryRun || len(pending) == 0 {
+		return pending, nil
+	}
+
+	if _, err = d.db.Exec(`
+		create table if not exists schema_version
+		(
+			version     integer not null,
+			description varchar,
+			applied     timestamp with time zone not null,
+			primary key (version)
+		);
+
+		comment on table schema_version is 'Each row represents a schema migration that has been applied.';
 	`); err != nil {
+		return nil, err
+	}
+	for _, m := range pending {
+		if err = d.migrate(m); err != nil {
+			return nil, errors.Wrapf(err, "failed to apply migration %d (%s)", m.Version, m.Description)
+		}
+	}
+	return pending, nil
+}
+
+func (d *PostgresDatabase) migrate(m *Migration) (err error) {
+	defer observeQuery("Migrate")()
+	txn, err := d.db.Begin()
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err != nil {
+			_ = txn.Rollback()
+		}
+	}()
+
+	// another proxy may be migrating the same database
+	if _, err = txn.Exec(`LOCK TABLE schema_version IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	var n int
+	if err = txn.QueryRow(`SELECT COUNT(*) FROM schema_version WHERE version=$1`, m.Version).Scan(&n); err != nil {
+		return err
+	}
+	ts := time.Now()
+	if n == 0 {
+		if _, err = txn.Exec(postgresMigrations[m.Version-1].script); err != nil {
+			return err
+		}
+		if _, err = txn.Exec(`INSERT INTO schema_version(version, description, applied) VALUES ($1, $2, $3)`,
+			m.Version, m.Description, ts); err != nil {
+			return err
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		return err
+	}
+	m.Applied = &ts
 	return nil
 }
 
-func (d *Database) SetImageReady(ready bool, serial int64) error {
+func (d *PostgresDatabase) SetImageReady(ready bool, serial int64) error {
+	defer observeQuery("SetImageReady")()
 	var id int64
 	if ready {
 		if err := d.db.QueryRow(
@@ -212,17 +418,18 @@ func (d *Database) SetImageReady(ready bool, serial int64) error {
 	return nil
 }
 
-func (d *Database) SetImageTag(name, tag, platform string, serial int64) error {
+func (d *PostgresDatabase) SetImageTag(name, tag, platform string, serial int64) error {
+	defer observeQuery("SetImageTag")()
 	txn, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 
 	if _, err = txn.Exec(`
-		INSERT INTO tag (name, tag, platform, "imageId") VALUES ($1,$2,$3,$4) 
+		INSERT INTO tag (name, tag, platform, "imageId", updated) VALUES ($1,$2,$3,$4,$5) 
 		ON CONFLICT ON CONSTRAINT "primary"
-			DO UPDATE SET  "imageId"=$4`,
-		name, tag, platform, serial,
+			DO UPDATE SET  "imageId"=$4, updated=$5`,
+		name, tag, platform, serial, time.Now().Format(time.RFC3339Nano),
 	); err != nil {
 		return err
 	}
@@ -235,12 +442,14 @@ func (d *Database) SetImageTag(name, tag, platform string, serial int64) error {
 	return nil
 }
 
-func (d *Database) InsertImage(image, hash string,
-	config *v1.ConfigFile, manifest *v1.Manifest,
+// InsertImage adds the image, config and manifest are the raw bytes from the registry
+func (d *PostgresDatabase) InsertImage(image, hash string,
+	config, manifest []byte,
 	layerCount int64) (
 	serial int64, existing bool,
 	err error,
 ) {
+	defer observeQuery("InsertImage")()
 	var (
 		ts *time.Time
 	)
@@ -257,21 +466,12 @@ func (d *Database) InsertImage(image, hash string,
 		return serial, true, nil
 	}
 
-	c, err := json.Marshal(config)
-	if err != nil {
-		return 0, false, err
-	}
-	m, err := json.Marshal(manifest)
-	if err != nil {
-		return 0, false, err
-	}
-
 	if err = d.db.QueryRow(`
 		INSERT INTO image(image, hash, config, manifest, ready, nlayer) 
 		VALUES ($1, $2, $3, $4, null, $5)
 		ON CONFLICT ON CONSTRAINT unique_image_hash DO NOTHING 
 		RETURNING id;`,
-		image, hash, c, m, layerCount,
+		image, hash, config, manifest, layerCount,
 	).Scan(&serial); err != nil {
 		return 0, false, err
 	}
@@ -279,10 +479,8 @@ func (d *Database) InsertImage(image, hash string,
 	return serial, false, nil
 }
 
-func (d *Database) InsertLayer(
-	txn *sql.Tx, size int64, imageSerial, stackIndex int64, layerDigest string) (
-	fsId int64, existing bool, err error) {
-
+func (d *PostgresDatabase) InsertLayer(size, imageSerial, stackIndex int64, layerDigest string,
+	entries func() (map[string]*common.TraceableEntry, error)) (fsId int64, existing bool, err error) {
 	if err = d.db.QueryRow(`
 			SELECT id FROM filesystem
 			WHERE filesystem.digest=$1 AND filesystem.size=$2`,
@@ -293,6 +491,42 @@ func (d *Database) InsertLayer(
 		existing = true
 	}
 
+	// load the ToC before starting the transaction
+	var ent map[string]*common.TraceableEntry
+	loaded := !existing
+	if loaded {
+		if ent, err = entries(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	defer observeQuery("InsertLayer")()
+	txn, err := d.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = txn.Rollback()
+		}
+	}()
+
+	// the filesystem may have been added by another image or removed by the GC in the meantime,
+	// the lock keeps the GC from removing it until the layer refers to it
+	fsId, existing = 0, false
+	if err = txn.QueryRow(`
+			SELECT id FROM filesystem
+			WHERE filesystem.digest=$1 AND filesystem.size=$2
+			FOR KEY SHARE`,
+		layerDigest, size).Scan(&fsId); err != nil && err != sql.ErrNoRows {
+		return 0, false, err
+	}
+	if existing = fsId != 0; !existing && !loaded {
+		// the ToC was not loaded because the filesystem existed, load it and insert the layer again
+		_ = txn.Rollback()
+		return d.InsertLayer(size, imageSerial, stackIndex, layerDigest, entries)
+	}
+
 	if !existing {
 		if err = txn.QueryRow(`
 				INSERT INTO filesystem(digest, size, ready) 
@@ -306,7 +540,7 @@ func (d *Database) InsertLayer(
 
 	// Update layer reference
 	var id int64
-	if err := txn.QueryRow(
+	if err = txn.QueryRow(
 		`
 		INSERT INTO layer(size, image, "stackIndex", layer) 
 		VALUES ($1, $2, $3, $4)
@@ -319,10 +553,19 @@ func (d *Database) InsertLayer(
 		return 0, false, err
 	}
 
-	return fsId, false, nil
+	if !existing {
+		if err = d.insertFiles(txn, fsId, ent); err != nil {
+			return 0, false, err
+		}
+	}
+
+	if err = txn.Commit(); err != nil {
+		return 0, false, err
+	}
+	return fsId, existing, nil
 }
 
-func (d *Database) InsertFiles(txn *sql.Tx, fsId int64, entries map[string]*common.TraceableEntry) (err error) {
+func (d *PostgresDatabase) insertFiles(txn *sql.Tx, fsId int64, entries map[string]*common.TraceableEntry) (err error) {
 	if _, err = txn.Exec(`DELETE FROM file WHERE fs=$1`, fsId); err != nil {
 		return err
 	}
@@ -358,7 +601,8 @@ func (d *Database) InsertFiles(txn *sql.Tx, fsId int64, entries map[string]*comm
 	return nil
 }
 
-func (d *Database) GetImage(image, identifier, platform string) (serial int64, err error) {
+func (d *PostgresDatabase) GetImage(image, identifier, platform string) (serial int64, err error) {
+	defer observeQuery("GetImage")()
 	if err = d.db.QueryRow(`
 		SELECT "imageId" FROM tag
 		WHERE name=$1 AND tag=$2 AND platform=$3 LIMIT 1`,
@@ -377,7 +621,8 @@ func (d *Database) GetImage(image, identifier, platform string) (serial int64, e
 	return serial, nil
 }
 
-func (d *Database) GetImageByDigest(image, digest string) (serial int64, err error) {
+func (d *PostgresDatabase) GetImageByDigest(image, digest string) (serial int64, err error) {
+	defer observeQuery("GetImageByDigest")()
 	if err = d.db.QueryRow(`
 		SELECT id FROM image
 		WHERE ready IS NOT NULL AND image=$1 AND hash=$2 LIMIT 1`,
@@ -387,7 +632,28 @@ func (d *Database) GetImageByDigest(image, digest string) (serial int64, err err
 	return serial, nil
 }
 
-func (d *Database) GetManifestAndConfig(serial int64) (config, manifest []byte, digest string, err error) {
+// GetImageNames returns the names of the images that have the manifest digest
+func (d *PostgresDatabase) GetImageNames(digest string) ([]string, error) {
+	defer observeQuery("GetImageNames")()
+	rows, err := d.db.Query(`SELECT DISTINCT image FROM image WHERE hash=$1`, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make([]string, 0)
+	for rows.Next() {
+		var n string
+		if err = rows.Scan(&n); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan image name")
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func (d *PostgresDatabase) GetManifestAndConfig(serial int64) (config, manifest []byte, digest string, err error) {
+	defer observeQuery("GetManifestAndConfig")()
 	if err = d.db.QueryRow(`
 		SELECT config, manifest, hash FROM image
 		WHERE id=$1 LIMIT 1`,
@@ -397,7 +663,25 @@ func (d *Database) GetManifestAndConfig(serial int64) (config, manifest []byte,
 	return config, manifest, digest, nil
 }
 
-func (d *Database) GetLayers(imageSerial int64) ([]*send.ImageLayer, error) {
+// SetImageSignature saves the verified signature attestation of the image
+func (d *PostgresDatabase) SetImageSignature(serial int64, signature []byte) error {
+	defer observeQuery("SetImageSignature")()
+	_, err := d.db.Exec(`UPDATE image SET signature=$1 WHERE id=$2`, signature, serial)
+	return err
+}
+
+// GetImageSignature returns the signature attestation of the image, it is nil if the image was
+// indexed without verifying the signature
+func (d *PostgresDatabase) GetImageSignature(serial int64) (signature []byte, err error) {
+	defer observeQuery("GetImageSignature")()
+	if err = d.db.QueryRow(`SELECT signature FROM image WHERE id=$1`, serial).Scan(&signature); err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+func (d *PostgresDatabase) GetLayers(imageSerial int64) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetLayers")()
 	rows, err := d.db.Query(`
 		SELECT FIS."id", "stackIndex", "digest", FIS."size"
 		FROM layer AS L
@@ -423,10 +707,38 @@ func (d *Database) GetLayers(imageSerial int64) ([]*send.ImageLayer, error) {
 	return r, nil
 }
 
+// GetLayersByDigests returns the layers (filesystems) that have the digests, the digests unknown to the proxy
+// are ignored
+func (d *PostgresDatabase) GetLayersByDigests(digests []string) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetLayersByDigests")()
+	rows, err := d.db.Query(`
+		SELECT id, digest, size FROM filesystem
+		WHERE ready IS NOT NULL AND digest = ANY($1)
+		ORDER BY id`, pq.Array(digests))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*send.ImageLayer, 0, len(digests))
+	for rows.Next() {
+		layer := &send.ImageLayer{}
+		if err := rows.Scan(&layer.Serial, &layer.Hash, &layer.UncompressedSize); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan layer")
+		}
+		r = append(r, layer)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to load layers")
+	}
+	return r, nil
+}
+
 // GetRoughDeduplicatedLayers returns the likely unique files
 // because it would be hard for the database to apply overlayfs correctly, so this deduplication
 // does not consider whiteout files.
-func (d *Database) GetRoughDeduplicatedLayers(fromSerial, toSerial int64) ([]*send.ImageLayer, error) {
+func (d *PostgresDatabase) GetRoughDeduplicatedLayers(fromSerial, toSerial int64) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetRoughDeduplicatedLayers")()
 	rows, err := d.db.Query(`
 		WITH
 		ALPHA AS (
@@ -483,7 +795,8 @@ func (d *Database) GetRoughDeduplicatedLayers(fromSerial, toSerial int64) ([]*se
 	return r, nil
 }
 
-func (d *Database) GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, error) {
+func (d *PostgresDatabase) GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, error) {
+	defer observeQuery("GetUniqueFiles")()
 	lids := make([]int64, 0, len(layers))
 	for _, v := range layers {
 		lids = append(lids, v.Serial)
@@ -492,7 +805,8 @@ func (d *Database) GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, erro
 		SELECT 
 			FI.fs, FI.metadata
 		FROM file AS FI
-		WHERE FI.fs = ANY($1) AND FI.hash != ''`, pq.Array(lids))
+		WHERE FI.fs = ANY($1) AND FI.hash != ''
+		ORDER BY FI.id ASC`, pq.Array(lids))
 	if err != nil {
 		return nil, err
 	}
@@ -519,7 +833,8 @@ func (d *Database) GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, erro
 	return fl, nil
 }
 
-func (d *Database) UpdateFileRanks(collection *fs.TraceCollection) (fs [][][]int64, err error) {
+func (d *PostgresDatabase) UpdateFileRanks(collection *fs.TraceCollection) (fs [][][]int64, err error) {
+	defer observeQuery("UpdateFileRanks")()
 	res := make([][][]int64, 0, len(collection.Groups))
 	for _, group := range collection.Groups {
 		layersImageMap := make([][]int64, len(group.Images))
@@ -572,7 +887,8 @@ func (d *Database) UpdateFileRanks(collection *fs.TraceCollection) (fs [][][]int
 	return res, nil
 }
 
-func (d *Database) GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, error) {
+func (d *PostgresDatabase) GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, error) {
+	defer observeQuery("GetFilesWithRanks")()
 	rows, err := d.db.Query(`
 		SELECT 
 			L."stackIndex", 
@@ -583,7 +899,7 @@ func (d *Database) GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, err
 		LEFT JOIN filesystem AS FIS ON FIS.id = L.layer
 		RIGHT JOIN file AS FI ON FI.fs = FIS.id
 		WHERE image=$1
-		ORDER BY L."stackIndex" ASC`, imageSerial)
+		ORDER BY L."stackIndex" ASC, FI.id ASC`, imageSerial)
 	if err != nil {
 		return nil, err
 	}
@@ -623,7 +939,8 @@ func (d *Database) GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, err
 	return fl, nil
 }
 
-func (d *Database) GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile, error) {
+func (d *PostgresDatabase) GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile, error) {
+	defer observeQuery("GetFilesWithoutRanks")()
 	rows, err := d.db.Query(`
 		SELECT 
 			L."stackIndex", 
@@ -633,7 +950,7 @@ func (d *Database) GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile,
 		LEFT JOIN filesystem AS FIS ON FIS.id = L.layer
 		RIGHT JOIN file AS FI ON FI.fs = FIS.id
 		WHERE image=$1
-		ORDER BY L."stackIndex" ASC`, imageSerial)
+		ORDER BY L."stackIndex" ASC, FI.id ASC`, imageSerial)
 	if err != nil {
 		return nil, err
 	}
@@ -664,6 +981,111 @@ func (d *Database) GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile,
 	return fl, nil
 }
 
+func (d *PostgresDatabase) CollectGarbage(policy RetentionPolicy, dryRun bool) (report *GCReport, err error) {
+	defer observeQuery("CollectGarbage")()
+	txn, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// a dry run removes everything in the transaction and rolls it back
+		if err != nil || dryRun {
+			_ = txn.Rollback()
+		}
+	}()
+
+	report = newGCReport(dryRun)
+
+	// tags beyond the most recent KeepTags tags of each repository
+	if policy.KeepTags > 0 {
+		rows, err := txn.Query(`
+			DELETE FROM tag WHERE (name, tag) IN (
+				SELECT name, tag FROM (
+					SELECT name, tag, ROW_NUMBER() OVER (
+						PARTITION BY name ORDER BY MAX(updated) DESC, tag DESC
+					) AS "n"
+					FROM tag
+					GROUP BY name, tag
+				) AS RANKED
+				WHERE "n" > $1
+			)
+			RETURNING name, tag, platform, "imageId"`, policy.KeepTags)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			t := &GCTag{}
+			if err = rows.Scan(&t.Name, &t.Tag, &t.Platform, &t.Image); err != nil {
+				_ = rows.Close()
+				return nil, errors.Wrapf(err, "failed to scan tag")
+			}
+			report.Tags = append(report.Tags, t)
+		}
+		if err = rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	// untagged images, the layers are removed by the foreign key
+	if policy.UntaggedMaxAge > 0 {
+		rows, err := txn.Query(`
+			DELETE FROM image AS I
+			WHERE COALESCE(I.ready, I.created) < $1
+				AND NOT EXISTS (SELECT 1 FROM tag AS T WHERE T."imageId" = I.id)
+			RETURNING id, image, hash`,
+			time.Now().Add(-policy.UntaggedMaxAge).Format(time.RFC3339Nano))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			img := &GCImage{}
+			if err = rows.Scan(&img.Serial, &img.Image, &img.Hash); err != nil {
+				_ = rows.Close()
+				return nil, errors.Wrapf(err, "failed to scan image")
+			}
+			report.Images = append(report.Images, img)
+		}
+		if err = rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	// filesystems without layers, the files are removed by the foreign key
+	rows, err := txn.Query(`
+		WITH REMOVED AS (
+			DELETE FROM filesystem AS FIS
+			WHERE NOT EXISTS (SELECT 1 FROM layer AS L WHERE L.layer = FIS.id)
+			RETURNING id, digest, size
+		)
+		SELECT R.id, R.digest, R.size, (SELECT COUNT(*) FROM file AS FI WHERE FI.fs = R.id)
+		FROM REMOVED AS R`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			f     = &GCFilesystem{}
+			files int64
+		)
+		if err = rows.Scan(&f.Serial, &f.Digest, &f.Size, &files); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrapf(err, "failed to scan filesystem")
+		}
+		report.Filesystems = append(report.Filesystems, f)
+		report.Files += files
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		if err = txn.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
 func ParseImageReference(ref name.Reference, defaultRegistry string, registryAlias []string) (imageName, identifier string) {
 	imageName = ref.Context().RepositoryStr()
 	appendRegistry := false
@@ -682,3 +1104,190 @@ func ParseImageReference(ref name.Reference, defaultRegistry string, registryAli
 	identifier = ref.Identifier()
 	return
 }
+
+func (d *PostgresDatabase) ListRepositories() ([]*RepositoryInfo, error) {
+	defer observeQuery("ListRepositories")()
+	rows, err := d.db.Query(`
+		SELECT I.image, COUNT(DISTINCT T.tag), COUNT(DISTINCT I.id)
+		FROM image AS I
+		LEFT JOIN tag AS T ON T.name = I.image
+		GROUP BY I.image
+		ORDER BY I.image`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*RepositoryInfo, 0)
+	for rows.Next() {
+		repo := &RepositoryInfo{}
+		if err = rows.Scan(&repo.Name, &repo.Tags, &repo.Images); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan repository")
+		}
+		r = append(r, repo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to load repositories")
+	}
+	return r, nil
+}
+
+func (d *PostgresDatabase) ListTags(repository string) ([]*TagInfo, error) {
+	defer observeQuery("ListTags")()
+	rows, err := d.db.Query(`
+		SELECT T.name, T.tag, T.platform, T."imageId", COALESCE(I.hash, ''), I.ready, T.updated
+		FROM tag AS T
+		LEFT JOIN image AS I ON I.id = T."imageId"
+		WHERE T.name = $1
+		ORDER BY T.tag, T.platform`, repository)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*TagInfo, 0)
+	for rows.Next() {
+		var (
+			t     = &TagInfo{}
+			ready sql.NullTime
+		)
+		if err = rows.Scan(&t.Name, &t.Tag, &t.Platform, &t.Image, &t.Digest, &ready, &t.Updated); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan tag")
+		}
+		if ready.Valid {
+			t.Ready = &ready.Time
+		}
+		r = append(r, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to load tags")
+	}
+	return r, nil
+}
+
+func (d *PostgresDatabase) GetImageInfo(serial int64) (*ImageInfo, error) {
+	defer observeQuery("GetImageInfo")()
+	var (
+		info           = &ImageInfo{Serial: serial, Layers: make([]*LayerInfo, 0)}
+		created, ready sql.NullTime
+	)
+	if err := d.db.QueryRow(`
+		SELECT image, hash, created, ready FROM image WHERE id=$1`,
+		serial).Scan(&info.Name, &info.Digest, &created, &ready); err != nil {
+		return nil, err
+	}
+	if created.Valid {
+		info.Created = &created.Time
+	}
+	if ready.Valid {
+		info.Ready = &ready.Time
+	}
+
+	rows, err := d.db.Query(`
+		SELECT L."stackIndex", FIS.id, FIS.digest, FIS.size,
+			(SELECT COUNT(*) FROM file AS FI WHERE FI.fs = FIS.id),
+			(SELECT COUNT(*) FROM file AS FI WHERE FI.fs = FIS.id AND cardinality(FI."order") > 0)
+		FROM layer AS L
+		LEFT JOIN filesystem AS FIS ON FIS.id = L.layer
+		WHERE L.image=$1
+		ORDER BY L."stackIndex"`, serial)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := &LayerInfo{}
+		if err = rows.Scan(&l.StackIndex, &l.Serial, &l.Digest, &l.Size, &l.Files, &l.RankedFiles); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan layer")
+		}
+		info.Layers = append(info.Layers, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to load layers")
+	}
+	info.summarize()
+	return info, nil
+}
+
+func (d *PostgresDatabase) DeleteTags(name, tag, platform string) ([]*GCTag, error) {
+	defer observeQuery("DeleteTags")()
+	rows, err := d.db.Query(`
+		DELETE FROM tag
+		WHERE name=$1 AND tag=$2 AND ($3='' OR platform=$3)
+		RETURNING name, tag, platform, "imageId"`, name, tag, platform)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	r := make([]*GCTag, 0)
+	for rows.Next() {
+		t := &GCTag{}
+		if err = rows.Scan(&t.Name, &t.Tag, &t.Platform, &t.Image); err != nil {
+			return nil, errors.Wrapf(err, "failed to scan tag")
+		}
+		r = append(r, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to delete tags")
+	}
+	return r, nil
+}
+
+func (d *PostgresDatabase) DeleteImages(name, digest string) (report *GCReport, err error) {
+	defer observeQuery("DeleteImages")()
+	txn, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = txn.Rollback()
+		}
+	}()
+
+	report = newGCReport(false)
+	rows, err := txn.Query(`
+		DELETE FROM tag
+		WHERE "imageId" IN (SELECT id FROM image WHERE image=$1 AND hash=$2)
+		RETURNING name, tag, platform, "imageId"`, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		t := &GCTag{}
+		if err = rows.Scan(&t.Name, &t.Tag, &t.Platform, &t.Image); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrapf(err, "failed to scan tag")
+		}
+		report.Tags = append(report.Tags, t)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	// the layers are removed by the foreign key
+	rows, err = txn.Query(`
+		DELETE FROM image WHERE image=$1 AND hash=$2
+		RETURNING id, image, hash`, name, digest)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		img := &GCImage{}
+		if err = rows.Scan(&img.Serial, &img.Image, &img.Hash); err != nil {
+			_ = rows.Close()
+			return nil, errors.Wrapf(err, "failed to scan image")
+		}
+		report.Images = append(report.Images, img)
+	}
+	if err = rows.Close(); err != nil {
+		return nil, err
+	}
+
+	if err = txn.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,1328 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mc256/starlight/client/fs"
+	"github.com/mc256/starlight/util/common"
+	"github.com/mc256/starlight/util/send"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltConnectionPrefix selects the embedded database, the rest of the connection string is the path of the file
+const BoltConnectionPrefix = "bolt://"
+
+var (
+	bucketImage      = []byte("image")             // id -> boltImage
+	bucketImageHash  = []byte("image_hash")        // image, hash -> id
+	bucketTag        = []byte("tag")               // name, tag, platform -> boltTag
+	bucketLayer      = []byte("layer")             // image id, stackIndex -> boltLayer
+	bucketFilesystem = []byte("filesystem")        // id -> boltFilesystem
+	bucketFsDigest   = []byte("filesystem_digest") // digest, size -> id
+	bucketFile       = []byte("file")              // fs id -> (file id -> boltFile)
+	bucketFileName   = []byte("file_name")         // fs id -> (file name -> file id)
+
+	bucketSchemaVersion = []byte("schema_version") // version -> Migration
+)
+
+type boltImage struct {
+	Image     string     `json:"image"`
+	Hash      string     `json:"hash"`
+	Config    []byte     `json:"config"`
+	Manifest  []byte     `json:"manifest"`
+	Created   *time.Time `json:"created,omitempty"`
+	Ready     *time.Time `json:"ready,omitempty"`
+	NLayer    int64      `json:"nlayer"`
+	Signature []byte     `json:"signature,omitempty"`
+}
+
+type boltTag struct {
+	Image   int64     `json:"image"`
+	Updated time.Time `json:"updated"`
+}
+
+type boltLayer struct {
+	Size       int64 `json:"size"`
+	StackIndex int64 `json:"stackIndex"`
+	Layer      int64 `json:"layer"`
+}
+
+type boltFilesystem struct {
+	Digest string     `json:"digest"`
+	Size   int64      `json:"size"`
+	Ready  *time.Time `json:"ready,omitempty"`
+}
+
+type boltFile struct {
+	Hash     string  `json:"hash"`
+	Size     int64   `json:"size"`
+	File     string  `json:"file"`
+	Offset   int64   `json:"offset"`
+	Order    []int64 `json:"order,omitempty"`
+	Metadata []byte  `json:"metadata"`
+}
+
+// BoltDatabase is the embedded backend of the metadata database. Everything is kept in a single bbolt file,
+// so that small deployments and tests do not need a PostgreSQL server. Queries are evaluated in memory.
+type BoltDatabase struct {
+	db *bolt.DB
+}
+
+func NewBoltDatabase(p string) (*BoltDatabase, error) {
+	if p == "" {
+		return nil, fmt.Errorf("path of the embedded database is empty")
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0750); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory for %s", p)
+	}
+	d, err := bolt.Open(p, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open embedded database %s", p)
+	}
+	return &BoltDatabase{db: d}, nil
+}
+
+func (d *BoltDatabase) Close() {
+	_ = d.db.Close()
+}
+
+// boltMigrations are the schema migrations of the embedded backend, append a new one to change the layout
+var boltMigrations = []struct {
+	Migration
+	apply func(tx *bolt.Tx) error
+}{
+	{Migration{Version: 1, Description: "create buckets"}, func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{
+			bucketImage, bucketImageHash, bucketTag, bucketLayer,
+			bucketFilesystem, bucketFsDigest, bucketFile, bucketFileName,
+		} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{Migration{Version: 2, Description: "add tag and image timestamps for garbage collection"}, func(tx *bolt.Tx) error {
+		now := time.Now()
+		tags := tx.Bucket(bucketTag)
+		converted := make(map[string]*boltTag)
+		if err := tags.ForEach(func(k, v []byte) error {
+			if len(v) == 8 {
+				converted[string(k)] = &boltTag{Image: btoi(v), Updated: now}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, t := range converted {
+			if err := boltPut(tags, []byte(k), t); err != nil {
+				return err
+			}
+		}
+
+		images := tx.Bucket(bucketImage)
+		created := make(map[string]*boltImage)
+		if err := images.ForEach(func(k, v []byte) error {
+			img := &boltImage{}
+			if err := json.Unmarshal(v, img); err != nil {
+				return err
+			}
+			if img.Created == nil {
+				img.Created = &now
+				created[string(k)] = img
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		for k, img := range created {
+			if err := boltPut(images, []byte(k), img); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// InitDatabase brings the schema up to date
+func (d *BoltDatabase) InitDatabase() error {
+	_, err := d.Migrate(false)
+	return err
+}
+
+func boltMigrationStatus(tx *bolt.Tx) ([]*Migration, error) {
+	defined := make([]Migration, 0, len(boltMigrations))
+	for _, m := range boltMigrations {
+		defined = append(defined, m.Migration)
+	}
+
+	applied := make(map[int]time.Time)
+	if b := tx.Bucket(bucketSchemaVersion); b != nil {
+		if err := b.ForEach(func(k, v []byte) error {
+			var m Migration
+			if err := json.Unmarshal(v, &m); err != nil {
+				return errors.Wrapf(err, "failed to parse schema version")
+			}
+			if m.Applied != nil {
+				applied[int(btoi(k))] = *m.Applied
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return newMigrations(defined, applied)
+}
+
+// Migrations returns the schema migrations, Applied is nil if the migration has not been applied
+func (d *BoltDatabase) Migrations() (ms []*Migration, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		ms, err = boltMigrationStatus(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// Migrate applies the pending migrations in order, all of them in one transaction
+func (d *BoltDatabase) Migrate(dryRun bool) (pending []*Migration, err error) {
+	if dryRun {
+		ms, err := d.Migrations()
+		if err != nil {
+			return nil, err
+		}
+		return pendingMigrations(ms), nil
+	}
+
+	defer observeQuery("Migrate")()
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		ms, err := boltMigrationStatus(tx)
+		if err != nil {
+			return err
+		}
+		pending = pendingMigrations(ms)
+		if len(pending) == 0 {
+			return nil
+		}
+		versions, err := tx.CreateBucketIfNotExists(bucketSchemaVersion)
+		if err != nil {
+			return err
+		}
+		for _, m := range pending {
+			if err = boltMigrations[m.Version-1].apply(tx); err != nil {
+				return errors.Wrapf(err, "failed to apply migration %d (%s)", m.Version, m.Description)
+			}
+			ts := time.Now()
+			m.Applied = &ts
+			if err = boltPut(versions, itob(int64(m.Version)), m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func itob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoi(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// boltKey joins the columns of a unique constraint
+func boltKey(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func boltGet(b *bolt.Bucket, key []byte, v interface{}) error {
+	buf := b.Get(key)
+	if buf == nil {
+		return sql.ErrNoRows
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func boltPut(b *bolt.Bucket, key []byte, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, buf)
+}
+
+func (d *BoltDatabase) updateImage(serial int64, update func(img *boltImage)) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketImage)
+		var img boltImage
+		if err := boltGet(b, itob(serial), &img); err != nil {
+			return err
+		}
+		update(&img)
+		return boltPut(b, itob(serial), &img)
+	})
+}
+
+func (d *BoltDatabase) getImage(serial int64) (img *boltImage, err error) {
+	err = d.db.View(func(tx *bolt.Tx) error {
+		img = &boltImage{}
+		return boltGet(tx.Bucket(bucketImage), itob(serial), img)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (d *BoltDatabase) SetImageReady(ready bool, serial int64) error {
+	defer observeQuery("SetImageReady")()
+	var ts *time.Time
+	if ready {
+		now := time.Now()
+		ts = &now
+	}
+	return d.updateImage(serial, func(img *boltImage) {
+		img.Ready = ts
+	})
+}
+
+func (d *BoltDatabase) SetImageTag(name, tag, platform string, serial int64) error {
+	defer observeQuery("SetImageTag")()
+	return d.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx.Bucket(bucketTag), boltKey(name, tag, platform), &boltTag{
+			Image:   serial,
+			Updated: time.Now(),
+		})
+	})
+}
+
+// InsertImage adds the image, config and manifest are the raw bytes from the registry
+func (d *BoltDatabase) InsertImage(image, hash string,
+	config, manifest []byte,
+	layerCount int64) (
+	serial int64, existing bool,
+	err error,
+) {
+	defer observeQuery("InsertImage")()
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		images, index := tx.Bucket(bucketImage), tx.Bucket(bucketImageHash)
+		if id := index.Get(boltKey(image, hash)); id != nil {
+			serial = btoi(id)
+			var img boltImage
+			if err := boltGet(images, id, &img); err != nil {
+				return err
+			}
+			if img.Ready == nil {
+				return fmt.Errorf("caching is in progress or unfinished, plrease remove: image[%s] digest[%s] serial[%d]", image, hash, serial)
+			}
+			existing = true
+			return nil
+		}
+
+		id, err := images.NextSequence()
+		if err != nil {
+			return err
+		}
+		serial = int64(id)
+		now := time.Now()
+		if err = boltPut(images, itob(serial), &boltImage{
+			Created:  &now,
+			Image:    image,
+			Hash:     hash,
+			Config:   config,
+			Manifest: manifest,
+			NLayer:   layerCount,
+		}); err != nil {
+			return err
+		}
+		return index.Put(boltKey(image, hash), itob(serial))
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return serial, existing, nil
+}
+
+func (d *BoltDatabase) InsertLayer(size, imageSerial, stackIndex int64, layerDigest string,
+	entries func() (map[string]*common.TraceableEntry, error)) (fsId int64, existing bool, err error) {
+	fsKey := boltKey(layerDigest, fmt.Sprint(size))
+	if err = d.db.View(func(tx *bolt.Tx) error {
+		if id := tx.Bucket(bucketFsDigest).Get(fsKey); id != nil {
+			fsId, existing = btoi(id), true
+		}
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+
+	// load the ToC outside the transaction, bbolt only allows one writer at a time
+	var ent map[string]*common.TraceableEntry
+	loaded := !existing
+	if loaded {
+		if ent, err = entries(); err != nil {
+			return 0, false, err
+		}
+	}
+
+	defer observeQuery("InsertLayer")()
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		// the filesystem may have been added by another image or removed by the GC in the meantime
+		existing = false
+		if id := tx.Bucket(bucketFsDigest).Get(fsKey); id != nil {
+			fsId, existing = btoi(id), true
+		}
+		if !existing && !loaded {
+			return errFilesystemRemoved
+		}
+
+		if !existing {
+			filesystems := tx.Bucket(bucketFilesystem)
+			id, err := filesystems.NextSequence()
+			if err != nil {
+				return err
+			}
+			fsId = int64(id)
+			now := time.Now()
+			if err = boltPut(filesystems, itob(fsId), &boltFilesystem{
+				Digest: layerDigest,
+				Size:   size,
+				Ready:  &now,
+			}); err != nil {
+				return err
+			}
+			if err = tx.Bucket(bucketFsDigest).Put(fsKey, itob(fsId)); err != nil {
+				return err
+			}
+			if err = d.insertFiles(tx, fsId, ent); err != nil {
+				return err
+			}
+		}
+
+		// Update layer reference
+		return boltPut(tx.Bucket(bucketLayer), append(itob(imageSerial), itob(stackIndex)...), &boltLayer{
+			Size:       size,
+			StackIndex: stackIndex,
+			Layer:      fsId,
+		})
+	})
+	if err == errFilesystemRemoved {
+		// the ToC was not loaded because the filesystem existed, load it and insert the layer again
+		return d.InsertLayer(size, imageSerial, stackIndex, layerDigest, entries)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return fsId, existing, nil
+}
+
+func (d *BoltDatabase) insertFiles(tx *bolt.Tx, fsId int64, entries map[string]*common.TraceableEntry) error {
+	fileBucket, nameBucket := tx.Bucket(bucketFile), tx.Bucket(bucketFileName)
+	for _, b := range []*bolt.Bucket{fileBucket, nameBucket} {
+		if err := b.DeleteBucket(itob(fsId)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+	}
+	files, err := fileBucket.CreateBucket(itob(fsId))
+	if err != nil {
+		return err
+	}
+	names, err := nameBucket.CreateBucket(itob(fsId))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := entries[k]
+		bMetadata, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		id, err := fileBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err = boltPut(files, itob(int64(id)), &boltFile{
+			Hash:     v.Digest,
+			Size:     v.Size,
+			File:     k,
+			Offset:   v.Offset,
+			Metadata: bMetadata,
+		}); err != nil {
+			return err
+		}
+		if err = names.Put([]byte(k), itob(int64(id))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *BoltDatabase) GetImage(image, identifier, platform string) (serial int64, err error) {
+	defer observeQuery("GetImage")()
+	err = d.db.View(func(tx *bolt.Tx) error {
+		var t boltTag
+		if err := boltGet(tx.Bucket(bucketTag), boltKey(image, identifier, platform), &t); err == nil {
+			serial = t.Image
+			return nil
+		} else if err != sql.ErrNoRows {
+			return err
+		}
+		serial, err = readyImage(tx, image, identifier)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return serial, nil
+}
+
+// readyImage returns the serial of the image that has the manifest digest and has been cached
+func readyImage(tx *bolt.Tx, image, digest string) (int64, error) {
+	id := tx.Bucket(bucketImageHash).Get(boltKey(image, digest))
+	if id == nil {
+		return 0, sql.ErrNoRows
+	}
+	var img boltImage
+	if err := boltGet(tx.Bucket(bucketImage), id, &img); err != nil {
+		return 0, err
+	}
+	if img.Ready == nil {
+		return 0, sql.ErrNoRows
+	}
+	return btoi(id), nil
+}
+
+func (d *BoltDatabase) GetImageByDigest(image, digest string) (serial int64, err error) {
+	defer observeQuery("GetImageByDigest")()
+	err = d.db.View(func(tx *bolt.Tx) error {
+		serial, err = readyImage(tx, image, digest)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return serial, nil
+}
+
+// GetImageNames returns the names of the images that have the manifest digest
+func (d *BoltDatabase) GetImageNames(digest string) ([]string, error) {
+	defer observeQuery("GetImageNames")()
+	found := make(map[string]bool)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketImage).ForEach(func(k, v []byte) error {
+			var img boltImage
+			if err := json.Unmarshal(v, &img); err != nil {
+				return err
+			}
+			if img.Hash == digest {
+				found[img.Image] = true
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(found))
+	for n := range found {
+		res = append(res, n)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (d *BoltDatabase) GetManifestAndConfig(serial int64) (config, manifest []byte, digest string, err error) {
+	defer observeQuery("GetManifestAndConfig")()
+	img, err := d.getImage(serial)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return img.Config, img.Manifest, img.Hash, nil
+}
+
+// SetImageSignature saves the verified signature attestation of the image
+func (d *BoltDatabase) SetImageSignature(serial int64, signature []byte) error {
+	defer observeQuery("SetImageSignature")()
+	return d.updateImage(serial, func(img *boltImage) {
+		img.Signature = signature
+	})
+}
+
+// GetImageSignature returns the signature attestation of the image, it is nil if the image was
+// indexed without verifying the signature
+func (d *BoltDatabase) GetImageSignature(serial int64) (signature []byte, err error) {
+	defer observeQuery("GetImageSignature")()
+	img, err := d.getImage(serial)
+	if err != nil {
+		return nil, err
+	}
+	return img.Signature, nil
+}
+
+// imageLayers returns the layers of the image ordered by the stack index
+func imageLayers(tx *bolt.Tx, imageSerial int64) ([]*boltLayer, error) {
+	prefix := itob(imageSerial)
+	r := make([]*boltLayer, 0)
+	c := tx.Bucket(bucketLayer).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		l := &boltLayer{}
+		if err := json.Unmarshal(v, l); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse layer")
+		}
+		r = append(r, l)
+	}
+	return r, nil
+}
+
+func (d *BoltDatabase) GetLayers(imageSerial int64) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetLayers")()
+	r := make([]*send.ImageLayer, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		layers, err := imageLayers(tx, imageSerial)
+		if err != nil {
+			return err
+		}
+		for _, l := range layers {
+			var f boltFilesystem
+			if err = boltGet(tx.Bucket(bucketFilesystem), itob(l.Layer), &f); err != nil {
+				return errors.Wrapf(err, "failed to load filesystem %d", l.Layer)
+			}
+			r = append(r, &send.ImageLayer{
+				Serial:           l.Layer,
+				StackIndex:       l.StackIndex,
+				Hash:             f.Digest,
+				UncompressedSize: f.Size,
+			})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetLayersByDigests returns the layers (filesystems) that have the digests, the digests unknown to the proxy
+// are ignored
+func (d *BoltDatabase) GetLayersByDigests(digests []string) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetLayersByDigests")()
+	wanted := make(map[string]bool, len(digests))
+	for _, v := range digests {
+		wanted[v] = true
+	}
+	r := make([]*send.ImageLayer, 0, len(digests))
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketFilesystem).ForEach(func(k, v []byte) error {
+			var f boltFilesystem
+			if err := json.Unmarshal(v, &f); err != nil {
+				return errors.Wrapf(err, "failed to parse layer")
+			}
+			if f.Ready != nil && wanted[f.Digest] {
+				r = append(r, &send.ImageLayer{
+					Serial:           btoi(k),
+					Hash:             f.Digest,
+					UncompressedSize: f.Size,
+				})
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// forEachFile iterates the files of the filesystem in the order they were inserted
+func forEachFile(tx *bolt.Tx, fsId int64, fn func(id int64, f *boltFile) error) error {
+	files := tx.Bucket(bucketFile).Bucket(itob(fsId))
+	if files == nil {
+		return nil
+	}
+	return files.ForEach(func(k, v []byte) error {
+		f := &boltFile{}
+		if err := json.Unmarshal(v, f); err != nil {
+			return errors.Wrapf(err, "failed to parse file")
+		}
+		return fn(btoi(k), f)
+	})
+}
+
+// GetRoughDeduplicatedLayers returns the likely unique files
+// because it would be hard for the database to apply overlayfs correctly, so this deduplication
+// does not consider whiteout files.
+func (d *BoltDatabase) GetRoughDeduplicatedLayers(fromSerial, toSerial int64) ([]*send.ImageLayer, error) {
+	defer observeQuery("GetRoughDeduplicatedLayers")()
+	type content struct {
+		hash string
+		size int64
+	}
+	type source struct {
+		content
+		layer *send.ImageLayer
+	}
+
+	alpha := make(map[content]bool)
+	minLayer := make(map[content]int64)
+	beta := make([]source, 0)
+
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		for _, serial := range []int64{fromSerial, toSerial} {
+			layers, err := imageLayers(tx, serial)
+			if err != nil {
+				return err
+			}
+			for _, l := range layers {
+				var fsys boltFilesystem
+				if err = boltGet(tx.Bucket(bucketFilesystem), itob(l.Layer), &fsys); err != nil {
+					return errors.Wrapf(err, "failed to load filesystem %d", l.Layer)
+				}
+				layer := &send.ImageLayer{StackIndex: l.StackIndex, Hash: fsys.Digest, UncompressedSize: fsys.Size}
+				if err = forEachFile(tx, l.Layer, func(_ int64, f *boltFile) error {
+					if f.Hash == "" {
+						return nil
+					}
+					c := content{f.Hash, f.Size}
+					if serial == fromSerial {
+						alpha[c] = true
+						return nil
+					}
+					if m, has := minLayer[c]; !has || l.StackIndex < m {
+						minLayer[c] = l.StackIndex
+					}
+					beta = append(beta, source{c, layer})
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	r := make([]*send.ImageLayer, 0)
+	added := make(map[*send.ImageLayer]bool)
+	for _, s := range beta {
+		if alpha[s.content] || minLayer[s.content] != s.layer.StackIndex || added[s.layer] {
+			continue
+		}
+		added[s.layer] = true
+		r = append(r, s.layer)
+	}
+	return r, nil
+}
+
+func (d *BoltDatabase) GetUniqueFiles(layers []*send.ImageLayer) ([]*send.File, error) {
+	defer observeQuery("GetUniqueFiles")()
+	type row struct {
+		id   int64
+		file *send.File
+	}
+	rows := make([]row, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		for _, l := range layers {
+			fsId := l.Serial
+			if err := forEachFile(tx, fsId, func(id int64, f *boltFile) error {
+				if f.Hash == "" {
+					return nil
+				}
+				var toc common.TOCEntry
+				if err := json.Unmarshal(f.Metadata, &toc); err != nil {
+					return errors.Wrapf(err, "failed to parse ToC Entry")
+				}
+				rows = append(rows, row{id, &send.File{
+					TOCEntry: toc, // no need to parse chunks from the database
+					FsId:     fsId,
+				}})
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rows, func(i, j int) bool { return rows[i].id < rows[j].id })
+	fl := make([]*send.File, 0, len(rows))
+	for _, r := range rows {
+		fl = append(fl, r.file)
+	}
+	return fl, nil
+}
+
+func (d *BoltDatabase) UpdateFileRanks(collection *fs.TraceCollection) (fs [][][]int64, err error) {
+	defer observeQuery("UpdateFileRanks")()
+	res := make([][][]int64, 0, len(collection.Groups))
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		for _, group := range collection.Groups {
+			layersImageMap := make([][]int64, len(group.Images))
+			for idx, img := range group.Images {
+				// get image serial id
+				imageSerial, nlayer, err := readyImageByHash(tx, img)
+				if err != nil {
+					return err
+				}
+
+				// get layers of the image
+				layers, err := imageLayers(tx, imageSerial)
+				if err != nil {
+					return err
+				}
+				if int64(len(layers)) > nlayer {
+					return fmt.Errorf("image %s has %d layers but expected %d", img, len(layers), nlayer)
+				}
+
+				// update mapping
+				layersImageMap[idx] = make([]int64, nlayer)
+				for layerIdx, l := range layers {
+					layersImageMap[idx][layerIdx] = l.Layer
+				}
+			}
+
+			for _, f := range group.History {
+				fsId := itob(layersImageMap[f.SourceImage][f.Stack])
+				names, files := tx.Bucket(bucketFileName).Bucket(fsId), tx.Bucket(bucketFile).Bucket(fsId)
+				if names == nil || files == nil {
+					continue
+				}
+				id := names.Get([]byte(f.FileName))
+				if id == nil {
+					continue
+				}
+				var file boltFile
+				if err := boltGet(files, id, &file); err != nil {
+					return err
+				}
+				file.Order = append(file.Order, int64(f.Rank))
+				if err := boltPut(files, id, &file); err != nil {
+					return err
+				}
+			}
+			res = append(res, layersImageMap)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// readyImageByHash returns the first cached image that has the manifest digest regardless of its name
+func readyImageByHash(tx *bolt.Tx, hash string) (serial, nlayer int64, err error) {
+	c := tx.Bucket(bucketImage).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var img boltImage
+		if err = json.Unmarshal(v, &img); err != nil {
+			return 0, 0, err
+		}
+		if img.Ready != nil && img.Hash == hash {
+			return btoi(k), img.NLayer, nil
+		}
+	}
+	return 0, 0, sql.ErrNoRows
+}
+
+// rankedFiles returns the files of the image ordered by the stack index and the order they were inserted.
+// The rank is the average of the ranks from the traces if ranked is true.
+func (d *BoltDatabase) rankedFiles(imageSerial int64, ranked bool) ([]*send.RankedFile, error) {
+	fl := make([]*send.RankedFile, 0)
+	err := d.db.View(func(tx *bolt.Tx) error {
+		layers, err := imageLayers(tx, imageSerial)
+		if err != nil {
+			return err
+		}
+		for _, l := range layers {
+			stackIndex, fsId := l.StackIndex, l.Layer
+			if err = forEachFile(tx, fsId, func(_ int64, f *boltFile) error {
+				var file send.File
+				if err := json.Unmarshal(f.Metadata, &file); err != nil {
+					return errors.Wrapf(err, "failed to parse ToC Entry")
+				}
+				file.FsId = fsId
+
+				rank := math.MaxFloat64
+				if ranked && len(f.Order) > 0 {
+					var sum int64
+					for _, o := range f.Order {
+						sum += o
+					}
+					rank = float64(sum) / float64(len(f.Order))
+				}
+				fl = append(fl, &send.RankedFile{
+					File:  file,
+					Stack: stackIndex,
+					Rank:  rank,
+				})
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fl, nil
+}
+
+func (d *BoltDatabase) GetFilesWithRanks(imageSerial int64) ([]*send.RankedFile, error) {
+	defer observeQuery("GetFilesWithRanks")()
+	return d.rankedFiles(imageSerial, true)
+}
+
+func (d *BoltDatabase) GetFilesWithoutRanks(imageSerial int64) ([]*send.RankedFile, error) {
+	defer observeQuery("GetFilesWithoutRanks")()
+	return d.rankedFiles(imageSerial, false)
+}
+
+var (
+	// errDryRun rolls back the transaction of a dry run
+	errDryRun = errors.New("dry run")
+	// errFilesystemRemoved rolls back InsertLayer if the filesystem was removed before its ToC was loaded
+	errFilesystemRemoved = errors.New("filesystem removed")
+)
+
+func (d *BoltDatabase) CollectGarbage(policy RetentionPolicy, dryRun bool) (report *GCReport, err error) {
+	defer observeQuery("CollectGarbage")()
+	report = newGCReport(dryRun)
+	err = d.db.Update(func(tx *bolt.Tx) error {
+		if err := boltCollectTags(tx, policy.KeepTags, report); err != nil {
+			return err
+		}
+		if err := boltCollectImages(tx, policy.UntaggedMaxAge, report); err != nil {
+			return err
+		}
+		if err := boltCollectFilesystems(tx, report); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && err != errDryRun {
+		return nil, err
+	}
+	return report, nil
+}
+
+// boltCollectTags removes the tags beyond the most recent keep tags of each repository
+func boltCollectTags(tx *bolt.Tx, keep int, report *GCReport) error {
+	if keep <= 0 {
+		return nil
+	}
+
+	type tagInfo struct {
+		tag     string
+		updated time.Time
+		rows    []*GCTag
+	}
+	repositories := make(map[string]map[string]*tagInfo)
+	tags := tx.Bucket(bucketTag)
+	if err := tags.ForEach(func(k, v []byte) error {
+		parts := strings.SplitN(string(k), "\x00", 3)
+		if len(parts) != 3 {
+			return fmt.Errorf("invalid tag key %q", k)
+		}
+		var t boltTag
+		if err := json.Unmarshal(v, &t); err != nil {
+			return errors.Wrapf(err, "failed to parse tag")
+		}
+		repo, has := repositories[parts[0]]
+		if !has {
+			repo = make(map[string]*tagInfo)
+			repositories[parts[0]] = repo
+		}
+		info, has := repo[parts[1]]
+		if !has {
+			info = &tagInfo{tag: parts[1]}
+			repo[parts[1]] = info
+		}
+		if t.Updated.After(info.updated) {
+			info.updated = t.Updated
+		}
+		info.rows = append(info.rows, &GCTag{Name: parts[0], Tag: parts[1], Platform: parts[2], Image: t.Image})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, repo := range repositories {
+		sorted := make([]*tagInfo, 0, len(repo))
+		for _, info := range repo {
+			sorted = append(sorted, info)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if !sorted[i].updated.Equal(sorted[j].updated) {
+				return sorted[i].updated.After(sorted[j].updated)
+			}
+			return sorted[i].tag > sorted[j].tag
+		})
+		for i := keep; i < len(sorted); i++ {
+			for _, t := range sorted[i].rows {
+				if err := tags.Delete(boltKey(t.Name, t.Tag, t.Platform)); err != nil {
+					return err
+				}
+				report.Tags = append(report.Tags, t)
+			}
+		}
+	}
+	sort.Slice(report.Tags, func(i, j int) bool {
+		a, b := report.Tags[i], report.Tags[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		return a.Platform < b.Platform
+	})
+	return nil
+}
+
+// boltCollectImages removes the images without tags that have been cached (or inserted) before maxAge
+func boltCollectImages(tx *bolt.Tx, maxAge time.Duration, report *GCReport) error {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	tagged := make(map[int64]bool)
+	if err := tx.Bucket(bucketTag).ForEach(func(k, v []byte) error {
+		var t boltTag
+		if err := json.Unmarshal(v, &t); err != nil {
+			return errors.Wrapf(err, "failed to parse tag")
+		}
+		tagged[t.Image] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	images := tx.Bucket(bucketImage)
+	if err := images.ForEach(func(k, v []byte) error {
+		var img boltImage
+		if err := json.Unmarshal(v, &img); err != nil {
+			return errors.Wrapf(err, "failed to parse image")
+		}
+		ts := img.Ready
+		if ts == nil {
+			ts = img.Created
+		}
+		if ts != nil && ts.Before(cutoff) && !tagged[btoi(k)] {
+			report.Images = append(report.Images, &GCImage{Serial: btoi(k), Image: img.Image, Hash: img.Hash})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, img := range report.Images {
+		if err := boltDeleteImage(tx, img.Serial, img.Image, img.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltDeleteImage removes the image and its layers, the tags are not checked
+func boltDeleteImage(tx *bolt.Tx, serial int64, image, hash string) error {
+	if err := tx.Bucket(bucketImage).Delete(itob(serial)); err != nil {
+		return err
+	}
+	if err := tx.Bucket(bucketImageHash).Delete(boltKey(image, hash)); err != nil {
+		return err
+	}
+	layers := tx.Bucket(bucketLayer)
+	prefix := itob(serial)
+	keys := make([][]byte, 0)
+	c := layers.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	for _, k := range keys {
+		if err := layers.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltCollectFilesystems removes the filesystems and their files that no layer refers to
+func boltCollectFilesystems(tx *bolt.Tx, report *GCReport) error {
+	referenced := make(map[int64]bool)
+	if err := tx.Bucket(bucketLayer).ForEach(func(k, v []byte) error {
+		var l boltLayer
+		if err := json.Unmarshal(v, &l); err != nil {
+			return errors.Wrapf(err, "failed to parse layer")
+		}
+		referenced[l.Layer] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	filesystems := tx.Bucket(bucketFilesystem)
+	removed := make([]*GCFilesystem, 0)
+	if err := filesystems.ForEach(func(k, v []byte) error {
+		if referenced[btoi(k)] {
+			return nil
+		}
+		var f boltFilesystem
+		if err := json.Unmarshal(v, &f); err != nil {
+			return errors.Wrapf(err, "failed to parse filesystem")
+		}
+		removed = append(removed, &GCFilesystem{Serial: btoi(k), Digest: f.Digest, Size: f.Size})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, f := range removed {
+		id := itob(f.Serial)
+		if files := tx.Bucket(bucketFile).Bucket(id); files != nil {
+			report.Files += int64(files.Stats().KeyN)
+		}
+		for _, b := range []*bolt.Bucket{tx.Bucket(bucketFile), tx.Bucket(bucketFileName)} {
+			if err := b.DeleteBucket(id); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketFsDigest).Delete(boltKey(f.Digest, fmt.Sprint(f.Size))); err != nil {
+			return err
+		}
+		if err := filesystems.Delete(id); err != nil {
+			return err
+		}
+		report.Filesystems = append(report.Filesystems, f)
+	}
+	return nil
+}
+
+func (d *BoltDatabase) ListRepositories() ([]*RepositoryInfo, error) {
+	defer observeQuery("ListRepositories")()
+	images := make(map[string]int64)
+	tags := make(map[string]map[string]bool)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketImage).ForEach(func(k, v []byte) error {
+			var img boltImage
+			if err := json.Unmarshal(v, &img); err != nil {
+				return errors.Wrapf(err, "failed to parse image")
+			}
+			images[img.Image]++
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketTag).ForEach(func(k, v []byte) error {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("invalid tag key %q", k)
+			}
+			if tags[parts[0]] == nil {
+				tags[parts[0]] = make(map[string]bool)
+			}
+			tags[parts[0]][parts[1]] = true
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	r := make([]*RepositoryInfo, 0, len(images))
+	for n, c := range images {
+		r = append(r, &RepositoryInfo{Name: n, Tags: int64(len(tags[n])), Images: c})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Name < r[j].Name
+	})
+	return r, nil
+}
+
+func (d *BoltDatabase) ListTags(repository string) ([]*TagInfo, error) {
+	defer observeQuery("ListTags")()
+	r := make([]*TagInfo, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		// the keys are sorted by tag and platform within the repository
+		prefix := boltKey(repository, "")
+		c := tx.Bucket(bucketTag).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("invalid tag key %q", k)
+			}
+			var t boltTag
+			if err := json.Unmarshal(v, &t); err != nil {
+				return errors.Wrapf(err, "failed to parse tag")
+			}
+			info := &TagInfo{Name: parts[0], Tag: parts[1], Platform: parts[2], Image: t.Image, Updated: t.Updated}
+			var img boltImage
+			if err := boltGet(tx.Bucket(bucketImage), itob(t.Image), &img); err == nil {
+				info.Digest, info.Ready = img.Hash, img.Ready
+			} else if err != sql.ErrNoRows {
+				return err
+			}
+			r = append(r, info)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *BoltDatabase) GetImageInfo(serial int64) (*ImageInfo, error) {
+	defer observeQuery("GetImageInfo")()
+	info := &ImageInfo{Serial: serial, Layers: make([]*LayerInfo, 0)}
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		var img boltImage
+		if err := boltGet(tx.Bucket(bucketImage), itob(serial), &img); err != nil {
+			return err
+		}
+		info.Name, info.Digest, info.Created, info.Ready = img.Image, img.Hash, img.Created, img.Ready
+
+		layers, err := imageLayers(tx, serial)
+		if err != nil {
+			return err
+		}
+		for _, l := range layers {
+			var f boltFilesystem
+			if err = boltGet(tx.Bucket(bucketFilesystem), itob(l.Layer), &f); err != nil {
+				return errors.Wrapf(err, "failed to load filesystem %d", l.Layer)
+			}
+			li := &LayerInfo{StackIndex: l.StackIndex, Serial: l.Layer, Digest: f.Digest, Size: f.Size}
+			if err = forEachFile(tx, l.Layer, func(id int64, f *boltFile) error {
+				li.Files++
+				if len(f.Order) > 0 {
+					li.RankedFiles++
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			info.Layers = append(info.Layers, li)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	info.summarize()
+	return info, nil
+}
+
+func (d *BoltDatabase) DeleteTags(name, tag, platform string) ([]*GCTag, error) {
+	defer observeQuery("DeleteTags")()
+	r := make([]*GCTag, 0)
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		tags := tx.Bucket(bucketTag)
+		prefix := boltKey(name, tag, "")
+		c := tags.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			p := string(k[len(prefix):])
+			if platform != "" && p != platform {
+				continue
+			}
+			var t boltTag
+			if err := json.Unmarshal(v, &t); err != nil {
+				return errors.Wrapf(err, "failed to parse tag")
+			}
+			r = append(r, &GCTag{Name: name, Tag: tag, Platform: p, Image: t.Image})
+		}
+		for _, t := range r {
+			if err := tags.Delete(boltKey(t.Name, t.Tag, t.Platform)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *BoltDatabase) DeleteImages(name, digest string) (*GCReport, error) {
+	defer observeQuery("DeleteImages")()
+	report := newGCReport(false)
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(bucketImageHash).Get(boltKey(name, digest))
+		if id == nil {
+			return nil
+		}
+		serial := btoi(id)
+
+		tags := tx.Bucket(bucketTag)
+		if err := tags.ForEach(func(k, v []byte) error {
+			var t boltTag
+			if err := json.Unmarshal(v, &t); err != nil {
+				return errors.Wrapf(err, "failed to parse tag")
+			}
+			if t.Image != serial {
+				return nil
+			}
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 {
+				return fmt.Errorf("invalid tag key %q", k)
+			}
+			report.Tags = append(report.Tags, &GCTag{Name: parts[0], Tag: parts[1], Platform: parts[2], Image: serial})
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, t := range report.Tags {
+			if err := tags.Delete(boltKey(t.Name, t.Tag, t.Platform)); err != nil {
+				return err
+			}
+		}
+
+		report.Images = append(report.Images, &GCImage{Serial: serial, Image: name, Hash: digest})
+		return boltDeleteImage(tx, serial, name, digest)
+	}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,366 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mc256/starlight/client/fs"
+	"github.com/mc256/starlight/util/common"
+	"github.com/mc256/starlight/util/send"
+)
+
+func TestBoltDatabase_Conformance(t *testing.T) {
+	d, err := NewDatabase(context.Background(), BoltConnectionPrefix+filepath.Join(t.TempDir(), "proxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err = d.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	testDatabaseConformance(t, d)
+}
+
+func TestPostgresDatabase_Conformance(t *testing.T) {
+	d, err := NewDatabase(context.Background(), cfg.PostgresConnectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err = d.InitDatabase(); err != nil {
+		t.Skipf("PostgreSQL is not available: %v", err)
+	}
+	testDatabaseConformance(t, d)
+}
+
+// testDatabaseConformance checks the behaviour every metadata backend must have. The names and digests are unique
+// to each run so that it could use a shared database.
+func testDatabaseConformance(t *testing.T, d Database) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	digest := func(s string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s+suffix)))
+	}
+	entry := func(name, hash string, size int64) *common.TraceableEntry {
+		e := &common.TOCEntry{Name: name, Type: "reg", Size: size}
+		if hash != "" {
+			e.Digest = digest(hash)
+		}
+		return &common.TraceableEntry{TOCEntry: e, Chunks: make([]*common.TOCEntry, 0)}
+	}
+	loaded := 0
+	entries := func(ent map[string]*common.TraceableEntry) func() (map[string]*common.TraceableEntry, error) {
+		return func() (map[string]*common.TraceableEntry, error) {
+			loaded++
+			return ent, nil
+		}
+	}
+
+	// schema
+	ms, err := d.Migrations()
+	if err != nil || len(ms) == 0 {
+		t.Fatalf("expected schema migrations but got %v (%v)", ms, err)
+	}
+	for _, m := range ms {
+		if m.Applied == nil {
+			t.Errorf("migration %d (%s) has not been applied", m.Version, m.Description)
+		}
+	}
+	if pending, err := d.Migrate(true); err != nil || len(pending) != 0 {
+		t.Errorf("expected no pending migration but got %v (%v)", pending, err)
+	}
+
+	name := "conformance/app-" + suffix
+	dA, dB := digest("image-a"), digest("image-b")
+	l1, l2, l3 := digest("layer-1"), digest("layer-2"), digest("layer-3")
+	config, manifest := []byte(`{"architecture":"amd64"}`), []byte(`{"schemaVersion":2}`)
+
+	// images
+	a, existing, err := d.InsertImage(name, dA, config, manifest, 2)
+	if err != nil || existing || a == 0 {
+		t.Fatalf("insert image: serial %d existing %v: %v", a, existing, err)
+	}
+	if _, _, err = d.InsertImage(name, dA, config, manifest, 2); err == nil {
+		t.Error("expected an error inserting an image that is not ready")
+	}
+	if _, err = d.GetImageByDigest(name, dA); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an image that is not ready but got %v", err)
+	}
+
+	fsId1, existing, err := d.InsertLayer(100, a, 0, l1, entries(map[string]*common.TraceableEntry{
+		"bin/sh": entry("bin/sh", "sh", 10),
+		"etc":    {TOCEntry: &common.TOCEntry{Name: "etc", Type: "dir"}},
+	}))
+	if err != nil || existing {
+		t.Fatalf("insert layer: existing %v: %v", existing, err)
+	}
+	fsId2, _, err := d.InsertLayer(200, a, 1, l2, entries(map[string]*common.TraceableEntry{
+		"app": entry("app", "app-v1", 20),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SetImageReady(true, a); err != nil {
+		t.Fatal(err)
+	}
+	if s, existing, err := d.InsertImage(name, dA, config, manifest, 2); err != nil || !existing || s != a {
+		t.Errorf("expected existing image %d but got %d (%v, %v)", a, s, existing, err)
+	}
+
+	b, _, err := d.InsertImage(name, dB, config, manifest, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, existing, err := d.InsertLayer(100, b, 0, l1, entries(nil))
+	if err != nil || !existing || shared != fsId1 {
+		t.Errorf("expected existing filesystem %d but got %d (%v, %v)", fsId1, shared, existing, err)
+	}
+	fsId3, _, err := d.InsertLayer(300, b, 1, l3, entries(map[string]*common.TraceableEntry{
+		"app": entry("app", "app-v2", 30),
+		"lib": entry("lib", "sh", 10),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 3 {
+		t.Errorf("expected the ToC to be loaded 3 times but got %d", loaded)
+	}
+	if err = d.SetImageReady(true, b); err != nil {
+		t.Fatal(err)
+	}
+
+	// tags
+	if s, err := d.GetImage(name, dA, "linux/amd64"); err != nil || s != a {
+		t.Errorf("expected image %d by digest but got %d (%v)", a, s, err)
+	}
+	if _, err = d.GetImage(name, "latest", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown tag but got %v", err)
+	}
+	for _, s := range []int64{a, b} {
+		if err = d.SetImageTag(name, "latest", "linux/amd64", s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s, err := d.GetImage(name, "latest", "linux/amd64"); err != nil || s != b {
+		t.Errorf("expected image %d by tag but got %d (%v)", b, s, err)
+	}
+	if s, err := d.GetImageByDigest(name, dB); err != nil || s != b {
+		t.Errorf("expected image %d but got %d (%v)", b, s, err)
+	}
+	if n, err := d.GetImageNames(dA); err != nil || len(n) != 1 || n[0] != name {
+		t.Errorf("expected image name %s but got %v (%v)", name, n, err)
+	}
+
+	// manifest and signature
+	c, m, h, err := d.GetManifestAndConfig(a)
+	if err != nil || !bytes.Equal(c, config) || !bytes.Equal(m, manifest) || h != dA {
+		t.Errorf("unexpected manifest and config %s %s %s (%v)", c, m, h, err)
+	}
+	if sig, err := d.GetImageSignature(a); err != nil || sig != nil {
+		t.Errorf("expected no signature but got %s (%v)", sig, err)
+	}
+	signature := []byte(`{"p":"cGF5bG9hZA==","s":"c2ln"}`)
+	if err = d.SetImageSignature(a, signature); err != nil {
+		t.Fatal(err)
+	}
+	if sig, err := d.GetImageSignature(a); err != nil || !bytes.Equal(sig, signature) {
+		t.Errorf("expected signature %s but got %s (%v)", signature, sig, err)
+	}
+	if _, _, _, err = d.GetManifestAndConfig(math.MaxInt32); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown image but got %v", err)
+	}
+
+	// layers
+	layers, err := d.GetLayers(b)
+	if err != nil || len(layers) != 2 {
+		t.Fatalf("expected 2 layers but got %v (%v)", layers, err)
+	}
+	for i, expected := range []send.ImageLayer{
+		{StackIndex: 0, Serial: fsId1, Hash: l1, UncompressedSize: 100},
+		{StackIndex: 1, Serial: fsId3, Hash: l3, UncompressedSize: 300},
+	} {
+		if *layers[i] != expected {
+			t.Errorf("layer %d: expected %+v but got %+v", i, expected, *layers[i])
+		}
+	}
+	byDigest, err := d.GetLayersByDigests([]string{l2, l3, digest("unknown")})
+	if err != nil || len(byDigest) != 2 || byDigest[0].Serial != fsId2 || byDigest[1].Serial != fsId3 {
+		t.Errorf("expected filesystems %d and %d but got %v (%v)", fsId2, fsId3, byDigest, err)
+	}
+	dedup, err := d.GetRoughDeduplicatedLayers(a, b)
+	if err != nil || len(dedup) != 1 || dedup[0].StackIndex != 1 || dedup[0].Hash != l3 {
+		t.Errorf("expected only the top layer of the new image but got %v (%v)", dedup, err)
+	}
+
+	// files
+	unique, err := d.GetUniqueFiles([]*send.ImageLayer{{Serial: fsId1}, {Serial: fsId2}})
+	if err != nil || len(unique) != 2 {
+		t.Fatalf("expected the 2 regular files but got %v (%v)", unique, err)
+	}
+	for _, f := range unique {
+		if (f.Name == "bin/sh" && f.FsId != fsId1) || (f.Name == "app" && f.FsId != fsId2) {
+			t.Errorf("unexpected filesystem %d of %s", f.FsId, f.Name)
+		}
+	}
+
+	for _, rank := range []int{1, 4} {
+		arr, err := d.UpdateFileRanks(&fs.TraceCollection{Groups: []*fs.OptimizedGroup{{
+			Images: []string{dB},
+			History: []*fs.OptimizedTraceItem{
+				{TraceItem: fs.TraceItem{FileName: "app", Stack: 1}, Rank: rank},
+			},
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(arr) != 1 || len(arr[0]) != 1 || arr[0][0][0] != fsId1 || arr[0][0][1] != fsId3 {
+			t.Errorf("unexpected layer mapping %v", arr)
+		}
+	}
+	if _, err = d.UpdateFileRanks(&fs.TraceCollection{Groups: []*fs.OptimizedGroup{{
+		Images: []string{digest("unknown")},
+	}}}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown image but got %v", err)
+	}
+
+	ranked, err := d.GetFilesWithRanks(b)
+	if err != nil || len(ranked) != 4 {
+		t.Fatalf("expected 4 files but got %v (%v)", ranked, err)
+	}
+	for i, f := range ranked {
+		if i > 0 && ranked[i-1].Stack > f.Stack {
+			t.Error("files are not ordered by the stack index")
+		}
+		switch f.Name {
+		case "app":
+			if f.Rank != 2.5 || f.FsId != fsId3 || f.Stack != 1 {
+				t.Errorf("expected rank 2.5 of app but got %+v", f)
+			}
+		default:
+			if f.Rank != math.MaxFloat64 {
+				t.Errorf("expected %s without rank but got %f", f.Name, f.Rank)
+			}
+		}
+	}
+	unranked, err := d.GetFilesWithoutRanks(b)
+	if err != nil || len(unranked) != 4 {
+		t.Fatalf("expected 4 files but got %v (%v)", unranked, err)
+	}
+	for _, f := range unranked {
+		if f.Rank != math.MaxFloat64 {
+			t.Errorf("expected %s without rank but got %f", f.Name, f.Rank)
+		}
+	}
+
+	// admin
+	repos, err := d.ListRepositories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range repos {
+		if r.Name == name {
+			found = true
+			if r.Tags != 1 || r.Images != 2 {
+				t.Errorf("expected 1 tag and 2 images but got %+v", r)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("repository %s is not listed", name)
+	}
+	if tags, err := d.ListTags(name); err != nil || len(tags) != 1 ||
+		tags[0].Image != b || tags[0].Digest != dB || tags[0].Ready == nil || tags[0].Platform != "linux/amd64" {
+		t.Errorf("unexpected tags %v (%v)", tags, err)
+	}
+	info, err := d.GetImageInfo(b)
+	if err != nil || len(info.Layers) != 2 {
+		t.Fatalf("expected 2 layers but got %+v (%v)", info, err)
+	}
+	if info.Name != name || info.Digest != dB || info.Ready == nil || info.Created == nil {
+		t.Errorf("unexpected image %+v", info)
+	}
+	for i, expected := range []LayerInfo{
+		{StackIndex: 0, Serial: fsId1, Digest: l1, Size: 100, Files: 2},
+		{StackIndex: 1, Serial: fsId3, Digest: l3, Size: 300, Files: 2, RankedFiles: 1},
+	} {
+		if *info.Layers[i] != expected {
+			t.Errorf("layer %d: expected %+v but got %+v", i, expected, *info.Layers[i])
+		}
+	}
+	if info.Files != 4 || info.RankedFiles != 1 || info.RankCoverage != 0.25 {
+		t.Errorf("expected 1 of 4 files ranked but got %+v", info)
+	}
+	if _, err = d.GetImageInfo(math.MaxInt32); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an unknown image but got %v", err)
+	}
+
+	if err = d.SetImageReady(false, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.GetImage(name, dA, "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for an image that is not ready but got %v", err)
+	}
+
+	// delete
+	if removed, err := d.DeleteTags(name, "latest", "linux/arm64"); err != nil || len(removed) != 0 {
+		t.Errorf("expected no tag to be removed but got %v (%v)", removed, err)
+	}
+	for _, p := range []string{"linux/amd64", "linux/arm64"} {
+		if err = d.SetImageTag(name, "v1", p, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := d.DeleteTags(name, "v1", "linux/arm64"); err != nil || len(removed) != 1 ||
+		*removed[0] != (GCTag{Name: name, Tag: "v1", Platform: "linux/arm64", Image: a}) {
+		t.Errorf("expected the arm64 tag to be removed but got %v (%v)", removed, err)
+	}
+	if removed, err := d.DeleteTags(name, "v1", ""); err != nil || len(removed) != 1 || removed[0].Platform != "linux/amd64" {
+		t.Errorf("expected the amd64 tag to be removed but got %v (%v)", removed, err)
+	}
+	if _, err = d.GetImage(name, "v1", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the removed tag but got %v", err)
+	}
+
+	report, err := d.DeleteImages(name, dB)
+	if err != nil || len(report.Tags) != 1 || report.Tags[0].Tag != "latest" ||
+		len(report.Images) != 1 || *report.Images[0] != (GCImage{Serial: b, Image: name, Hash: dB}) {
+		t.Errorf("expected image %d and its tag to be removed but got %+v (%v)", b, report, err)
+	}
+	if _, err = d.GetImage(name, "latest", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the tag of the removed image but got %v", err)
+	}
+	if _, err = d.GetImageByDigest(name, dB); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the removed image but got %v", err)
+	}
+	if l, err := d.GetLayers(b); err != nil || len(l) != 0 {
+		t.Errorf("expected no layers of the removed image but got %v (%v)", l, err)
+	}
+	if report, err = d.DeleteImages(name, dB); err != nil || !report.empty() {
+		t.Errorf("expected nothing to be removed but got %+v (%v)", report, err)
+	}
+}
@@ -17,7 +17,7 @@ import (
 )
 
 var (
-	db  *Database
+	db  Database
 	cfg *Configuration
 )
 
@@ -0,0 +1,308 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/containerd/containerd/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RegistryEvent is an event of the registry notification endpoint
+// (https://distribution.github.io/distribution/about/notifications/), only the fields the proxy uses are parsed
+type RegistryEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Target struct {
+		MediaType  string `json:"mediaType"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		// Host is the registry host of the request that caused the event
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// repository returns the name of the repository in the database, the events from the registries other than
+// the default registry and its aliases refer to the repositories prefixed with the registry
+func (ev *RegistryEvent) repository(a *Server) string {
+	if ev.Request.Host == "" {
+		return a.repositories(ev.Target.Repository)[0]
+	}
+	return a.repositories(ev.Request.Host + "/" + ev.Target.Repository)[0]
+}
+
+// RegistryEventEnvelope is the body of a registry notification
+type RegistryEventEnvelope struct {
+	Events []*RegistryEvent `json:"events"`
+}
+
+// deleteReference removes the tag of the platform (all the platforms if plt is empty) if ref is a tag, or the
+// images that have the manifest digest if ref is a digest
+func (a *Server) deleteReference(ref, plt string) (*GCReport, error) {
+	r, err := name.ParseReference(ref,
+		name.WithDefaultRegistry(a.config.DefaultRegistry),
+		name.WithDefaultTag("latest-starlight"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	refName, refId := ParseImageReference(r, a.config.DefaultRegistry, a.config.DefaultRegistryAlias)
+
+	if _, ok := r.(name.Digest); ok {
+		return a.db.DeleteImages(refName, refId)
+	}
+	report := newGCReport(false)
+	if report.Tags, err = a.db.DeleteTags(refName, refId, plt); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// reconcileTags removes the tags of the repository that no longer exist in the registry. A registry only
+// reports the digest of a deleted image index, which is not in the database, and the tags pointing to it
+// are removed together with it.
+func (a *Server) reconcileTags(repository string, insecure bool) ([]*GCTag, error) {
+	tags, err := a.db.ListTags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []name.Option{name.WithDefaultRegistry(a.config.DefaultRegistry)}
+	if insecure {
+		opts = append(opts, name.Insecure)
+	}
+	removed := make([]*GCTag, 0)
+	checked := make(map[string]bool)
+	for _, t := range tags {
+		if checked[t.Tag] {
+			continue
+		}
+		checked[t.Tag] = true
+
+		ref, err := name.NewTag(fmt.Sprintf("%s:%s", repository, t.Tag), opts...)
+		if err != nil {
+			return nil, err
+		}
+		_, err = remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain), remote.WithContext(a.ctx))
+		var terr *transport.Error
+		if err == nil || !errors.As(err, &terr) || terr.StatusCode != http.StatusNotFound {
+			if err != nil {
+				log.G(a.ctx).WithField("tag", ref.String()).WithError(err).Warn("failed to check tag")
+			}
+			continue
+		}
+
+		r, err := a.db.DeleteTags(repository, t.Tag, "")
+		if err != nil {
+			return nil, err
+		}
+		removed = append(removed, r...)
+	}
+	return removed, nil
+}
+
+// tagReconciler runs reconcileTags in the background, one at a time for each repository. The registry does not
+// wait for the tags to be checked, otherwise its notification could time out and be delivered again.
+type tagReconciler struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+	// running is true if the repository is being checked and it has to be checked again after that
+	running map[string]bool
+}
+
+// reconcile checks the tags of the repository in the background
+func (a *Server) reconcile(repository string, insecure bool) {
+	r := &a.reconciler
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running == nil {
+		r.running = make(map[string]bool)
+	}
+	if _, ok := r.running[repository]; ok {
+		// the tags could have been checked before the event
+		r.running[repository] = true
+		return
+	}
+	r.running[repository] = false
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for again := true; again; {
+			tags, err := a.reconcileTags(repository, insecure)
+			entry := log.G(a.ctx).WithField("repository", repository)
+			if err != nil {
+				entry.WithError(err).Error("failed to reconcile tags")
+			} else if len(tags) > 0 {
+				entry.WithField("tags", len(tags)).Info("removed tags deleted from the registry")
+			}
+
+			r.mu.Lock()
+			if again = r.running[repository]; again {
+				r.running[repository] = false
+			} else {
+				delete(r.running, repository)
+			}
+			r.mu.Unlock()
+		}
+	}()
+}
+
+// deleteImage removes a tag (ref=name:tag, optionally of one platform) or the images with a manifest digest
+// (ref=name@digest) so that they are no longer served. The filesystems are left to the garbage collector.
+func (a *Server) deleteImage(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "delete", "ip": a.getIpAddress(req)}).Info("request received")
+
+	if req.Method != http.MethodDelete {
+		a.respond(w, req, &ApiResponse{
+			Status: "Method Not Allowed",
+			Code:   http.StatusMethodNotAllowed,
+			Error:  "use DELETE to remove a tag or an image",
+		})
+		return
+	}
+
+	ref := q.Get("ref")
+	if ref == "" {
+		a.error(w, req, "missing parameters")
+		return
+	}
+
+	if _, ok := a.authorize(w, req, a.repositories(ref)); !ok {
+		return
+	}
+
+	report, err := a.deleteReference(ref, q.Get("platform"))
+	if err != nil {
+		log.G(a.ctx).WithError(err).Error("failed to delete image")
+		a.error(w, req, err.Error())
+		return
+	}
+	if report.empty() {
+		a.respond(w, req, &ApiResponse{
+			Status: "Not Found",
+			Code:   http.StatusNotFound,
+			Error:  fmt.Sprintf("requested image %s not found", ref),
+		})
+		return
+	}
+
+	log.G(a.ctx).WithFields(logrus.Fields{
+		"ref":    ref,
+		"tags":   len(report.Tags),
+		"images": len(report.Images),
+	}).Info("deleted image")
+	a.respond(w, req, &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		Deleted: report,
+	})
+}
+
+// registryEvents handles the notifications of the registry. A deleted tag or manifest is removed from the
+// database, if the digest is unknown to the proxy (e.g. an image index) the tags of the repository are checked
+// against the registry in the background. Set insecure=true in the endpoint URL if the registry uses HTTP.
+func (a *Server) registryEvents(w http.ResponseWriter, req *http.Request) {
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "registry-events", "ip": a.getIpAddress(req)}).Info("request received")
+
+	if req.Method != http.MethodPost {
+		a.respond(w, req, &ApiResponse{
+			Status: "Method Not Allowed",
+			Code:   http.StatusMethodNotAllowed,
+			Error:  "registry notifications must be sent with POST",
+		})
+		return
+	}
+
+	var envelope RegistryEventEnvelope
+	if err := json.NewDecoder(req.Body).Decode(&envelope); err != nil {
+		a.error(w, req, fmt.Sprintf("cannot parse registry events: %v", err))
+		return
+	}
+
+	deletes := make([]*RegistryEvent, 0)
+	repos := make([]string, 0)
+	for _, ev := range envelope.Events {
+		if ev.Action != "delete" || ev.Target.Repository == "" {
+			continue
+		}
+		deletes = append(deletes, ev)
+		repos = append(repos, ev.repository(a))
+	}
+
+	if _, ok := a.authorize(w, req, repos); !ok {
+		return
+	}
+
+	insecure := req.URL.Query().Get("insecure") == "true"
+	report := newGCReport(false)
+	for i, ev := range deletes {
+		repo := repos[i]
+		entry := log.G(a.ctx).WithFields(logrus.Fields{
+			"event":      ev.ID,
+			"repository": repo,
+			"tag":        ev.Target.Tag,
+			"digest":     ev.Target.Digest,
+		})
+
+		if ev.Target.Tag != "" {
+			tags, err := a.db.DeleteTags(repo, ev.Target.Tag, "")
+			if err != nil {
+				entry.WithError(err).Error("failed to delete tag")
+				a.error(w, req, err.Error())
+				return
+			}
+			report.Tags = append(report.Tags, tags...)
+		}
+
+		if ev.Target.Digest != "" && ev.Target.Tag == "" {
+			r, err := a.db.DeleteImages(repo, ev.Target.Digest)
+			if err != nil {
+				entry.WithError(err).Error("failed to delete image")
+				a.error(w, req, err.Error())
+				return
+			}
+			report.merge(r)
+
+			if len(r.Images) == 0 {
+				a.reconcile(repo, insecure)
+			}
+		}
+		entry.Info("registry delete event")
+	}
+
+	a.respond(w, req, &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		Deleted: report,
+	})
+}
@@ -0,0 +1,146 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestServer_DeleteImage(t *testing.T) {
+	a, d := newTestServer(t)
+	digest := "sha256:" + strings.Repeat("a", 64)
+	serial := insertTaggedImage(t, d, "delete/app", digest, nil, "v1", "v2")
+
+	if code, _ := serve(t, a.deleteImage, http.MethodGet, "/starlight/image?ref=delete/app:v1", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed but got %d", code)
+	}
+	if code, _ := serve(t, a.deleteImage, http.MethodDelete, "/starlight/image", nil); code != http.StatusBadRequest {
+		t.Errorf("expected bad request but got %d", code)
+	}
+
+	code, res := serve(t, a.deleteImage, http.MethodDelete, "/starlight/image?ref=delete/app:v1&platform=linux/arm64", nil)
+	if code != http.StatusOK || len(res.Deleted.Tags) != 1 || res.Deleted.Tags[0].Platform != "linux/arm64" {
+		t.Errorf("expected the arm64 tag to be removed but got %d %+v", code, res.Deleted)
+	}
+	if s, err := d.GetImage("delete/app", "v1", "linux/amd64"); err != nil || s != serial {
+		t.Errorf("expected the amd64 tag to be kept: %d (%v)", s, err)
+	}
+
+	code, res = serve(t, a.deleteImage, http.MethodDelete, "/starlight/image?ref=delete/app@"+digest, nil)
+	if code != http.StatusOK || len(res.Deleted.Images) != 1 || len(res.Deleted.Tags) != 3 {
+		t.Errorf("expected the image and its 3 tags to be removed but got %d %+v", code, res.Deleted)
+	}
+	if _, err := d.GetImage("delete/app", "v2", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the removed tag but got %v", err)
+	}
+
+	if code, _ := serve(t, a.deleteImage, http.MethodDelete, "/starlight/image?ref=delete/app:v2", nil); code != http.StatusNotFound {
+		t.Errorf("expected not found but got %d", code)
+	}
+}
+
+func TestServer_RegistryEvents(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+
+	a, d := newTestServer(t)
+	a.config.DefaultRegistry = strings.TrimPrefix(s.URL, "http://")
+
+	// v2 and v3 are still in the registry, v1 has been deleted together with the image index
+	for _, tag := range []string{"v2", "v3"} {
+		img, err := random.Image(16, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref, err := name.NewTag(a.config.DefaultRegistry+"/events/app:"+tag, name.Insecure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertTaggedImage(t, d, "events/app", "sha256:v1", nil, "v1")
+	insertTaggedImage(t, d, "events/app", "sha256:v2", nil, "v2")
+	v3 := insertTaggedImage(t, d, "events/app", "sha256:v3", nil, "v3")
+
+	events := func(evs ...string) io.Reader {
+		return bytes.NewBufferString(`{"events":[` + strings.Join(evs, ",") + `]}`)
+	}
+
+	if code, _ := serve(t, a.registryEvents, http.MethodGet, "/starlight/registry/events", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed but got %d", code)
+	}
+
+	// push events are ignored
+	code, res := serve(t, a.registryEvents, http.MethodPost, "/starlight/registry/events?insecure=true", events(
+		`{"id":"1","action":"push","target":{"repository":"events/app","digest":"sha256:v3","tag":"v3"}}`,
+	))
+	if code != http.StatusOK || !res.Deleted.empty() {
+		t.Errorf("expected nothing to be removed but got %d %+v", code, res.Deleted)
+	}
+
+	// the same repository in another registry
+	code, res = serve(t, a.registryEvents, http.MethodPost, "/starlight/registry/events", events(
+		`{"id":"2","action":"delete","target":{"repository":"events/app","tag":"v1"},"request":{"host":"other.example.com"}}`,
+	))
+	if code != http.StatusOK || !res.Deleted.empty() {
+		t.Errorf("expected nothing to be removed but got %d %+v", code, res.Deleted)
+	}
+
+	// the digest of the index is unknown to the proxy, the tags are checked in the background
+	code, res = serve(t, a.registryEvents, http.MethodPost, "/starlight/registry/events?insecure=true", events(
+		`{"id":"3","action":"delete","target":{"repository":"events/app","digest":"sha256:index"},`+
+			`"request":{"host":"`+a.config.DefaultRegistry+`"}}`,
+	))
+	if code != http.StatusOK || !res.Deleted.empty() {
+		t.Errorf("expected nothing to be removed by the request but got %d %+v", code, res.Deleted)
+	}
+	a.reconciler.wg.Wait()
+	if _, err := d.GetImage("events/app", "v1", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for v1 but got %v", err)
+	}
+	if _, err := d.GetImage("events/app", "v2", "linux/amd64"); err != nil {
+		t.Errorf("expected v2 to be kept but got %v", err)
+	}
+
+	code, res = serve(t, a.registryEvents, http.MethodPost, "/starlight/registry/events", events(
+		`{"id":"4","action":"delete","target":{"repository":"events/app","tag":"v2"}}`,
+		`{"id":"5","action":"delete","target":{"repository":"events/app","digest":"sha256:v3"}}`,
+	))
+	if code != http.StatusOK || len(res.Deleted.Tags) != 4 || len(res.Deleted.Images) != 1 ||
+		res.Deleted.Images[0].Serial != v3 {
+		t.Errorf("expected the tags of v2 and the image v3 to be removed but got %d %+v", code, res.Deleted)
+	}
+	for _, tag := range []string{"v2", "v3"} {
+		if _, err := d.GetImage("events/app", tag, "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for %s but got %v", tag, err)
+		}
+	}
+}
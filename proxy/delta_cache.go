@@ -0,0 +1,187 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/mc256/starlight/util/send"
+	"github.com/opencontainers/go-digest"
+	"golang.org/x/sync/singleflight"
+)
+
+// deltaHeader is the beginning of the delta image: the compressed manifest, config and Starlight header
+type deltaHeader struct {
+	buf                                           []byte
+	manifestSize, configSize, starlightHeaderSize int64
+	digest                                        digest.Digest
+}
+
+// deltaPlanKey identifies a delta image. The source image is identified by its serial, which is resolved
+// from the digest the client has provided, and it is 0 if the client does not have any base image.
+// available is the sorted serials of the other available layers on the client that are layers of the
+// destination image or have the contents of its files, the unrelated layers of the clients do not split the cache.
+type deltaPlanKey struct {
+	source      int64
+	available   string
+	destination int64
+	sorted      bool
+}
+
+func (k deltaPlanKey) String() string {
+	return fmt.Sprintf("%d+[%s]->%d (sorted=%v)", k.source, k.available, k.destination, k.sorted)
+}
+
+// deltaPlan is the result of Builder.computeDelta and the serialized header, it does not change unless
+// the ranks of the files change. The plan is shared by the builders and must not be modified.
+type deltaPlan struct {
+	contents       []*send.Content
+	requestedFiles []*send.RankedFile
+	bodyLength     int64
+	manifestDigest string
+	header         *deltaHeader
+
+	// layers are the filesystem serials of the destination image
+	layers map[int64]bool
+}
+
+type deltaPlanEntry struct {
+	key  deltaPlanKey
+	plan *deltaPlan
+}
+
+// DeltaPlanCache keeps the most recently used delta plans, so that many workers pulling the same image
+// share one computation. Concurrent requests for the same plan wait for the first one to compute it.
+type DeltaPlanCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[deltaPlanKey]*list.Element
+	lru     *list.List
+
+	// generation increases on every invalidation, a plan computed across an invalidation is not stored
+	generation int64
+
+	group singleflight.Group
+}
+
+// Get returns the cached plan, or computes it using compute. hit is true if the plan has not been computed
+// by this call. A nil cache computes the plan every time.
+func (c *DeltaPlanCache) Get(key deltaPlanKey, compute func() (*deltaPlan, error)) (plan *deltaPlan, hit bool, err error) {
+	if c == nil || c.size <= 0 {
+		plan, err = compute()
+		return plan, false, err
+	}
+
+	c.mutex.Lock()
+	if e, has := c.entries[key]; has {
+		c.lru.MoveToFront(e)
+		c.mutex.Unlock()
+		deltaPlanCacheCounter.WithLabelValues("hit").Inc()
+		return e.Value.(*deltaPlanEntry).plan, true, nil
+	}
+	generation := c.generation
+	c.mutex.Unlock()
+
+	computed := false
+	v, err, _ := c.group.Do(key.String(), func() (interface{}, error) {
+		computed = true
+		p, err := compute()
+		if err != nil {
+			return nil, err
+		}
+		c.put(key, p, generation)
+		return p, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if computed {
+		deltaPlanCacheCounter.WithLabelValues("miss").Inc()
+	} else {
+		deltaPlanCacheCounter.WithLabelValues("hit").Inc()
+	}
+	return v.(*deltaPlan), !computed, nil
+}
+
+func (c *DeltaPlanCache) put(key deltaPlanKey, plan *deltaPlan, generation int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if e, has := c.entries[key]; has {
+		e.Value.(*deltaPlanEntry).plan = plan
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&deltaPlanEntry{key: key, plan: plan})
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*deltaPlanEntry).key)
+	}
+}
+
+// InvalidateLayers removes the sorted plans of the images that contain any of the filesystems,
+// it should be called after the ranks of the files in these filesystems have been updated.
+// The plans without sorting do not depend on the ranks and are kept.
+func (c *DeltaPlanCache) InvalidateLayers(layers ...int64) (removed int) {
+	if c == nil {
+		return 0
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for key, e := range c.entries {
+		if !key.sorted {
+			continue
+		}
+		for _, l := range layers {
+			if e.Value.(*deltaPlanEntry).plan.layers[l] {
+				c.lru.Remove(e)
+				delete(c.entries, key)
+				removed++
+				break
+			}
+		}
+	}
+	return removed
+}
+
+// Len returns the number of cached plans
+func (c *DeltaPlanCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lru.Len()
+}
+
+// NewDeltaPlanCache creates a cache that keeps at most size plans, size 0 disables the cache
+func NewDeltaPlanCache(size int) *DeltaPlanCache {
+	return &DeltaPlanCache{
+		size:    size,
+		entries: make(map[deltaPlanKey]*list.Element),
+		lru:     list.New(),
+	}
+}
@@ -0,0 +1,101 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeltaPlanCache_Get(t *testing.T) {
+	c := NewDeltaPlanCache(2)
+
+	var computed int32
+	compute := func(layers ...int64) func() (*deltaPlan, error) {
+		return func() (*deltaPlan, error) {
+			atomic.AddInt32(&computed, 1)
+			time.Sleep(10 * time.Millisecond)
+			p := &deltaPlan{layers: make(map[int64]bool)}
+			for _, l := range layers {
+				p.layers[l] = true
+			}
+			return p, nil
+		}
+	}
+
+	// concurrent requests share one computation
+	k1 := deltaPlanKey{source: 1, destination: 2, sorted: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.Get(k1, compute(10, 11)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if computed != 1 {
+		t.Fatalf("expected 1 computation but got %d", computed)
+	}
+	if _, hit, _ := c.Get(k1, compute(10, 11)); !hit {
+		t.Fatal("expected cache hit")
+	}
+
+	// least recently used plan is evicted
+	k2 := deltaPlanKey{destination: 2, sorted: false}
+	k3 := deltaPlanKey{destination: 3, sorted: true}
+	_, _, _ = c.Get(k2, compute(10, 11))
+	_, _, _ = c.Get(k1, compute(10, 11))
+	_, _, _ = c.Get(k3, compute(12))
+	if _, has := c.entries[k2]; has || c.Len() != 2 {
+		t.Fatalf("expected %v to be evicted", k2)
+	}
+
+	// only the sorted plans of the images with updated ranks are invalidated
+	if removed := c.InvalidateLayers(11); removed != 1 {
+		t.Fatalf("expected 1 plan invalidated but got %d", removed)
+	}
+	if _, has := c.entries[k1]; has {
+		t.Fatalf("expected %v to be invalidated", k1)
+	}
+	if _, has := c.entries[k3]; !has {
+		t.Fatalf("expected %v to be kept", k3)
+	}
+	_, _, _ = c.Get(k2, compute(10, 11))
+	if removed := c.InvalidateLayers(10); removed != 0 {
+		t.Fatalf("expected plan without sorting to be kept but %d removed", removed)
+	}
+}
+
+func TestDeltaPlanCache_InvalidateWhileComputing(t *testing.T) {
+	c := NewDeltaPlanCache(2)
+	k := deltaPlanKey{destination: 1, sorted: true}
+	_, hit, err := c.Get(k, func() (*deltaPlan, error) {
+		c.InvalidateLayers(1)
+		return &deltaPlan{layers: map[int64]bool{1: true}}, nil
+	})
+	if err != nil || hit {
+		t.Fatalf("unexpected result hit=%v err=%v", hit, err)
+	}
+	if c.Len() != 0 {
+		t.Fatal("plan computed across an invalidation should not be cached")
+	}
+}
@@ -20,17 +20,19 @@ package proxy
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"path"
+	"time"
 
 	"github.com/containerd/containerd/log"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
-	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/mc256/starlight/util"
 	"github.com/mc256/starlight/util/common"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -58,7 +60,8 @@ func (ex *Extractor) saveImage(img *v1.Image) (
 	}
 	digest := d.String()
 
-	config, err := (*img).ConfigFile()
+	// keep the raw bytes, the clients verify them against the digests
+	config, err := (*img).RawConfigFile()
 	if err != nil {
 		return
 	}
@@ -67,8 +70,12 @@ func (ex *Extractor) saveImage(img *v1.Image) (
 	if err != nil {
 		return
 	}
+	rawManifest, err := (*img).RawManifest()
+	if err != nil {
+		return
+	}
 
-	serial, existing, err = ex.server.db.InsertImage(ex.ParsedName, digest, config, manifest, int64(len(manifest.Layers)))
+	serial, existing, err = ex.server.db.InsertImage(ex.ParsedName, digest, config, rawManifest, int64(len(manifest.Layers)))
 	if err != nil {
 		return
 	}
@@ -85,12 +92,6 @@ func (ex *Extractor) enableImage(serial int64) error {
 }
 
 func (ex *Extractor) saveLayer(imageSerial, idx int64, layer v1.Layer) error {
-	txn, err := ex.server.db.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer txn.Commit()
-
 	size, err := layer.Size()
 	if err != nil {
 		return err
@@ -100,64 +101,91 @@ func (ex *Extractor) saveLayer(imageSerial, idx int64, layer v1.Layer) error {
 		return err
 	}
 
-	layerRef, existing, err := ex.server.db.InsertLayer(txn, size, imageSerial, idx, digest.String())
+	_, _, err = ex.server.db.InsertLayer(size, imageSerial, idx, digest.String(),
+		func() (map[string]*common.TraceableEntry, error) {
+			return layerEntries(layer)
+		})
+	return err
+}
+
+// layerEntries loads the ToC of the layer from the registry
+func layerEntries(layer v1.Layer) (map[string]*common.TraceableEntry, error) {
+	src, err := layer.Compressed()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer src.Close()
+	buf := bytes.NewBuffer([]byte{})
+	_, err = io.Copy(buf, src)
+	if err != nil {
+		return nil, err
 	}
 
-	if !existing {
-		var src io.ReadCloser
-		src, err = layer.Compressed()
-		if err != nil {
-			return err
-		}
-		buf := bytes.NewBuffer([]byte{})
-		_, err = io.Copy(buf, src)
-		if err != nil {
-			return err
-		}
-
-		reader := bytes.NewReader(buf.Bytes())
-		sr := io.NewSectionReader(reader, 0, reader.Size())
-		layerFile, err := common.OpenStargz(sr)
-		if err != nil {
-			return err
-		}
-
-		// Get TOC
-		entryMap, chunks, _ := layerFile.GetTOC()
+	reader := bytes.NewReader(buf.Bytes())
+	sr := io.NewSectionReader(reader, 0, reader.Size())
+	layerFile, err := common.OpenStargz(sr)
+	if err != nil {
+		return nil, err
+	}
 
-		// entries map
-		entBuffer := make(map[string]*common.TraceableEntry)
-		for k, v := range entryMap {
-			entBuffer[k] = &common.TraceableEntry{
-				TOCEntry: v,
-				Chunks:   make([]*common.TOCEntry, 0),
-			}
-		}
+	// Get TOC
+	entryMap, chunks, _ := layerFile.GetTOC()
 
-		// chunks
-		for k, v := range chunks {
-			extEntry := entBuffer[k]
-			extEntry.Chunks = append(extEntry.Chunks, v...)
+	// entries map
+	entBuffer := make(map[string]*common.TraceableEntry)
+	for k, v := range entryMap {
+		entBuffer[k] = &common.TraceableEntry{
+			TOCEntry: v,
+			Chunks:   make([]*common.TOCEntry, 0),
 		}
+	}
 
-		err = ex.server.db.InsertFiles(txn, layerRef, entBuffer)
-		if err != nil {
-			return err
-		}
+	// chunks
+	for k, v := range chunks {
+		extEntry := entBuffer[k]
+		extEntry.Chunks = append(extEntry.Chunks, v...)
 	}
 
-	return nil
+	return entBuffer, nil
+}
+
+// verifySignature returns the signature attestation of the manifest that is signed by a trusted key.
+// indexSignatures are the signatures of the image index the manifest belongs to, they cover the manifest as well.
+// It returns nil if signature verification is disabled.
+func (ex *Extractor) verifySignature(manifestDigest string, indexSignatures []*common.SignatureAttestation) (
+	*common.SignatureAttestation, error) {
+	if ex.server.signatures == nil {
+		return nil, nil
+	}
+	signatures, err := common.FetchSignatures(ex.ref.Context(), manifestDigest, nil,
+		remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return nil, err
+	}
+	return ex.server.signatures.Verify(manifestDigest, append(signatures, indexSignatures...))
+}
 
+// indexSignatures returns the signatures of the image index, nil if signature verification is disabled
+func (ex *Extractor) indexSignatures(desc *remote.Descriptor) ([]*common.SignatureAttestation, error) {
+	if ex.server.signatures == nil {
+		return nil, nil
+	}
+	return common.FetchSignatures(ex.ref.Context(), desc.Digest.String(), desc.Manifest,
+		remote.WithAuthFromKeychain(authn.DefaultKeychain))
 }
 
-func (ex *Extractor) saveToCPerImage(img v1.Image, m v1.Descriptor) error {
+func (ex *Extractor) saveToCPerImage(img v1.Image, m v1.Descriptor, indexSignatures []*common.SignatureAttestation) error {
 	pltStr := "uni-arch"
 	if plt := m.Platform; plt != nil {
 		pltStr = path.Join(plt.OS, plt.Architecture, plt.Variant)
 	}
 
+	// refuse to index the image if it is not signed by a trusted key
+	signature, err := ex.verifySignature(m.Digest.String(), indexSignatures)
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify signature of %s", m.Digest)
+	}
+
 	log.G(ex.server.ctx).WithFields(logrus.Fields{
 		"image":    ex.ParsedName,
 		"tag":      ex.ParsedTag,
@@ -177,6 +205,12 @@ func (ex *Extractor) saveToCPerImage(img v1.Image, m v1.Descriptor) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to save image")
 	}
+	if signature != nil {
+		buf, _ := json.Marshal(signature)
+		if err = ex.server.db.SetImageSignature(serial, buf); err != nil {
+			return errors.Wrapf(err, "failed to save signature")
+		}
+	}
 
 	// Insert into the "layer" - "filesystem" - "file" tables
 	if !existing {
@@ -204,20 +238,63 @@ func (ex *Extractor) saveToCPerImage(img v1.Image, m v1.Descriptor) error {
 		return errors.Wrapf(err, "failed to cache ToC")
 	}
 
+	compression, chunking := ex.layerPolicy(img)
 	log.G(ex.server.ctx).WithFields(logrus.Fields{
-		"image":    ex.ParsedName,
-		"tag":      ex.ParsedTag,
-		"hash":     m.Digest.String(),
-		"serial":   serial,
-		"platform": pltStr,
+		"image":       ex.ParsedName,
+		"tag":         ex.ParsedTag,
+		"hash":        m.Digest.String(),
+		"serial":      serial,
+		"platform":    pltStr,
+		"compression": compression,
+		"chunking":    chunking,
 	}).Debug("saved ToC")
 
 	return nil
 }
 
+// layerPolicy returns the compression and the chunking policy recorded by the convertor in the annotations
+// of the layers, it returns "unknown" if the image was converted before the policy was recorded.
+// Layers converted with different policies are reported as "mixed".
+func (ex *Extractor) layerPolicy(img v1.Image) (compression, chunking string) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return "unknown", "unknown"
+	}
+	merge := func(cur, next string) string {
+		if cur == "" || cur == next {
+			return next
+		}
+		return "mixed"
+	}
+	for _, l := range manifest.Layers {
+		c, has := l.Annotations[util.StarlightCompressionAnnotation]
+		if !has {
+			c = "unknown"
+		}
+		compression = merge(compression, c)
+
+		p, ok, err := util.ChunkingPolicyFromAnnotations(l.Annotations)
+		switch {
+		case err != nil || !ok:
+			chunking = merge(chunking, "unknown")
+		default:
+			chunking = merge(chunking, p.String())
+		}
+	}
+	return compression, chunking
+}
+
 // SaveToC save ToC to the backend database and return ApiResponse if success.
 // It does require the container registry is functioning correctly.
 func (ex *Extractor) SaveToC() (res *ApiResponse, err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			saveToCFailures.Inc()
+		} else {
+			saveToCDuration.Observe(time.Since(start).Seconds())
+		}
+	}()
 
 	// Manifest and Config
 	desc, err := remote.Get(ex.ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
@@ -225,16 +302,21 @@ func (ex *Extractor) SaveToC() (res *ApiResponse, err error) {
 		return nil, errors.Wrapf(err, "failed to cache ToC")
 	}
 
-	if desc.MediaType == types.DockerManifestSchema2 {
+	if !desc.MediaType.IsImage() && !desc.MediaType.IsIndex() {
+		return nil, fmt.Errorf("unsupported media type %s", desc.MediaType)
+	}
+
+	if desc.MediaType.IsImage() {
 		// single manifest image
 		// "application/vnd.docker.distribution.manifest.v2+json"
+		// "application/vnd.oci.image.manifest.v1+json"
 
 		img, err := desc.Image()
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to get single image")
 		}
 
-		err = ex.saveToCPerImage(img, desc.Descriptor)
+		err = ex.saveToCPerImage(img, desc.Descriptor, nil)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to cache ToC")
 		}
@@ -242,6 +324,7 @@ func (ex *Extractor) SaveToC() (res *ApiResponse, err error) {
 	} else {
 		// image index
 		// "application/vnd.docker.distribution.manifest.list.v2+json"
+		// "application/vnd.oci.image.index.v1+json"
 
 		// Container image index
 		imgIdx, err := desc.ImageIndex()
@@ -254,13 +337,28 @@ func (ex *Extractor) SaveToC() (res *ApiResponse, err error) {
 			return nil, errors.Wrapf(err, "failed to get index manifest")
 		}
 
+		idxSignatures, err := ex.indexSignatures(desc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to cache ToC")
+		}
+
 		for _, m := range idxMan.Manifests {
+			if !m.MediaType.IsImage() {
+				log.G(ex.server.ctx).WithFields(logrus.Fields{
+					"image":     ex.ParsedName,
+					"tag":       ex.ParsedTag,
+					"hash":      m.Digest.String(),
+					"mediaType": m.MediaType,
+				}).Debug("skipped non-image manifest")
+				continue
+			}
+
 			img, err := imgIdx.Image(m.Digest)
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to get image")
 			}
 
-			err = ex.saveToCPerImage(img, m)
+			err = ex.saveToCPerImage(img, m, idxSignatures)
 			if err != nil {
 				return nil, errors.Wrapf(err, "failed to cache ToC")
 			}
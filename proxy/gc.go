@@ -0,0 +1,202 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/sirupsen/logrus"
+)
+
+// RetentionPolicy decides which images the garbage collector keeps in the metadata database.
+// The filesystems (and their files) that are no longer referenced by any image are always removed.
+type RetentionPolicy struct {
+	// KeepTags is the number of the most recently updated tags kept in each repository,
+	// the platforms of a tag count as one tag. 0 keeps all the tags.
+	KeepTags int
+	// UntaggedMaxAge is how long an image without any tag is kept after it has been cached.
+	// 0 keeps the untagged images.
+	UntaggedMaxAge time.Duration
+}
+
+type GCTag struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	Platform string `json:"platform"`
+	Image    int64  `json:"image"`
+}
+
+type GCImage struct {
+	Serial int64  `json:"serial"`
+	Image  string `json:"image"`
+	Hash   string `json:"hash"`
+}
+
+type GCFilesystem struct {
+	Serial int64  `json:"serial"`
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// GCReport lists the metadata removed by the garbage collector, or the metadata it would remove in a dry run
+type GCReport struct {
+	DryRun      bool            `json:"dryRun"`
+	Tags        []*GCTag        `json:"tags"`
+	Images      []*GCImage      `json:"images"`
+	Filesystems []*GCFilesystem `json:"filesystems"`
+	// Files is the number of files in the removed filesystems
+	Files int64 `json:"files"`
+}
+
+func newGCReport(dryRun bool) *GCReport {
+	return &GCReport{
+		DryRun:      dryRun,
+		Tags:        make([]*GCTag, 0),
+		Images:      make([]*GCImage, 0),
+		Filesystems: make([]*GCFilesystem, 0),
+	}
+}
+
+func (r *GCReport) empty() bool {
+	return len(r.Tags) == 0 && len(r.Images) == 0 && len(r.Filesystems) == 0
+}
+
+// merge appends the metadata removed in o to the report
+func (r *GCReport) merge(o *GCReport) {
+	r.Tags = append(r.Tags, o.Tags...)
+	r.Images = append(r.Images, o.Images...)
+	r.Filesystems = append(r.Filesystems, o.Filesystems...)
+	r.Files += o.Files
+}
+
+// retentionPolicy is the retention policy in the configuration
+func (a *Server) retentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		KeepTags:       a.config.GCKeepTags,
+		UntaggedMaxAge: time.Duration(a.config.GCUntaggedMaxAge) * time.Second,
+	}
+}
+
+func (a *Server) collectGarbage(policy RetentionPolicy, dryRun bool) (*GCReport, error) {
+	start := time.Now()
+	report, err := a.db.CollectGarbage(policy, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		gcRemovedCounter.WithLabelValues("tag").Add(float64(len(report.Tags)))
+		gcRemovedCounter.WithLabelValues("image").Add(float64(len(report.Images)))
+		gcRemovedCounter.WithLabelValues("filesystem").Add(float64(len(report.Filesystems)))
+		gcRemovedCounter.WithLabelValues("file").Add(float64(report.Files))
+	}
+	entry := log.G(a.ctx).WithFields(logrus.Fields{
+		"dryRun":      dryRun,
+		"tags":        len(report.Tags),
+		"images":      len(report.Images),
+		"filesystems": len(report.Filesystems),
+		"files":       report.Files,
+		"duration":    time.Since(start),
+	})
+	if report.empty() {
+		entry.Debug("garbage collection")
+	} else {
+		entry.Info("garbage collection")
+	}
+	return report, nil
+}
+
+// gcSweeper runs the garbage collector periodically with the retention policy in the configuration
+func (a *Server) gcSweeper(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-t.C:
+			if _, err := a.collectGarbage(a.retentionPolicy(), false); err != nil {
+				log.G(a.ctx).WithError(err).Error("garbage collection failed")
+			}
+		}
+	}
+}
+
+// gc runs the garbage collector. GET (or dry_run=true) returns the report without removing anything.
+// keep_tags and untagged_max_age (e.g. "72h") override the retention policy in the configuration.
+func (a *Server) gc(w http.ResponseWriter, req *http.Request) {
+	ip := a.getIpAddress(req)
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "gc", "ip": ip}).Info("request received")
+
+	if _, ok := a.authorize(w, req, nil); !ok {
+		return
+	}
+
+	var dryRun bool
+	switch req.Method {
+	case http.MethodGet:
+		dryRun = true
+	case http.MethodPost:
+		dryRun = q.Get("dry_run") == "true"
+	default:
+		a.respond(w, req, &ApiResponse{
+			Status: "Method Not Allowed",
+			Code:   http.StatusMethodNotAllowed,
+			Error:  "use GET for a dry run or POST to collect garbage",
+		})
+		return
+	}
+
+	policy := a.retentionPolicy()
+	if v := q.Get("keep_tags"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			a.error(w, req, "invalid keep_tags")
+			return
+		}
+		policy.KeepTags = n
+	}
+	if v := q.Get("untagged_max_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			a.error(w, req, "invalid untagged_max_age")
+			return
+		}
+		policy.UntaggedMaxAge = d
+	}
+
+	report, err := a.collectGarbage(policy, dryRun)
+	if err != nil {
+		log.G(a.ctx).WithError(err).Error("garbage collection failed")
+		a.respond(w, req, &ApiResponse{
+			Status: "Internal Server Error",
+			Code:   http.StatusInternalServerError,
+			Error:  err.Error(),
+		})
+		return
+	}
+
+	a.respond(w, req, &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		GC:      report,
+	})
+}
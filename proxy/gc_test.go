@@ -0,0 +1,236 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mc256/starlight/util/common"
+)
+
+func TestBoltDatabase_CollectGarbage(t *testing.T) {
+	d, err := NewDatabase(context.Background(), BoltConnectionPrefix+filepath.Join(t.TempDir(), "proxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err = d.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	testDatabaseGC(t, d, false)
+}
+
+func TestPostgresDatabase_CollectGarbage(t *testing.T) {
+	d, err := NewDatabase(context.Background(), cfg.PostgresConnectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err = d.InitDatabase(); err != nil {
+		t.Skipf("PostgreSQL is not available: %v", err)
+	}
+	// the database is shared, do not remove anything that belongs to the other tests
+	testDatabaseGC(t, d, true)
+}
+
+// testDatabaseGC populates a repository with 3 tags and an untagged image and collects the garbage keeping
+// 2 tags. If dryRunOnly is false, the database must be empty before the test.
+func testDatabaseGC(t *testing.T, d Database, dryRunOnly bool) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	digest := func(s string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(s+suffix)))
+	}
+	name := "gc/app-" + suffix
+	files := func(n string) func() (map[string]*common.TraceableEntry, error) {
+		return func() (map[string]*common.TraceableEntry, error) {
+			return map[string]*common.TraceableEntry{
+				n: {TOCEntry: &common.TOCEntry{Name: n, Type: "reg", Size: 1, Digest: digest(n)}},
+			}, nil
+		}
+	}
+
+	images := make(map[string]int64)
+	for _, img := range []struct {
+		name   string
+		layers []string
+		tags   []string
+	}{
+		{"v1", []string{"base", "v1"}, []string{"linux/amd64"}},
+		{"v2", []string{"base", "v2"}, []string{"linux/amd64"}},
+		{"v3", []string{"base", "v3"}, []string{"linux/amd64", "linux/arm64"}},
+		{"untagged", []string{"untagged"}, nil},
+	} {
+		serial, _, err := d.InsertImage(name, digest(img.name), []byte(`{}`), []byte(`{}`), int64(len(img.layers)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, l := range img.layers {
+			if _, _, err = d.InsertLayer(1, serial, int64(i), digest(l), files(l)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, p := range img.tags {
+			if err = d.SetImageTag(name, img.name, p, serial); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = d.SetImageReady(true, serial); err != nil {
+			t.Fatal(err)
+		}
+		images[img.name] = serial
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	policy := RetentionPolicy{KeepTags: 2, UntaggedMaxAge: time.Millisecond}
+	check := func(report *GCReport) {
+		var tags, imgs, fss []string
+		for _, v := range report.Tags {
+			if v.Name == name {
+				tags = append(tags, fmt.Sprintf("%s/%s/%d", v.Tag, v.Platform, v.Image))
+			}
+		}
+		for _, v := range report.Images {
+			if v.Image == name {
+				imgs = append(imgs, v.Hash)
+			}
+		}
+		for _, v := range report.Filesystems {
+			if v.Digest == digest("v1") || v.Digest == digest("untagged") || v.Digest == digest("base") {
+				fss = append(fss, v.Digest)
+			}
+		}
+		sort.Strings(imgs)
+		sort.Strings(fss)
+
+		expectedImages := []string{digest("untagged"), digest("v1")}
+		sort.Strings(expectedImages)
+		expectedFilesystems := []string{digest("untagged"), digest("v1")}
+		sort.Strings(expectedFilesystems)
+		if fmt.Sprint(tags) != fmt.Sprintf("[v1/linux/amd64/%d]", images["v1"]) {
+			t.Errorf("expected tag v1 to be removed but got %v", tags)
+		}
+		if fmt.Sprint(imgs) != fmt.Sprint(expectedImages) {
+			t.Errorf("expected images %v to be removed but got %v", expectedImages, imgs)
+		}
+		if fmt.Sprint(fss) != fmt.Sprint(expectedFilesystems) {
+			t.Errorf("expected filesystems %v to be removed but got %v", expectedFilesystems, fss)
+		}
+	}
+
+	report, err := d.CollectGarbage(policy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun {
+		t.Error("expected a dry run report")
+	}
+	check(report)
+	if s, err := d.GetImage(name, "v1", "linux/amd64"); err != nil || s != images["v1"] {
+		t.Errorf("dry run should not remove tag v1: %d (%v)", s, err)
+	}
+	if l, err := d.GetLayersByDigests([]string{digest("v1")}); err != nil || len(l) != 1 {
+		t.Errorf("dry run should not remove the filesystem: %v (%v)", l, err)
+	}
+	if dryRunOnly {
+		return
+	}
+
+	if report, err = d.CollectGarbage(policy, false); err != nil {
+		t.Fatal(err)
+	}
+	check(report)
+	if report.Files != 2 {
+		t.Errorf("expected 2 files to be removed but got %d", report.Files)
+	}
+
+	if _, err = d.GetImage(name, "v1", "linux/amd64"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the removed tag but got %v", err)
+	}
+	if _, err = d.GetImageByDigest(name, digest("v1")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for the removed image but got %v", err)
+	}
+	if l, err := d.GetLayers(images["v1"]); err != nil || len(l) != 0 {
+		t.Errorf("expected no layers of the removed image but got %v (%v)", l, err)
+	}
+	for _, tag := range []string{"v2", "v3"} {
+		if s, err := d.GetImage(name, tag, "linux/amd64"); err != nil || s != images[tag] {
+			t.Errorf("expected tag %s to be kept: %d (%v)", tag, s, err)
+		}
+	}
+	if l, err := d.GetLayers(images["v2"]); err != nil || len(l) != 2 {
+		t.Errorf("expected the layers of v2 to be kept but got %v (%v)", l, err)
+	}
+	if l, err := d.GetLayersByDigests([]string{digest("base"), digest("v1"), digest("untagged")}); err != nil ||
+		len(l) != 1 || l[0].Hash != digest("base") {
+		t.Errorf("expected only the shared filesystem to be kept but got %v (%v)", l, err)
+	}
+
+	// the same filesystem could be indexed again
+	serial, _, err := d.InsertImage(name, digest("v1"), []byte(`{}`), []byte(`{}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, existing, err := d.InsertLayer(1, serial, 0, digest("v1"), files("v1")); err != nil || existing {
+		t.Errorf("expected the filesystem to be inserted again: %v (%v)", existing, err)
+	}
+
+	policy.UntaggedMaxAge = time.Hour
+	if report, err = d.CollectGarbage(policy, false); err != nil || len(report.Tags) != 0 || len(report.Filesystems) != 0 {
+		t.Errorf("expected nothing else to be removed but got %+v (%v)", report, err)
+	}
+}
+
+func TestServer_GC(t *testing.T) {
+	a, d := newTestServer(t)
+	insertTaggedImage(t, d, "gc/app", "sha256:untagged", nil)
+	time.Sleep(10 * time.Millisecond)
+
+	request := func(method, query string) (int, *ApiResponse) {
+		return serve(t, a.gc, method, "/starlight/admin/gc?"+query, nil)
+	}
+
+	// the configuration keeps everything
+	if code, res := request(http.MethodGet, ""); code != http.StatusOK || !res.GC.DryRun || len(res.GC.Images) != 0 {
+		t.Errorf("expected an empty dry run report but got %d %+v", code, res.GC)
+	}
+	if code, res := request(http.MethodGet, "untagged_max_age=1ms"); code != http.StatusOK || len(res.GC.Images) != 1 {
+		t.Errorf("expected the untagged image in the report but got %d %+v", code, res.GC)
+	}
+	if code, _ := request(http.MethodGet, "keep_tags=-1"); code != http.StatusBadRequest {
+		t.Errorf("expected bad request but got %d", code)
+	}
+	if code, _ := request(http.MethodDelete, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed but got %d", code)
+	}
+	if code, res := request(http.MethodPost, "untagged_max_age=1ms"); code != http.StatusOK || res.GC.DryRun ||
+		len(res.GC.Images) != 1 {
+		t.Errorf("expected the untagged image to be removed but got %d %+v", code, res.GC)
+	}
+	if _, err := d.GetImageByDigest("gc/app", "sha256:untagged"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the image to be removed but got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "starlight_proxy"
+
+var (
+	requestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "requests_total",
+		Help:      "Number of HTTP requests by endpoint and status code.",
+	}, []string{"endpoint", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time to serve the HTTP requests by endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 10),
+	}, []string{"endpoint"})
+
+	computeDeltaDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "compute_delta_duration_seconds",
+		Help:      "Time to compute the delta between the requested and the available images.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	deltaBodyBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "delta_body_bytes",
+		Help:      "Size of the delta image body (compressed file contents).",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 12),
+	})
+
+	contentBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "content_bytes_total",
+		Help:      "Bytes of compressed contents sent to the clients that fetch files out of order.",
+	})
+
+	layerFetchCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "layer_fetch_total",
+		Help:      "Number of layers fetched by the delta image builder, shared is true if the layer cache was hit.",
+	}, []string{"shared"})
+
+	deltaPlanCacheCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "delta_plan_cache_total",
+		Help:      "Number of delta image requests by the result of the delta plan cache lookup (hit or miss).",
+	}, []string{"result"})
+
+	saveToCDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "save_toc_duration_seconds",
+		Help:      "Time to extract and save the ToC of an image.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	})
+
+	saveToCFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "save_toc_failures_total",
+		Help:      "Number of images that failed to have their ToC saved.",
+	})
+
+	gcRemovedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "gc_removed_total",
+		Help:      "Number of tags, images, filesystems and files removed by the garbage collector.",
+	}, []string{"kind"})
+
+	databaseQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "database_query_duration_seconds",
+		Help:      "Latency of the database queries.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"query"})
+)
+
+// observeQuery measures the latency of a database query, use it as `defer observeQuery("name")()`
+func observeQuery(query string) func() {
+	start := time.Now()
+	return func() {
+		databaseQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusRecorder keeps the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument counts the requests and measures the response time of the endpoint
+func (a *Server) instrument(endpoint string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		handler(rec, req)
+		requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		requestCounter.WithLabelValues(endpoint, strconv.Itoa(rec.code)).Inc()
+	}
+}
+
+// metricsHandler creates a registry with the proxy metrics and the layer cache statistics
+func (a *Server) metricsHandler() http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requestCounter, requestDuration,
+		computeDeltaDuration, deltaBodyBytes, contentBytes, layerFetchCounter, deltaPlanCacheCounter,
+		saveToCDuration, saveToCFailures, gcRemovedCounter,
+		databaseQueryDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "layer_cache_bytes",
+			Help:      "Total size of the cached layers.",
+		}, func() float64 {
+			return float64(a.cache.Size())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "layer_cache_evictions_total",
+			Help:      "Number of layers evicted from the layer cache.",
+		}, func() float64 {
+			return float64(a.cache.Evictions())
+		}),
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// metrics serves the metrics, only the users allowed to access the endpoint could read them unless public is set
+func (a *Server) metrics(public bool) http.HandlerFunc {
+	handler := a.metricsHandler()
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !public {
+			if _, ok := a.authorize(w, req, nil); !ok {
+				return
+			}
+		}
+		handler.ServeHTTP(w, req)
+	}
+}
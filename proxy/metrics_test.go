@@ -0,0 +1,81 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mc256/starlight/util/common"
+)
+
+func TestServer_Metrics(t *testing.T) {
+	a := &Server{
+		config: NewConfig(),
+		cache:  common.NewLayerCachePool(common.NewMemoryCacheBackend(), 0),
+	}
+	h := a.instrument("/test", func(w http.ResponseWriter, req *http.Request) {
+		a.error(w, req, "bad request")
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest("GET", "/test", nil))
+
+	w := httptest.NewRecorder()
+	a.metricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	for _, m := range []string{
+		`starlight_proxy_requests_total{code="400",endpoint="/test"} 1`,
+		`starlight_proxy_layer_cache_bytes 0`,
+		`starlight_proxy_layer_cache_evictions_total 0`,
+	} {
+		if !strings.Contains(string(body), m) {
+			t.Errorf("metric %q not found", m)
+		}
+	}
+}
+
+func TestServer_MetricsAuth(t *testing.T) {
+	auth, err := NewAuthorizer(&AuthConfiguration{
+		Rules: []*AuthRule{
+			{Users: []string{AnonymousUser}, Endpoints: []string{"/starlight/delta"}, Repositories: []string{AnyUser}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Server{
+		ctx:    context.Background(),
+		config: NewConfig(),
+		cache:  common.NewLayerCachePool(common.NewMemoryCacheBackend(), 0),
+		auth:   auth,
+	}
+
+	w := httptest.NewRecorder()
+	a.metrics(false)(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthorized but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	a.metrics(true)(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected public metrics but got %d", w.Code)
+	}
+}
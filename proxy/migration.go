@@ -0,0 +1,64 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// Migration is a forward-only change of the database schema. Versions start from 1 and increase by one,
+// the migrations are applied in the order of their versions and recorded in the schema_version table.
+type Migration struct {
+	Version     int        `json:"version"`
+	Description string     `json:"description"`
+	Applied     *time.Time `json:"applied,omitempty"`
+}
+
+// newMigrations returns the status of the migrations of a backend given the versions that have been applied.
+// It fails if the database has been migrated by a newer proxy.
+func newMigrations(defined []Migration, applied map[int]time.Time) ([]*Migration, error) {
+	r := make([]*Migration, 0, len(defined))
+	for i, m := range defined {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d (%s) is out of order", m.Version, m.Description)
+		}
+		m := m
+		if ts, ok := applied[m.Version]; ok {
+			m.Applied = &ts
+		}
+		r = append(r, &m)
+	}
+	for v := range applied {
+		if v > len(defined) {
+			return nil, fmt.Errorf("database schema version %d is newer than the latest known version %d",
+				v, len(defined))
+		}
+	}
+	return r, nil
+}
+
+// pendingMigrations returns the migrations that have not been applied
+func pendingMigrations(ms []*Migration) []*Migration {
+	r := make([]*Migration, 0)
+	for _, m := range ms {
+		if m.Applied == nil {
+			r = append(r, m)
+		}
+	}
+	return r
+}
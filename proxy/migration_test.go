@@ -0,0 +1,83 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func hasBucket(d *BoltDatabase, name []byte) (has bool) {
+	_ = d.db.View(func(tx *bolt.Tx) error {
+		has = tx.Bucket(name) != nil
+		return nil
+	})
+	return has
+}
+
+func TestBoltDatabase_Migrate(t *testing.T) {
+	d, err := NewBoltDatabase(filepath.Join(t.TempDir(), "proxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	ms, err := d.Migrations()
+	if err != nil || len(ms) != len(boltMigrations) {
+		t.Fatalf("expected %d migrations but got %v (%v)", len(boltMigrations), ms, err)
+	}
+	for _, m := range ms {
+		if m.Applied != nil {
+			t.Errorf("migration %d should be pending", m.Version)
+		}
+	}
+
+	// dry run does not touch the database
+	pending, err := d.Migrate(true)
+	if err != nil || len(pending) != len(boltMigrations) {
+		t.Fatalf("expected %d pending migrations but got %v (%v)", len(boltMigrations), pending, err)
+	}
+	if hasBucket(d, bucketImage) {
+		t.Error("dry run should not create the buckets")
+	}
+
+	applied, err := d.Migrate(false)
+	if err != nil || len(applied) != len(boltMigrations) || applied[0].Applied == nil {
+		t.Fatalf("expected all the migrations to be applied but got %v (%v)", applied, err)
+	}
+	if applied, err = d.Migrate(false); err != nil || len(applied) != 0 {
+		t.Errorf("expected no migration but got %v (%v)", applied, err)
+	}
+	if !hasBucket(d, bucketImage) {
+		t.Error("expected the buckets to be created")
+	}
+
+	// a newer proxy has migrated the database
+	if err = d.db.Update(func(tx *bolt.Tx) error {
+		ts := time.Now()
+		return boltPut(tx.Bucket(bucketSchemaVersion), itob(int64(len(boltMigrations)+1)),
+			&Migration{Version: len(boltMigrations) + 1, Applied: &ts})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Migrate(false); err == nil {
+		t.Error("expected an error for a newer schema")
+	}
+}
@@ -20,9 +20,12 @@ package proxy
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -31,6 +34,8 @@ import (
 	"github.com/mc256/starlight/client/fs"
 	"github.com/mc256/starlight/util"
 	"github.com/mc256/starlight/util/common"
+	"github.com/mc256/starlight/util/send"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,6 +44,18 @@ type transition struct {
 	to   name.Reference
 }
 
+// maxDeltaRequestSize limits the body of a delta image request
+const maxDeltaRequestSize = 4 << 20
+
+// DeltaRequest is the body of a POST delta image request. The layers on a client could be too many for
+// the query string, so the client sends them in the body instead.
+type DeltaRequest struct {
+	// Available are the other images (references with digest) on the client
+	Available []string `json:"available,omitempty"`
+	// Layers are the digests of the compressed layers on the client
+	Layers []string `json:"layers,omitempty"`
+}
+
 type ApiResponse struct {
 	Status string `json:"status"`
 	Code   int    `json:"code"`
@@ -49,17 +66,37 @@ type ApiResponse struct {
 
 	// Responses Information
 	Extractor *Extractor `json:"extractor,omitempty"`
+	Layers    []string   `json:"layers,omitempty"`
+	GC        *GCReport  `json:"gc,omitempty"`
+
+	// Admin API
+	Repositories []*RepositoryInfo `json:"repositories,omitempty"`
+	Tags         []*TagInfo        `json:"tags,omitempty"`
+	Image        *ImageInfo        `json:"image,omitempty"`
+
+	Deleted *GCReport `json:"deleted,omitempty"`
 }
 
 type Server struct {
 	http.Server
 	ctx context.Context
 
-	db     *Database
+	db     Database
 	config *Configuration
 
-	cache      map[string]*common.LayerCache
-	cacheMutex sync.Mutex
+	cache *common.LayerCachePool
+
+	// plans caches the computed delta images
+	plans *DeltaPlanCache
+
+	// auth is nil if authentication is disabled
+	auth *Authorizer
+
+	// signatures is nil if the image signatures are not verified
+	signatures *common.SignatureVerifier
+
+	// reconciler checks the tags of the repositories with deleted image indexes against the registry
+	reconciler tagReconciler
 }
 
 func (a *Server) getIpAddress(req *http.Request) string {
@@ -71,15 +108,99 @@ func (a *Server) getIpAddress(req *http.Request) string {
 }
 
 func (a *Server) cacheTimeoutValidator() {
-	for k, v := range a.cache {
-		v.Mutex.Lock()
-		// Delete Expired Cache
-		if v.UseCounter <= 0 && time.Now().Add(time.Duration(a.config.CacheTimeout)*time.Second).Before(v.LastUsed) {
-			delete(a.cache, k)
+	// Delete Expired Cache
+	a.cache.EvictExpired(a.ctx, time.Duration(a.config.CacheTimeout)*time.Second)
+	time.Sleep(time.Second)
+}
+
+// repositories converts image references to the repository names used in the authorization rules
+func (a *Server) repositories(refs ...string) []string {
+	res := make([]string, 0, len(refs))
+	for _, r := range refs {
+		if r == "" {
+			continue
+		}
+		ref, err := name.ParseReference(r, name.WithDefaultRegistry(a.config.DefaultRegistry))
+		if err != nil {
+			res = append(res, r)
+			continue
 		}
-		v.Mutex.Unlock()
+		n, _ := ParseImageReference(ref, a.config.DefaultRegistry, a.config.DefaultRegistryAlias)
+		res = append(res, n)
 	}
-	time.Sleep(time.Second)
+	return res
+}
+
+// splitQueryValues splits the comma separated values of a query parameter, empty values are removed
+func splitQueryValues(values []string) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, vv := range strings.Split(v, ",") {
+			if vv = strings.TrimSpace(vv); vv != "" {
+				res = append(res, vv)
+			}
+		}
+	}
+	return res
+}
+
+// authorize checks the credentials of the request and whether the user could access the endpoint and
+// the repositories. If the request is denied, it responds with 401 or 403 and returns false.
+func (a *Server) authorize(w http.ResponseWriter, req *http.Request, repositories []string) (user string, ok bool) {
+	if a.auth == nil {
+		return AnonymousUser, true
+	}
+
+	user, err := a.auth.Authenticate(req)
+	if err != nil {
+		log.G(a.ctx).
+			WithFields(logrus.Fields{"ip": a.getIpAddress(req), "path": req.URL.Path}).
+			WithError(err).
+			Warn("authentication failed")
+		for _, c := range a.auth.Challenges() {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		a.respond(w, req, &ApiResponse{
+			Status: "Unauthorized",
+			Code:   http.StatusUnauthorized,
+			Error:  ErrInvalidCredentials.Error(),
+		})
+		return user, false
+	}
+	return user, a.permit(w, req, user, repositories)
+}
+
+// permit checks whether the authenticated user could access the repositories through the endpoint.
+// If the request is denied, it responds with 401 or 403 and returns false.
+func (a *Server) permit(w http.ResponseWriter, req *http.Request, user string, repositories []string) bool {
+	if a.auth == nil {
+		return true
+	}
+
+	if !a.auth.Allowed(user, req.URL.Path, repositories) {
+		log.G(a.ctx).
+			WithFields(logrus.Fields{"ip": a.getIpAddress(req), "path": req.URL.Path, "user": user}).
+			WithField("repositories", repositories).
+			Warn("access denied")
+		if user == AnonymousUser {
+			for _, c := range a.auth.Challenges() {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+			a.respond(w, req, &ApiResponse{
+				Status: "Unauthorized",
+				Code:   http.StatusUnauthorized,
+				Error:  "authentication required",
+			})
+			return false
+		}
+		a.respond(w, req, &ApiResponse{
+			Status: "Forbidden",
+			Code:   http.StatusForbidden,
+			Error:  "access denied",
+		})
+		return false
+	}
+	return true
 }
 
 func (a *Server) home(w http.ResponseWriter, req *http.Request) {
@@ -101,6 +222,9 @@ func (a *Server) home(w http.ResponseWriter, req *http.Request) {
 
 func (a *Server) scanner(w http.ResponseWriter, req *http.Request) {
 	log.G(a.ctx).WithFields(logrus.Fields{"ip": a.getIpAddress(req)}).Info("harbor scanner")
+	if _, ok := a.authorize(w, req, nil); !ok {
+		return
+	}
 
 	// TODO: implement api hooks
 	header := w.Header()
@@ -128,6 +252,24 @@ func (a *Server) delta(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// other images (references with digest) and layers (digests of the compressed layers) on the client,
+	// either as repeated parameters or separated by commas, or in the body of a POST request
+	available, layers := splitQueryValues(q["available"]), splitQueryValues(q["layers"])
+	if req.Method == http.MethodPost {
+		var body DeltaRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxDeltaRequestSize)).Decode(&body)
+		if err != nil && err != io.EOF {
+			a.error(w, req, fmt.Sprintf("cannot parse request body: %v", err))
+			return
+		}
+		available = append(available, body.Available...)
+		layers = append(layers, body.Layers...)
+	}
+
+	if _, ok := a.authorize(w, req, a.repositories(append([]string{f, t}, available...)...)); !ok {
+		return
+	}
+
 	// disable sorting
 	nonsrt := false
 	if srt == "true" {
@@ -139,6 +281,14 @@ func (a *Server) delta(w http.ResponseWriter, req *http.Request) {
 		a.error(w, req, err.Error())
 		return
 	}
+	defer b.Release()
+
+	if len(available) > 0 || len(layers) > 0 {
+		if err = b.SetAvailable(available, layers); err != nil {
+			a.error(w, req, err.Error())
+			return
+		}
+	}
 
 	if err = b.Load(); err != nil {
 		a.error(w, req, err.Error())
@@ -159,6 +309,156 @@ func (a *Server) delta(w http.ResponseWriter, req *http.Request) {
 	log.G(a.ctx).WithFields(logrus.Fields{"action": "delta", "ip": ip}).Debug("response sent")
 }
 
+// layers responds the digests of the compressed layers of the image, the client uses them to choose
+// the local image that shares the most layers with the requested image as the base image
+func (a *Server) layers(w http.ResponseWriter, req *http.Request) {
+	ip := a.getIpAddress(req)
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "layers", "ip": ip}).Info("request received")
+
+	ref, plt := q.Get("ref"), q.Get("platform")
+	if ref == "" || plt == "" {
+		a.error(w, req, "missing parameters")
+		return
+	}
+
+	if _, ok := a.authorize(w, req, a.repositories(ref)); !ok {
+		return
+	}
+
+	_, layers, err := a.imageLayers(ref, plt)
+	if err != nil {
+		a.error(w, req, err.Error())
+		return
+	}
+
+	res := &ApiResponse{
+		Status:  "OK",
+		Code:    http.StatusOK,
+		Message: "Starlight Proxy",
+		Layers:  make([]string, 0, len(layers)),
+	}
+	for _, l := range layers {
+		res.Layers = append(res.Layers, l.Hash)
+	}
+	a.respond(w, req, res)
+}
+
+// imageSerial resolves the image reference (tag or digest) of the platform
+func (a *Server) imageSerial(ref, plt string) (name.Reference, int64, error) {
+	r, err := name.ParseReference(ref,
+		name.WithDefaultRegistry(a.config.DefaultRegistry),
+		name.WithDefaultTag("latest-starlight"),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	refName, refTag := ParseImageReference(r, a.config.DefaultRegistry, a.config.DefaultRegistryAlias)
+	serial, err := a.db.GetImage(refName, refTag, plt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("requested image %s not found", ref)
+		}
+		return nil, 0, err
+	}
+	return r, serial, nil
+}
+
+// imageLayers returns the layers of the image from bottom to top
+func (a *Server) imageLayers(ref, plt string) (name.Reference, []*send.ImageLayer, error) {
+	r, serial, err := a.imageSerial(ref, plt)
+	if err != nil {
+		return nil, nil, err
+	}
+	layers, err := a.db.GetLayers(serial)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, layers, nil
+}
+
+// content responds the requested byte ranges of a compressed layer of the image in the order of the request.
+// The client uses it to fetch the chunks of a file that is accessed before the delta image reaches it.
+func (a *Server) content(w http.ResponseWriter, req *http.Request) {
+	ip := a.getIpAddress(req)
+	q := req.URL.Query()
+	log.G(a.ctx).WithFields(logrus.Fields{"action": "content", "ip": ip}).Debug("request received")
+
+	ref, plt, hash := q.Get("ref"), q.Get("platform"), q.Get("layer")
+	if ref == "" || plt == "" || hash == "" {
+		a.error(w, req, "missing parameters")
+		return
+	}
+	ranges, err := common.ParseByteRanges(q.Get("ranges"))
+	if err != nil {
+		a.error(w, req, err.Error())
+		return
+	}
+	if len(ranges) == 0 {
+		a.error(w, req, "missing parameters")
+		return
+	}
+
+	if _, ok := a.authorize(w, req, a.repositories(ref)); !ok {
+		return
+	}
+
+	r, layers, err := a.imageLayers(ref, plt)
+	if err != nil {
+		a.error(w, req, err.Error())
+		return
+	}
+	var layer *send.ImageLayer
+	for _, l := range layers {
+		if l.Hash == hash {
+			layer = l
+			break
+		}
+	}
+	if layer == nil {
+		a.error(w, req, fmt.Sprintf("layer %s not found in image %s", hash, ref))
+		return
+	}
+
+	var total int64
+	for _, rg := range ranges {
+		if rg.End() > layer.UncompressedSize {
+			a.error(w, req, fmt.Sprintf("byte range %s is out of the layer", rg))
+			return
+		}
+		total += rg.Length
+	}
+
+	d, err := name.NewDigest(fmt.Sprintf("%s@%s", r.Context().Name(), layer.Hash))
+	if err != nil {
+		a.error(w, req, err.Error())
+		return
+	}
+	layer.SetDigest(d)
+
+	c, _, err := a.cache.FetchRanges(a.ctx, layer, common.CoalesceRanges(ranges, rangeCoalesceGap))
+	if err != nil {
+		a.error(w, req, errors.Wrapf(err, "failed to load layer").Error())
+		return
+	}
+	defer a.cache.Release(c)
+
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Length", fmt.Sprintf("%d", total))
+	w.WriteHeader(http.StatusOK)
+	for _, rg := range ranges {
+		if _, err = io.Copy(w, io.NewSectionReader(c.Buffer, rg.Offset, rg.Length)); err != nil {
+			log.G(a.ctx).
+				WithField("layer", layer.Hash).
+				WithField("range", rg.String()).
+				Error(errors.Wrapf(err, "failed to send content"))
+			return
+		}
+	}
+	contentBytes.Add(float64(total))
+}
+
 func (a *Server) notify(w http.ResponseWriter, req *http.Request) {
 	ip := a.getIpAddress(req)
 	q := req.URL.Query()
@@ -170,6 +470,10 @@ func (a *Server) notify(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if _, ok := a.authorize(w, req, a.repositories(i)); !ok {
+		return
+	}
+
 	insecure := false
 	if q.Get("insecure") != "" {
 		insecure = true
@@ -197,6 +501,11 @@ func (a *Server) report(w http.ResponseWriter, req *http.Request) {
 	ip := a.getIpAddress(req)
 	log.G(a.ctx).WithFields(logrus.Fields{"action": "report", "ip": ip}).Info("request received")
 
+	user, ok := a.authorize(w, req, nil)
+	if !ok {
+		return
+	}
+
 	tc, err := fs.NewTraceCollectionFromBuffer(req.Body)
 	if err != nil {
 		log.G(a.ctx).WithError(err).Info("cannot parse trace collection")
@@ -204,6 +513,25 @@ func (a *Server) report(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// traces refer to the images by digest, look up their repositories for authorization
+	repos := make([]string, 0)
+	if a.auth != nil {
+		for _, g := range tc.Groups {
+			for _, img := range g.Images {
+				names, err := a.db.GetImageNames(img)
+				if err != nil {
+					log.G(a.ctx).WithError(err).Info("cannot find image")
+					a.error(w, req, err.Error())
+					return
+				}
+				repos = append(repos, names...)
+			}
+		}
+	}
+	if !a.permit(w, req, user, repos) {
+		return
+	}
+
 	arr, err := a.db.UpdateFileRanks(tc)
 	if err != nil {
 		log.G(a.ctx).WithError(err).Info("cannot update file ranks")
@@ -211,9 +539,19 @@ func (a *Server) report(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// the ranks of the files in these layers have changed, the delta images need to be sorted again
+	var layers []int64
+	for _, group := range arr {
+		for _, img := range group {
+			layers = append(layers, img...)
+		}
+	}
+	removed := a.plans.InvalidateLayers(layers...)
+
 	log.G(a.ctx).
 		WithField("ip", ip).
 		WithField("layers", arr).
+		WithField("invalidated", removed).
 		Info("received traces")
 
 	a.respond(w, req, &ApiResponse{
@@ -265,12 +603,27 @@ func NewServer(ctx context.Context, wg *sync.WaitGroup, cfg *Configuration) (*Se
 			Addr: fmt.Sprintf("%s:%d", cfg.ListenAddress, cfg.ListenPort),
 		},
 		config: cfg,
-		cache:  make(map[string]*common.LayerCache),
+		plans:  NewDeltaPlanCache(cfg.DeltaCacheSize),
+	}
+
+	// layer cache
+	switch cfg.CacheBackend {
+	case "memory":
+		server.cache = common.NewLayerCachePool(common.NewMemoryCacheBackend(), cfg.CacheSize)
+	case "disk", "":
+		backend, err := common.NewDiskCacheBackend(cfg.CacheDirectory)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize layer cache")
+		}
+		server.cache = common.NewLayerCachePool(backend, cfg.CacheSize)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.CacheBackend)
 	}
 
 	// connect database
 	if db, err := NewDatabase(ctx, cfg.PostgresConnectionString); err != nil {
 		log.G(ctx).Errorf("failed to connect to database: %v\n", err)
+		return nil, err
 	} else {
 		server.db = db
 	}
@@ -295,20 +648,72 @@ func NewServer(ctx context.Context, wg *sync.WaitGroup, cfg *Configuration) (*Se
 	}
 	log.G(ctx).Info("database initialized")
 
+	// TLS
+	if cfg.TLSCertificate != "" {
+		r, err := util.NewTLSReloader(cfg.TLSCertificate, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize TLS")
+		}
+		server.TLSConfig = r.ServerConfig()
+		log.G(ctx).WithField("mtls", cfg.TLSClientCA != "").Info("TLS enabled")
+	} else if cfg.TLSClientCA != "" {
+		return nil, fmt.Errorf("mutual TLS requires the certificate and the key of the proxy")
+	}
+
+	// image signatures
+	signatures, err := common.NewSignatureVerifier(cfg.SignatureKeys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load signature keys")
+	}
+	if server.signatures = signatures; signatures != nil {
+		log.G(ctx).WithField("keys", len(cfg.SignatureKeys)).Info("signature verification enabled")
+	}
+
+	// authentication
+	if cfg.Auth != nil {
+		var extra []Authenticator
+		if cfg.TLSClientCA != "" {
+			extra = append(extra, &CertificateAuthenticator{})
+		}
+		auth, err := NewAuthorizer(cfg.Auth, extra...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to initialize authentication")
+		}
+		server.auth = auth
+		log.G(ctx).Info("authentication enabled")
+	}
+
 	// create router
-	http.HandleFunc("/scanner", server.scanner)
-	http.HandleFunc("/starlight/delta", server.delta)
-	http.HandleFunc("/starlight/notify", server.notify)
-	http.HandleFunc("/starlight/report", server.report)
-	http.HandleFunc("/health-check", server.healthCheck)
-	http.HandleFunc("/", server.home)
+	http.HandleFunc("/scanner", server.instrument("/scanner", server.scanner))
+	http.HandleFunc("/starlight/delta", server.instrument("/starlight/delta", server.delta))
+	http.HandleFunc("/starlight/layers", server.instrument("/starlight/layers", server.layers))
+	http.HandleFunc("/starlight/content", server.instrument("/starlight/content", server.content))
+	http.HandleFunc("/starlight/notify", server.instrument("/starlight/notify", server.notify))
+	http.HandleFunc("/starlight/report", server.instrument("/starlight/report", server.report))
+	http.HandleFunc("/starlight/image", server.instrument("/starlight/image", server.deleteImage))
+	http.HandleFunc("/starlight/registry/events",
+		server.instrument("/starlight/registry/events", server.registryEvents))
+	http.HandleFunc("/starlight/admin/gc", server.instrument("/starlight/admin/gc", server.gc))
+	http.HandleFunc("/starlight/admin/repositories",
+		server.instrument("/starlight/admin/repositories", server.adminRepositories))
+	http.HandleFunc("/starlight/admin/tags", server.instrument("/starlight/admin/tags", server.adminTags))
+	http.HandleFunc("/starlight/admin/image", server.instrument("/starlight/admin/image", server.adminImage))
+	http.HandleFunc("/health-check", server.instrument("/health-check", server.healthCheck))
+	http.HandleFunc("/metrics", server.instrument("/metrics", server.metrics(cfg.MetricsPublic)))
+	http.HandleFunc("/", server.instrument("/", server.home))
 
 	go func() {
 		defer wg.Done()
 		defer server.db.Close()
 
 		log.G(ctx).Infof("listen on %s:%d", cfg.ListenAddress, cfg.ListenPort)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
 			log.G(ctx).WithField("error", err).Error("server exit with error")
 		}
 	}()
@@ -319,5 +724,10 @@ func NewServer(ctx context.Context, wg *sync.WaitGroup, cfg *Configuration) (*Se
 		}
 	}()
 
+	if cfg.GCInterval > 0 {
+		go server.gcSweeper(time.Duration(cfg.GCInterval) * time.Second)
+		log.G(ctx).WithField("interval", cfg.GCInterval).Info("garbage collection enabled")
+	}
+
 	return server, nil
 }
@@ -0,0 +1,82 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mc256/starlight/util/common"
+)
+
+// newTestServer creates a server backed by an empty embedded database
+func newTestServer(t *testing.T) (*Server, *BoltDatabase) {
+	d, err := NewBoltDatabase(filepath.Join(t.TempDir(), "proxy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	if err = d.InitDatabase(); err != nil {
+		t.Fatal(err)
+	}
+	return &Server{ctx: context.Background(), config: NewConfig(), db: d}, d
+}
+
+// insertTaggedImage inserts a ready image with the layers (digests of 10-byte filesystems with one file each)
+// and tags it for linux/amd64 and linux/arm64
+func insertTaggedImage(t *testing.T, d Database, repo, digest string, layers []string, tags ...string) int64 {
+	serial, _, err := d.InsertImage(repo, digest, []byte(`{}`), []byte(`{}`), int64(len(layers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range layers {
+		if _, _, err = d.InsertLayer(10, serial, int64(i), l, func() (map[string]*common.TraceableEntry, error) {
+			return map[string]*common.TraceableEntry{
+				"file": {TOCEntry: &common.TOCEntry{Name: "file", Type: "reg", Size: 10, Digest: l + "-file"}},
+			}, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = d.SetImageReady(true, serial); err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range tags {
+		for _, p := range []string{"linux/amd64", "linux/arm64"} {
+			if err = d.SetImageTag(repo, tag, p, serial); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return serial
+}
+
+// serve calls the handler and decodes the response
+func serve(t *testing.T, handler http.HandlerFunc, method, target string, body io.Reader) (int, *ApiResponse) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(method, target, body))
+	res := &ApiResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), res); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, res
+}
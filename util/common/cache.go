@@ -6,7 +6,6 @@
 package common
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"github.com/containerd/containerd/log"
@@ -37,6 +36,16 @@ type LayerCache struct {
 
 	digest name.Digest
 	size   int64
+
+	// key identifies the layer in the cache backend
+	key     string
+	backend CacheBackend
+	blob    CachedBlob
+
+	// private is true if the layer is not shared in the LayerCachePool (e.g. only some ranges are loaded)
+	private bool
+	// charged is the number of bytes of the private layer accounted in the LayerCachePool
+	charged int64
 }
 
 func (lc *LayerCache) String() string {
@@ -60,6 +69,7 @@ func (lc *LayerCache) notify(err error) {
 		}
 		close(*s)
 	}
+	lc.subscribers = nil
 }
 
 func (lc *LayerCache) Subscribe(errChan *chan error) {
@@ -73,7 +83,9 @@ func (lc *LayerCache) Subscribe(errChan *chan error) {
 }
 
 func (lc *LayerCache) Load(ctx context.Context) (err error) {
-	defer lc.SetReady(err)
+	defer func() {
+		lc.SetReady(err)
+	}()
 
 	var l v1.Layer
 	l, err = remote.Layer(lc.digest, remote.WithAuthFromKeychain(authn.DefaultKeychain))
@@ -86,27 +98,45 @@ func (lc *LayerCache) Load(ctx context.Context) (err error) {
 		log.G(ctx).WithField("layer", lc.String()).Error(errors.Wrapf(err, "failed to load layer"))
 		return err
 	}
+	defer rc.Close()
 
-	buf := new(bytes.Buffer)
-	var n int64
-	n, err = io.Copy(buf, rc)
+	var blob CachedBlob
+	blob, err = lc.backend.Save(lc.key, rc)
 	if err != nil {
 		log.G(ctx).WithField("layer", lc.String()).Error(errors.Wrapf(err, "failed to load layer"))
 		return err
 	}
-	if n != lc.size {
+	if n := blob.Size(); n != lc.size {
+		_ = blob.Close()
+		_ = lc.backend.Remove(lc.key)
 		err = fmt.Errorf("size unmatch expected %d, but got %d", lc.size, n)
 		log.G(ctx).WithField("layer", lc.String()).Error(errors.Wrapf(err, "failed to load layer"))
 		return err
 	}
-	rc.Close()
 
-	lc.Buffer = io.NewSectionReader(bytes.NewReader(buf.Bytes()), 0, n)
+	lc.blob = blob
+	lc.Buffer = io.NewSectionReader(blob, 0, blob.Size())
 
 	return nil
 }
 
-func NewLayerCache(layer CacheInterface) *LayerCache {
+// release closes and removes the layer from the cache backend.
+// A private layer only owns its range segments, which are removed by closing the blob. The key is the digest
+// of the layer and may hold the entire layer shared in the pool.
+func (lc *LayerCache) release() error {
+	lc.Buffer = nil
+	if lc.blob == nil {
+		return nil
+	}
+	_ = lc.blob.Close()
+	lc.blob = nil
+	if lc.private {
+		return nil
+	}
+	return lc.backend.Remove(lc.key)
+}
+
+func NewLayerCache(layer CacheInterface, backend CacheBackend) *LayerCache {
 	return &LayerCache{
 		Buffer: nil,
 		Mutex:  sync.Mutex{},
@@ -116,5 +146,8 @@ func NewLayerCache(layer CacheInterface) *LayerCache {
 		LastUsed:   time.Now(),
 		digest:     layer.Digest(),
 		size:       layer.Size(),
+
+		key:     layer.Digest().DigestStr(),
+		backend: backend,
 	}
 }
@@ -0,0 +1,149 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// CachedBlob is a layer stored in a CacheBackend
+type CachedBlob interface {
+	io.ReaderAt
+	io.Closer
+	Size() int64
+}
+
+// CacheBackend stores the content of the layers in the LayerCachePool
+type CacheBackend interface {
+	// Save reads the layer from r and keeps it under key until Remove is called
+	Save(key string, r io.Reader) (CachedBlob, error)
+	// Remove deletes the layer saved under key
+	Remove(key string) error
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// Memory Backend
+
+type memoryBlob struct {
+	*bytes.Reader
+}
+
+func (b *memoryBlob) Close() error {
+	return nil
+}
+
+// MemoryCacheBackend keeps the layers in memory, it is fast but a few large layers could use up
+// all the memory of the proxy
+type MemoryCacheBackend struct{}
+
+func (m *MemoryCacheBackend) Save(key string, r io.Reader) (CachedBlob, error) {
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return &memoryBlob{bytes.NewReader(buf.Bytes())}, nil
+}
+
+func (m *MemoryCacheBackend) Remove(key string) error {
+	return nil
+}
+
+func NewMemoryCacheBackend() *MemoryCacheBackend {
+	return &MemoryCacheBackend{}
+}
+
+// ---------------------------------------------------------------------------------------------------------------------
+// Disk Backend
+
+const diskCacheSuffix = ".layer"
+
+type diskBlob struct {
+	*os.File
+	size int64
+}
+
+func (b *diskBlob) Size() int64 {
+	return b.size
+}
+
+// DiskCacheBackend spills the layers to a directory, so that serving a delta image only needs memory
+// for the chunks in flight
+type DiskCacheBackend struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+func (d *DiskCacheBackend) path(key string) string {
+	return filepath.Join(d.dir, strings.ReplaceAll(key, ":", "-")+diskCacheSuffix)
+}
+
+func (d *DiskCacheBackend) Save(key string, r io.Reader) (CachedBlob, error) {
+	tmp, err := os.CreateTemp(d.dir, "download-*"+diskCacheSuffix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create cache file")
+	}
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, errors.Wrapf(err, "failed to write cache file")
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err = os.Rename(tmp.Name(), d.path(key)); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return nil, errors.Wrapf(err, "failed to save cache file")
+	}
+	return &diskBlob{File: tmp, size: n}, nil
+}
+
+func (d *DiskCacheBackend) Remove(key string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if err := os.Remove(d.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NewDiskCacheBackend creates the cache directory and removes the layers left behind by the previous run
+func NewDiskCacheBackend(dir string) (*DiskCacheBackend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("cache directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create cache directory %s", dir)
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*"+diskCacheSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range stale {
+		_ = os.Remove(f)
+	}
+	return &DiskCacheBackend{dir: dir}, nil
+}
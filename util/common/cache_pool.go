@@ -0,0 +1,228 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// LayerCachePool shares the layers among the delta image builders.
+// The total size of the cached layers is bounded by budget (in bytes, 0 means unlimited),
+// the least recently used layers are evicted if the budget is exceeded. Layers that are being used
+// (UseCounter > 0) are never evicted, so the budget could be temporarily exceeded if all the layers are in use.
+type LayerCachePool struct {
+	mutex   sync.Mutex
+	layers  map[string]*LayerCache
+	backend CacheBackend
+
+	budget    int64
+	used      int64
+	evictions int64
+}
+
+// Fetch returns the cached layer, it loads the layer from the registry if it is not in the pool.
+// shared is true if the layer was loaded by another request.
+// The caller must call Release after using the layer.
+func (p *LayerCachePool) Fetch(ctx context.Context, layer CacheInterface) (c *LayerCache, shared bool, err error) {
+	key := layer.Digest().DigestStr()
+
+	p.mutex.Lock()
+	if c, shared = p.layers[key]; shared {
+		c.Mutex.Lock()
+		c.UseCounter += 1
+		c.Mutex.Unlock()
+		p.mutex.Unlock()
+
+		sub := make(chan error, 1)
+		c.Subscribe(&sub)
+		if err = <-sub; err == nil && c.Buffer == nil {
+			err = fmt.Errorf("layer %s is not available", key)
+		}
+	} else {
+		c = NewLayerCache(layer, p.backend)
+		c.UseCounter = 1
+		p.layers[key] = c
+		p.used += c.size
+		p.evict(ctx)
+		p.mutex.Unlock()
+
+		err = c.Load(ctx)
+	}
+
+	if err != nil {
+		p.Release(c)
+		p.mutex.Lock()
+		if p.layers[key] == c {
+			delete(p.layers, key)
+			p.used -= c.size
+		}
+		p.mutex.Unlock()
+		return nil, shared, err
+	}
+	return c, shared, nil
+}
+
+// FetchRanges loads only the given ranges of the layer from the registry. If the entire layer is
+// already in the pool, or the registry does not support range requests, it returns the entire layer instead.
+// The partial layer is private to the caller, and it is removed once released. The fetched ranges count
+// towards the budget while the partial layer is alive.
+func (p *LayerCachePool) FetchRanges(ctx context.Context, layer CacheInterface, ranges []ByteRange) (
+	c *LayerCache, shared bool, err error) {
+	p.mutex.Lock()
+	_, has := p.layers[layer.Digest().DigestStr()]
+	p.mutex.Unlock()
+	if has {
+		return p.Fetch(ctx, layer)
+	}
+
+	c = NewLayerCache(layer, p.backend)
+	c.UseCounter = 1
+	c.private = true
+	for _, r := range ranges {
+		c.charged += r.Length
+	}
+	p.mutex.Lock()
+	p.used += c.charged
+	p.evict(ctx)
+	p.mutex.Unlock()
+
+	if err = c.LoadRanges(ctx, ranges); err != nil {
+		p.uncharge(c)
+		if errors.Is(err, ErrRangeNotSupported) {
+			log.G(ctx).
+				WithField("layer", c.key).
+				Info("registry does not support range requests, fetching the entire layer")
+			return p.Fetch(ctx, layer)
+		}
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// Release marks the layer as no longer used by the caller
+func (p *LayerCachePool) Release(c *LayerCache) {
+	c.Mutex.Lock()
+	c.UseCounter -= 1
+	c.LastUsed = time.Now()
+	released := c.private && c.UseCounter <= 0
+	if released {
+		_ = c.release()
+	}
+	c.Mutex.Unlock()
+
+	if released {
+		p.uncharge(c)
+	}
+}
+
+// uncharge removes the bytes of the private layer from the pool
+func (p *LayerCachePool) uncharge(c *LayerCache) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.used -= c.charged
+	c.charged = 0
+}
+
+// evict removes the least recently used layers until the pool fits in the budget,
+// the caller must hold the pool mutex
+func (p *LayerCachePool) evict(ctx context.Context) {
+	if p.budget <= 0 || p.used <= p.budget {
+		return
+	}
+
+	candidates := make([]*LayerCache, 0)
+	for _, c := range p.layers {
+		c.Mutex.Lock()
+		if c.Ready && c.UseCounter <= 0 {
+			candidates = append(candidates, c)
+		}
+		c.Mutex.Unlock()
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastUsed.Before(candidates[j].LastUsed)
+	})
+
+	for _, c := range candidates {
+		if p.used <= p.budget {
+			return
+		}
+		p.remove(ctx, c)
+	}
+
+	if p.used > p.budget {
+		log.G(ctx).
+			WithField("used", p.used).
+			WithField("budget", p.budget).
+			Warn("layer cache exceeds budget, all the cached layers are in use")
+	}
+}
+
+// remove deletes the layer from the pool, the caller must hold the pool mutex
+func (p *LayerCachePool) remove(ctx context.Context, c *LayerCache) {
+	delete(p.layers, c.key)
+	p.used -= c.size
+	p.evictions += 1
+	if err := c.release(); err != nil {
+		log.G(ctx).WithField("layer", c.key).WithError(err).Warn("failed to remove cached layer")
+	}
+	log.G(ctx).WithField("layer", c.key).Debug("evicted cached layer")
+}
+
+// EvictExpired removes the layers that have not been used for timeout
+func (p *LayerCachePool) EvictExpired(ctx context.Context, timeout time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	expiry := time.Now().Add(-timeout)
+	for _, c := range p.layers {
+		c.Mutex.Lock()
+		expired := c.Ready && c.UseCounter <= 0 && c.LastUsed.Before(expiry)
+		c.Mutex.Unlock()
+		if expired {
+			p.remove(ctx, c)
+		}
+	}
+}
+
+// Size returns the total size of the cached layers and the ranges of the partial layers in bytes
+func (p *LayerCachePool) Size() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.used
+}
+
+// Evictions returns the number of layers evicted from the pool
+func (p *LayerCachePool) Evictions() int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.evictions
+}
+
+func NewLayerCachePool(backend CacheBackend, budget int64) *LayerCachePool {
+	return &LayerCachePool{
+		layers:  make(map[string]*LayerCache),
+		backend: backend,
+		budget:  budget,
+	}
+}
@@ -0,0 +1,268 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/log"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// ErrRangeNotSupported is returned by LoadRanges if the registry ignores the Range header
+var ErrRangeNotSupported = errors.New("registry does not support range requests")
+
+const (
+	// maximum number of concurrent range requests for a layer
+	rangeRequestConcurrency = 4
+)
+
+// ByteRange is a range [Offset, Offset+Length) in a compressed layer
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+func (r ByteRange) End() int64 {
+	return r.Offset + r.Length
+}
+
+// String returns the range as "offset:length"
+func (r ByteRange) String() string {
+	return fmt.Sprintf("%d:%d", r.Offset, r.Length)
+}
+
+// FormatByteRanges joins the ranges as a comma separated list of "offset:length"
+func FormatByteRanges(ranges []ByteRange) string {
+	s := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// ParseByteRanges parses the comma separated list of "offset:length" created by FormatByteRanges
+func ParseByteRanges(s string) ([]ByteRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	res := make([]ByteRange, 0, len(parts))
+	for _, p := range parts {
+		off, length, found := strings.Cut(p, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid byte range %q", p)
+		}
+		r := ByteRange{}
+		var err error
+		if r.Offset, err = strconv.ParseInt(off, 10, 64); err != nil || r.Offset < 0 {
+			return nil, fmt.Errorf("invalid offset in byte range %q", p)
+		}
+		if r.Length, err = strconv.ParseInt(length, 10, 64); err != nil || r.Length <= 0 {
+			return nil, fmt.Errorf("invalid length in byte range %q", p)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// CoalesceRanges sorts the ranges and merges the ranges that overlap or are less than gap bytes apart,
+// so that we send fewer requests to the registry at the cost of downloading a few more bytes
+func CoalesceRanges(ranges []ByteRange, gap int64) []ByteRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sorted := make([]ByteRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Offset < sorted[j].Offset
+	})
+
+	res := []ByteRange{sorted[0]}
+	for _, r := range sorted[1:] {
+		last := &res[len(res)-1]
+		if r.Offset <= last.End()+gap {
+			if r.End() > last.End() {
+				last.Length = r.End() - last.Offset
+			}
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+type rangeSegment struct {
+	ByteRange
+	key  string
+	blob CachedBlob
+}
+
+// rangeBlob is a layer that only has some byte ranges available,
+// reading outside the available ranges returns an error
+type rangeBlob struct {
+	segments []*rangeSegment
+	backend  CacheBackend
+	size     int64
+}
+
+func (r *rangeBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	// find the last segment that starts before off
+	i := sort.Search(len(r.segments), func(i int) bool {
+		return r.segments[i].Offset > off
+	}) - 1
+	if i < 0 || off >= r.segments[i].End() {
+		return 0, fmt.Errorf("offset %d is not in the fetched ranges", off)
+	}
+	s := r.segments[i]
+	if off+int64(len(p)) > s.End() {
+		n, err = s.blob.ReadAt(p[:s.End()-off], off-s.Offset)
+		if err == nil {
+			err = fmt.Errorf("range [%d, %d) is not in the fetched ranges", off, off+int64(len(p)))
+		}
+		return n, err
+	}
+	return s.blob.ReadAt(p, off-s.Offset)
+}
+
+func (r *rangeBlob) Size() int64 {
+	return r.size
+}
+
+func (r *rangeBlob) Close() error {
+	for _, s := range r.segments {
+		_ = s.blob.Close()
+		_ = r.backend.Remove(s.key)
+	}
+	r.segments = nil
+	return nil
+}
+
+// LoadRanges fetches only the given ranges of the layer from the registry using HTTP Range requests.
+func (lc *LayerCache) LoadRanges(ctx context.Context, ranges []ByteRange) (err error) {
+	defer func() {
+		lc.SetReady(err)
+	}()
+
+	repo := lc.digest.Context()
+	auth, err := authn.DefaultKeychain.Resolve(repo)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve credentials")
+	}
+	rt, err := transport.NewWithContext(ctx, repo.Registry, auth, http.DefaultTransport,
+		[]string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to registry")
+	}
+	client := &http.Client{Transport: rt}
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo.RepositoryStr(), lc.digest.DigestStr()),
+	}
+
+	rb := &rangeBlob{
+		segments: make([]*rangeSegment, len(ranges)),
+		backend:  lc.backend,
+		size:     lc.size,
+	}
+	id := uuid.New().String()
+
+	var errGrp errgroup.Group
+	errGrp.SetLimit(rangeRequestConcurrency)
+	for i, r := range ranges {
+		i, r := i, r
+		errGrp.Go(func() error {
+			key := fmt.Sprintf("%s-%s-%d", lc.key, id, r.Offset)
+			blob, err := fetchRange(ctx, client, u.String(), r, key, lc.backend)
+			if err != nil {
+				return err
+			}
+			rb.segments[i] = &rangeSegment{ByteRange: r, key: key, blob: blob}
+			return nil
+		})
+	}
+	if err = errGrp.Wait(); err != nil {
+		for _, s := range rb.segments {
+			if s != nil {
+				_ = s.blob.Close()
+				_ = lc.backend.Remove(s.key)
+			}
+		}
+		return err
+	}
+
+	log.G(ctx).
+		WithField("layer", lc.key).
+		WithField("ranges", len(ranges)).
+		Debug("fetched layer ranges")
+
+	lc.blob = rb
+	lc.Buffer = io.NewSectionReader(rb, 0, lc.size)
+	return nil
+}
+
+func fetchRange(ctx context.Context, client *http.Client, u string, r ByteRange,
+	key string, backend CacheBackend) (CachedBlob, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.Offset, r.End()-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request range [%d, %d)", r.Offset, r.End())
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		return nil, ErrRangeNotSupported
+	default:
+		return nil, fmt.Errorf("unexpected status %d for range [%d, %d)", resp.StatusCode, r.Offset, r.End())
+	}
+
+	var start, end int64
+	if _, err = fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d", &start, &end); err != nil ||
+		start != r.Offset || end != r.End()-1 {
+		return nil, fmt.Errorf("unexpected Content-Range %q for range [%d, %d)",
+			resp.Header.Get("Content-Range"), r.Offset, r.End())
+	}
+
+	blob, err := backend.Save(key, io.LimitReader(resp.Body, r.Length))
+	if err != nil {
+		return nil, err
+	}
+	if blob.Size() != r.Length {
+		_ = blob.Close()
+		_ = backend.Remove(key)
+		return nil, fmt.Errorf("size unmatch expected %d, but got %d", r.Length, blob.Size())
+	}
+	return blob, nil
+}
@@ -0,0 +1,250 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+func newTestLayerCache(t *testing.T, backend CacheBackend, key, content string, used int, lastUsed time.Time) *LayerCache {
+	blob, err := backend.Save(key, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &LayerCache{
+		Buffer:     io.NewSectionReader(blob, 0, blob.Size()),
+		Ready:      true,
+		UseCounter: used,
+		LastUsed:   lastUsed,
+		size:       blob.Size(),
+		key:        key,
+		backend:    backend,
+		blob:       blob,
+	}
+}
+
+func TestDiskCacheBackend(t *testing.T) {
+	backend, err := NewDiskCacheBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := backend.Save("sha256:1234", strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+	if blob.Size() != 11 {
+		t.Fatalf("expected 11 bytes but got %d", blob.Size())
+	}
+	buf := make([]byte, 5)
+	if _, err = blob.ReadAt(buf, 6); err != nil || string(buf) != "world" {
+		t.Fatalf("unexpected content %q (%v)", buf, err)
+	}
+	if err = backend.Remove("sha256:1234"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(backend.path("sha256:1234")); !os.IsNotExist(err) {
+		t.Fatalf("cache file should be removed")
+	}
+}
+
+func TestLayerCachePool_Evict(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewDiskCacheBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	p := NewLayerCachePool(backend, 10)
+	for _, c := range []*LayerCache{
+		newTestLayerCache(t, backend, "sha256:a", "aaaa", 0, now.Add(-3*time.Minute)),
+		newTestLayerCache(t, backend, "sha256:b", "bbbb", 1, now.Add(-4*time.Minute)),
+		newTestLayerCache(t, backend, "sha256:c", "cccc", 0, now.Add(-1*time.Minute)),
+		newTestLayerCache(t, backend, "sha256:d", "dddd", 0, now.Add(-2*time.Minute)),
+	} {
+		p.layers[c.key] = c
+		p.used += c.size
+	}
+
+	// "b" is in use, "a" and "d" are the least recently used
+	p.mutex.Lock()
+	p.evict(ctx)
+	p.mutex.Unlock()
+	if p.Size() != 8 {
+		t.Fatalf("expected 8 bytes in cache but got %d", p.Size())
+	}
+	for k, expected := range map[string]bool{"sha256:a": false, "sha256:b": true, "sha256:c": true, "sha256:d": false} {
+		if _, has := p.layers[k]; has != expected {
+			t.Errorf("layer %s: expected cached=%v", k, expected)
+		}
+		if _, err := os.Stat(backend.path(k)); (err == nil) != expected {
+			t.Errorf("layer %s: expected file exists=%v", k, expected)
+		}
+	}
+
+	p.EvictExpired(ctx, 30*time.Second)
+	if _, has := p.layers["sha256:b"]; !has || len(p.layers) != 1 {
+		t.Errorf("only the layer in use should be kept, got %d layers", len(p.layers))
+	}
+}
+
+func TestCoalesceRanges(t *testing.T) {
+	res := CoalesceRanges([]ByteRange{
+		{Offset: 100, Length: 10},
+		{Offset: 0, Length: 10},
+		{Offset: 15, Length: 10},
+		{Offset: 20, Length: 2},
+		{Offset: 200, Length: 1},
+	}, 5)
+	expected := []ByteRange{{0, 25}, {100, 10}, {200, 1}}
+	if len(res) != len(expected) {
+		t.Fatalf("expected %v but got %v", expected, res)
+	}
+	for i := range res {
+		if res[i] != expected[i] {
+			t.Fatalf("expected %v but got %v", expected, res)
+		}
+	}
+}
+
+func TestParseByteRanges(t *testing.T) {
+	ranges := []ByteRange{{0, 25}, {200, 1}, {100, 10}}
+	res, err := ParseByteRanges(FormatByteRanges(ranges))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(ranges) {
+		t.Fatalf("expected %v but got %v", ranges, res)
+	}
+	for i := range res {
+		if res[i] != ranges[i] {
+			t.Fatalf("expected %v but got %v", ranges, res)
+		}
+	}
+
+	for _, s := range []string{"1", "a:1", "1:0", "-1:5", "1:2,"} {
+		if _, err := ParseByteRanges(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+type testLayer struct {
+	d    name.Digest
+	size int64
+}
+
+func (l *testLayer) Digest() name.Digest { return l.d }
+func (l *testLayer) Size() int64         { return l.size }
+
+func newTestRegistry(t *testing.T, blob []byte, supportRange bool) (*httptest.Server, *testLayer) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(req.URL.Path, "/v2/test/blobs/"):
+			if !supportRange {
+				req.Header.Del("Range")
+			}
+			http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	d, err := name.NewDigest(fmt.Sprintf("%s/test@%s", strings.TrimPrefix(server.URL, "http://"),
+		digest.FromBytes(blob)), name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, &testLayer{d: d, size: int64(len(blob))}
+}
+
+func TestLayerCache_LoadRanges(t *testing.T) {
+	ctx := context.Background()
+	blob := []byte("0123456789ABCDEFGHIJabcdefghij")
+	server, layer := newTestRegistry(t, blob, true)
+	defer server.Close()
+
+	backend, err := NewDiskCacheBackend(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewLayerCachePool(backend, 0)
+	c, _, err := p.FetchRanges(ctx, layer, []ByteRange{{2, 3}, {20, 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+	if _, err = c.Buffer.ReadAt(buf, 20); err != nil || string(buf) != "abcde" {
+		t.Fatalf("unexpected content %q (%v)", buf, err)
+	}
+	if _, err = c.Buffer.ReadAt(buf[:3], 2); err != nil || string(buf[:3]) != "234" {
+		t.Fatalf("unexpected content %q (%v)", buf[:3], err)
+	}
+	if _, err = c.Buffer.ReadAt(buf, 10); err == nil {
+		t.Fatal("expected error reading outside the fetched ranges")
+	}
+	if _, has := p.layers[c.key]; has {
+		t.Fatal("partial layer should not be in the pool")
+	}
+	if p.Size() != 8 {
+		t.Fatalf("expected the 8 fetched bytes to be accounted but got %d", p.Size())
+	}
+
+	// the entire layer is fetched by another request in the meantime
+	full := newTestLayerCache(t, backend, c.key, string(blob), 0, time.Now())
+	p.mutex.Lock()
+	p.layers[full.key] = full
+	p.used += full.size
+	p.mutex.Unlock()
+
+	p.Release(c)
+	if p.Size() != full.size {
+		t.Fatalf("expected only the entire layer to be accounted but got %d", p.Size())
+	}
+	files, _ := filepath.Glob(filepath.Join(backend.dir, "*"+diskCacheSuffix))
+	if len(files) != 1 || files[0] != backend.path(full.key) {
+		t.Fatalf("only the entire layer should be kept after release, found %v", files)
+	}
+}
+
+func TestLayerCache_LoadRangesNotSupported(t *testing.T) {
+	ctx := context.Background()
+	blob := []byte("0123456789ABCDEFGHIJabcdefghij")
+	server, layer := newTestRegistry(t, blob, false)
+	defer server.Close()
+
+	c := NewLayerCache(layer, NewMemoryCacheBackend())
+	if err := c.LoadRanges(ctx, []ByteRange{{2, 3}}); !errors.Is(err, ErrRangeNotSupported) {
+		t.Fatalf("expected ErrRangeNotSupported but got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression is the algorithm that compresses the chunks and the TOC of a Starlight layer
+type Compression string
+
+const (
+	// CompressionGzip is the eStargz compatible layer format, every chunk is a gzip stream
+	CompressionGzip Compression = "gzip"
+	// CompressionZstd is the zstd:chunked style layer format, every chunk is a zstd frame
+	CompressionZstd Compression = "zstd"
+
+	// ZstdFooterSize is the number of bytes in the footer of a zstd Starlight layer
+	//
+	// The footer is a zstd skippable frame, so that the layer is still a valid zstd stream.
+	//
+	// 32 comes from:
+	//
+	// 4  bytes  magic number of the skippable frame (0x184D2A50, little endian)
+	// 4  bytes  size of the frame = 24 (little endian)
+	// 24 bytes  payload = fmt.Sprintf("%016xSTARZSTD", offsetOfTOC)
+	ZstdFooterSize = 32
+
+	zstdSkippableFrameMagic = 0x184D2A50
+	zstdFooterMagic         = "STARZSTD"
+)
+
+var (
+	// zstdDecoder is shared by all the goroutines, DecodeAll is safe for concurrent use
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// ParseCompression converts the name of the compression algorithm, empty string means gzip
+func ParseCompression(s string) (Compression, error) {
+	switch Compression(s) {
+	case "", CompressionGzip:
+		return CompressionGzip, nil
+	case CompressionZstd:
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("unsupported compression %q", s)
+	}
+}
+
+// DecompressChunk decompresses a chunk of a Starlight layer. compression is the value recorded in the TOC
+// (empty means gzip) and size is the size of the uncompressed chunk.
+func DecompressChunk(compression string, p []byte, size int64) ([]byte, error) {
+	c, err := ParseCompression(compression)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case CompressionZstd:
+		buf, err := zstdDecoder.DecodeAll(p, make([]byte, 0, size))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decompress zstd chunk")
+		}
+		if int64(len(buf)) < size {
+			return nil, fmt.Errorf("zstd chunk has %d bytes, expected %d", len(buf), size)
+		}
+		return buf[:size], nil
+	default:
+		gr, err := gzip.NewReader(bytes.NewReader(p))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create gzip reader")
+		}
+		buf := make([]byte, size)
+		if _, err = io.ReadFull(gr, buf); err != nil {
+			return nil, errors.Wrapf(err, "failed to decompress gzip chunk")
+		}
+		return buf, nil
+	}
+}
+
+// newDecompressor returns a reader of the concatenated gzip streams or zstd frames
+func newDecompressor(c Compression, r io.Reader) (io.ReadCloser, error) {
+	if c == CompressionZstd {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return gzip.NewReader(r)
+}
+
+// zstdFooterBytes returns the 32 bytes footer of a zstd Starlight layer.
+func zstdFooterBytes(tocOff int64) []byte {
+	buf := make([]byte, 8, ZstdFooterSize)
+	binary.LittleEndian.PutUint32(buf[0:4], zstdSkippableFrameMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(ZstdFooterSize-8))
+	buf = append(buf, []byte(fmt.Sprintf("%016x%s", tocOff, zstdFooterMagic))...)
+	if len(buf) != ZstdFooterSize {
+		panic(fmt.Sprintf("footer buffer = %d, not %d", len(buf), ZstdFooterSize))
+	}
+	return buf
+}
+
+func parseZstdFooter(p []byte) (tocOffset int64, err error) {
+	if len(p) != ZstdFooterSize {
+		return 0, fmt.Errorf("zstd: invalid length %d cannot be parsed", len(p))
+	}
+	if binary.LittleEndian.Uint32(p[0:4]) != zstdSkippableFrameMagic {
+		return 0, fmt.Errorf("zstd: footer is not a skippable frame")
+	}
+	if binary.LittleEndian.Uint32(p[4:8]) != uint32(ZstdFooterSize-8) {
+		return 0, fmt.Errorf("zstd: invalid size of the footer frame")
+	}
+	if string(p[24:]) != zstdFooterMagic {
+		return 0, fmt.Errorf("zstd: magic string %s not found", zstdFooterMagic)
+	}
+	return strconv.ParseInt(string(p[8:24]), 16, 64)
+}
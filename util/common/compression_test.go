@@ -0,0 +1,208 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func newTestLayer(t *testing.T, compression Compression, level, chunkSize int, files map[string][]byte) []byte {
+	return newTestLayerWithPolicy(t, compression, level, chunkSize, 0, files)
+}
+
+func newTestLayerWithThreshold(t *testing.T, threshold int64, files map[string][]byte) []byte {
+	return newTestLayerWithPolicy(t, CompressionGzip, gzip.BestSpeed, 1000, threshold, files)
+}
+
+func newTestLayerWithPolicy(t *testing.T, compression Compression, level, chunkSize int, threshold int64,
+	files map[string][]byte) []byte {
+	src := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(src)
+	for n, content := range files {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     n,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := bytes.NewBuffer(nil)
+	w := NewWriterCompression(dst, compression, level)
+	w.ChunkSize = chunkSize
+	w.LargeFileThreshold = threshold
+	if err := w.AppendTar(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dst.Bytes()
+}
+
+func TestWriterCompression(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	large := make([]byte, 10000)
+	rnd.Read(large)
+	files := map[string][]byte{
+		"large.bin": large,
+		"small.txt": []byte("hello starlight"),
+	}
+
+	for _, tc := range []struct {
+		compression Compression
+		level       int
+		footerSize  int64
+		recorded    string
+	}{
+		{CompressionGzip, gzip.BestCompression, FooterSize, ""},
+		{CompressionZstd, 9, ZstdFooterSize, "zstd"},
+	} {
+		t.Run(string(tc.compression), func(t *testing.T) {
+			layer := newTestLayer(t, tc.compression, tc.level, 4096, files)
+			sr := io.NewSectionReader(bytes.NewReader(layer), 0, int64(len(layer)))
+
+			_, footerSize, err := OpenFooter(sr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if footerSize != tc.footerSize {
+				t.Fatalf("expected footer size %d but got %d", tc.footerSize, footerSize)
+			}
+
+			r, err := OpenStargz(sr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, chunks, _ := r.GetTOC()
+			if len(chunks["large.bin"]) != 3 {
+				t.Fatalf("expected 3 chunks but got %d", len(chunks["large.bin"]))
+			}
+
+			// every chunk can be decompressed on its own, which is what the client does
+			var content []byte
+			for _, ch := range chunks["large.bin"] {
+				if ch.Compression != tc.recorded {
+					t.Errorf("expected compression %q but got %q", tc.recorded, ch.Compression)
+				}
+				buf, err := DecompressChunk(ch.Compression, layer[ch.Offset:ch.Offset+ch.CompressedSize], ch.ChunkSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				content = append(content, buf...)
+			}
+			if !bytes.Equal(content, large) {
+				t.Error("content of the chunks does not match the file")
+			}
+
+			// the whole layer is still a regular compressed tar
+			zr, err := newDecompressor(tc.compression, bytes.NewReader(layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			found := 0
+			tr := tar.NewReader(zr)
+			for {
+				h, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, has := files[h.Name]; has {
+					found++
+				}
+			}
+			if found != len(files) {
+				t.Errorf("expected %d files in the tar but found %d", len(files), found)
+			}
+
+			for n, expected := range files {
+				f, err := r.OpenFile(n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual := make([]byte, len(expected))
+				if _, err = f.ReadAt(actual, 0); err != nil && err != io.EOF {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(actual, expected) {
+					t.Errorf("content of %s does not match", n)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for s, expected := range map[string]Compression{
+		"":     CompressionGzip,
+		"gzip": CompressionGzip,
+		"zstd": CompressionZstd,
+	} {
+		if c, err := ParseCompression(s); err != nil || c != expected {
+			t.Errorf("%q: expected %s but got %s (%v)", s, expected, c, err)
+		}
+	}
+	if _, err := ParseCompression("lz4"); err == nil {
+		t.Error("expected error for unsupported compression")
+	}
+}
+
+func TestWriterLargeFileThreshold(t *testing.T) {
+	files := map[string][]byte{
+		"medium.bin": bytes.Repeat([]byte("m"), 3000),
+		"large.bin":  bytes.Repeat([]byte("l"), 5000),
+	}
+
+	for _, tc := range []struct {
+		threshold       int64
+		medium, largeCh int
+	}{
+		{0, 3, 5},    // same as the chunk size
+		{4000, 1, 5}, // medium file is kept in one chunk
+		{8000, 1, 1},
+	} {
+		layer := newTestLayerWithThreshold(t, tc.threshold, files)
+		sr := io.NewSectionReader(bytes.NewReader(layer), 0, int64(len(layer)))
+		r, err := OpenStargz(sr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n, expected := range map[string]int{"medium.bin": tc.medium, "large.bin": tc.largeCh} {
+			e, _ := r.Lookup(n)
+			if actual := len(r.getChunks(e)); actual != expected {
+				t.Errorf("threshold %d: expected %d chunks in %s but got %d", tc.threshold, expected, n, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SignatureAnnotation is the annotation of the signature layer that holds the base64 encoded signature
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// SignaturePayloadType is the type of the cosign "simple signing" payload
+	SignaturePayloadType = "cosign container image signature"
+
+	// maximum size of a signature payload, the payload is a small JSON document
+	maxSignaturePayloadSize = 1 << 20
+)
+
+// ErrNotSigned is returned if none of the signatures of the image can be verified with the configured keys
+var ErrNotSigned = errors.New("image is not signed by any of the trusted keys")
+
+// SignaturePayload is the cosign "simple signing" payload, the signature is computed over its raw bytes
+type SignaturePayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional map[string]interface{} `json:"optional"`
+}
+
+// SignatureAttestation is a signature of the image, the proxy passes the signatures it has verified to the
+// client in the delta image header, so that the client could verify them with its own keys
+type SignatureAttestation struct {
+	Payload   []byte `json:"p"`
+	Signature string `json:"s"`
+
+	// Index is the raw image index if the index is signed rather than the manifest of the platform
+	Index []byte `json:"i,omitempty"`
+}
+
+// SignatureVerifier verifies cosign-style signatures with locally configured public keys
+type SignatureVerifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewSignatureVerifier loads the PEM encoded public keys (ECDSA, RSA or Ed25519).
+// It returns nil if no key is given, which means signatures are not verified.
+func NewSignatureVerifier(keyFiles []string) (*SignatureVerifier, error) {
+	if len(keyFiles) == 0 {
+		return nil, nil
+	}
+	v := &SignatureVerifier{}
+	for _, f := range keyFiles {
+		buf, err := os.ReadFile(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read public key %s", f)
+		}
+		k, err := ParsePublicKey(buf)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse public key %s", f)
+		}
+		v.keys = append(v.keys, k)
+	}
+	return v, nil
+}
+
+// ParsePublicKey parses a PEM encoded PKIX public key
+func ParsePublicKey(buf []byte) (crypto.PublicKey, error) {
+	b, _ := pem.Decode(buf)
+	if b == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	k, err := x509.ParsePKIXPublicKey(b.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch k.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", k)
+	}
+}
+
+// verifySignature checks the signature of the payload against all the keys
+func (v *SignatureVerifier) verifySignature(payload []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decode signature")
+	}
+	h := sha256.Sum256(payload)
+	for _, k := range v.keys {
+		switch pk := k.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(pk, h[:], sig) {
+				return nil
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(pk, crypto.SHA256, h[:], sig) == nil {
+				return nil
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(pk, payload, sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature does not match any of the trusted keys")
+}
+
+// Verify returns the first attestation that is signed by a trusted key and that covers the manifest,
+// either directly or through the image index included in the attestation
+func (v *SignatureVerifier) Verify(manifestDigest string, attestations []*SignatureAttestation) (
+	*SignatureAttestation, error) {
+	var reasons []string
+	for _, a := range attestations {
+		if err := v.verifyAttestation(manifestDigest, a); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		return a, nil
+	}
+	if len(reasons) == 0 {
+		return nil, errors.Wrap(ErrNotSigned, "no signature found")
+	}
+	return nil, errors.Wrap(ErrNotSigned, strings.Join(reasons, "; "))
+}
+
+func (v *SignatureVerifier) verifyAttestation(manifestDigest string, a *SignatureAttestation) error {
+	if err := v.verifySignature(a.Payload, a.Signature); err != nil {
+		return err
+	}
+	var p SignaturePayload
+	if err := json.Unmarshal(a.Payload, &p); err != nil {
+		return errors.Wrapf(err, "failed to parse signature payload")
+	}
+	if p.Critical.Type != SignaturePayloadType {
+		return fmt.Errorf("unexpected signature type %q", p.Critical.Type)
+	}
+	signed := p.Critical.Image.DockerManifestDigest
+	if signed == manifestDigest {
+		return nil
+	}
+	if a.Index == nil {
+		return fmt.Errorf("signature is for %s, not %s", signed, manifestDigest)
+	}
+
+	// the index is signed, it must list the manifest
+	d, _, err := v1.SHA256(bytes.NewReader(a.Index))
+	if err != nil {
+		return err
+	}
+	if d.String() != signed {
+		return fmt.Errorf("signature is for %s, but the index is %s", signed, d)
+	}
+	idx, err := v1.ParseIndexManifest(bytes.NewReader(a.Index))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse image index")
+	}
+	for _, m := range idx.Manifests {
+		if m.Digest.String() == manifestDigest {
+			return nil
+		}
+	}
+	return fmt.Errorf("signed index %s does not contain %s", signed, manifestDigest)
+}
+
+// SignatureTag returns the tag of the cosign signatures of the manifest (e.g. "sha256-abc.sig")
+func SignatureTag(manifestDigest string) string {
+	return strings.Replace(manifestDigest, ":", "-", 1) + ".sig"
+}
+
+// FetchSignatures loads the cosign signatures of the manifest from the repository. index is the raw image
+// index if manifestDigest refers to an index, it is attached to the attestations. It returns no attestation
+// if the manifest has not been signed.
+func FetchSignatures(repo name.Repository, manifestDigest string, index []byte, options ...remote.Option) (
+	[]*SignatureAttestation, error) {
+	img, err := remote.Image(repo.Tag(SignatureTag(manifestDigest)), options...)
+	if err != nil {
+		var te *transport.Error
+		if errors.As(err, &te) && te.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "failed to load signatures of %s", manifestDigest)
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load signatures of %s", manifestDigest)
+	}
+
+	res := make([]*SignatureAttestation, 0, len(m.Layers))
+	for _, l := range m.Layers {
+		sig, has := l.Annotations[SignatureAnnotation]
+		if !has {
+			continue
+		}
+		layer, err := img.LayerByDigest(l.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load signature payload %s", l.Digest)
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load signature payload %s", l.Digest)
+		}
+		payload, err := io.ReadAll(io.LimitReader(rc, maxSignaturePayloadSize))
+		_ = rc.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read signature payload %s", l.Digest)
+		}
+		res = append(res, &SignatureAttestation{
+			Payload:   payload,
+			Signature: sig,
+			Index:     index,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,187 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package common
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func newSignaturePayload(t *testing.T, manifestDigest string) []byte {
+	p := SignaturePayload{}
+	p.Critical.Identity.DockerReference = "registry.example.com/test/app"
+	p.Critical.Image.DockerManifestDigest = manifestDigest
+	p.Critical.Type = SignaturePayloadType
+	buf, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func writePublicKey(t *testing.T, pub crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), "cosign.pub")
+	if err = os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func signECDSA(t *testing.T, k *ecdsa.PrivateKey, payload []byte) string {
+	h := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, k, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestSignatureVerifier_Verify(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	v, err := NewSignatureVerifier([]string{writePublicKey(t, &ecKey.PublicKey), writePublicKey(t, edPub)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := "sha256:" + strings.Repeat("a", 64)
+	payload := newSignaturePayload(t, manifest)
+
+	index := []byte(fmt.Sprintf(`{"schemaVersion":2,"manifests":[{"digest":"%s","size":1}]}`, manifest))
+	indexDigest, _, _ := v1.SHA256(bytes.NewReader(index))
+	indexPayload := newSignaturePayload(t, indexDigest.String())
+
+	for n, tc := range map[string]struct {
+		a     *SignatureAttestation
+		valid bool
+	}{
+		"ecdsa":   {&SignatureAttestation{Payload: payload, Signature: signECDSA(t, ecKey, payload)}, true},
+		"ed25519": {&SignatureAttestation{Payload: payload, Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edKey, payload))}, true},
+		"index":   {&SignatureAttestation{Payload: indexPayload, Signature: signECDSA(t, ecKey, indexPayload), Index: index}, true},
+		"untrusted key": {
+			&SignatureAttestation{Payload: payload, Signature: signECDSA(t, otherKey, payload)}, false},
+		"other manifest": {
+			&SignatureAttestation{Payload: indexPayload, Signature: signECDSA(t, ecKey, indexPayload)}, false},
+		"tampered index": {
+			&SignatureAttestation{Payload: indexPayload, Signature: signECDSA(t, ecKey, indexPayload), Index: append(index, ' ')}, false},
+		"tampered payload": {
+			&SignatureAttestation{Payload: newSignaturePayload(t, manifest+"b"), Signature: signECDSA(t, ecKey, payload)}, false},
+	} {
+		_, err := v.Verify(manifest, []*SignatureAttestation{tc.a})
+		if tc.valid && err != nil {
+			t.Errorf("%s: %v", n, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrNotSigned) {
+			t.Errorf("%s: expected ErrNotSigned but got %v", n, err)
+		}
+	}
+
+	if _, err = v.Verify(manifest, nil); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("expected ErrNotSigned without signatures but got %v", err)
+	}
+	if v, err = NewSignatureVerifier(nil); v != nil || err != nil {
+		t.Error("verifier should be disabled without keys")
+	}
+}
+
+// payloadLayer is the layer of a cosign signature image, the payload is stored as is
+type payloadLayer []byte
+
+func (l payloadLayer) Digest() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(l))
+	return h, err
+}
+func (l payloadLayer) DiffID() (v1.Hash, error) { return l.Digest() }
+func (l payloadLayer) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(l)), nil
+}
+func (l payloadLayer) Uncompressed() (io.ReadCloser, error) { return l.Compressed() }
+func (l payloadLayer) Size() (int64, error)                 { return int64(len(l)), nil }
+func (l payloadLayer) MediaType() (types.MediaType, error) {
+	return "application/vnd.dev.cosign.simplesigning.v1+json", nil
+}
+
+func TestFetchSignatures(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	repo, err := name.NewRepository(strings.TrimPrefix(s.URL, "http://")+"/test/app", name.Insecure)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	manifest := "sha256:" + strings.Repeat("a", 64)
+	payload := newSignaturePayload(t, manifest)
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer:       payloadLayer(payload),
+		Annotations: map[string]string{SignatureAnnotation: signECDSA(t, key, payload)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = remote.Write(repo.Tag(SignatureTag(manifest)), img); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := FetchSignatures(repo, manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || !bytes.Equal(res[0].Payload, payload) {
+		t.Fatalf("expected the signature payload but got %v", res)
+	}
+	v, _ := NewSignatureVerifier([]string{writePublicKey(t, &key.PublicKey)})
+	if _, err = v.Verify(manifest, res); err != nil {
+		t.Error(err)
+	}
+
+	// not signed
+	if res, err = FetchSignatures(repo, "sha256:"+strings.Repeat("b", 64), nil); err != nil || len(res) != 0 {
+		t.Errorf("expected no signature but got %v (%v)", res, err)
+	}
+}
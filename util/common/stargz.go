@@ -47,15 +47,17 @@ import (
 	"sync"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
 // A Reader permits random access reads from a stargz file.
 type Reader struct {
-	sr        *io.SectionReader
-	toc       *jtoc
-	tocDigest digest.Digest
+	sr          *io.SectionReader
+	toc         *jtoc
+	tocDigest   digest.Digest
+	compression Compression
 
 	// m stores all non-chunk entries, keyed by name.
 	m map[string]*TOCEntry
@@ -74,19 +76,26 @@ func OpenStargz(sr *io.SectionReader) (*Reader, error) {
 	if err != nil {
 		return nil, errors.Wrapf(err, "error parsing footer")
 	}
+	compression := CompressionGzip
+	if footerSize == ZstdFooterSize {
+		compression = CompressionZstd
+	}
 	tocTargz := make([]byte, sr.Size()-tocOff-footerSize)
 	if _, err := sr.ReadAt(tocTargz, tocOff); err != nil {
 		return nil, fmt.Errorf("error reading %d byte TOC targz: %v", len(tocTargz), err)
 	}
-	zr, err := gzip.NewReader(bytes.NewReader(tocTargz))
+	zr, err := newDecompressor(compression, bytes.NewReader(tocTargz))
 	if err != nil {
-		return nil, fmt.Errorf("malformed TOC gzip header: %v", err)
+		return nil, fmt.Errorf("malformed TOC %s header: %v", compression, err)
+	}
+	defer zr.Close()
+	if gr, ok := zr.(*gzip.Reader); ok {
+		gr.Multistream(false)
 	}
-	zr.Multistream(false)
 	tr := tar.NewReader(zr)
 	h, err := tr.Next()
 	if err != nil {
-		return nil, fmt.Errorf("failed to find tar header in TOC gzip stream: %v", err)
+		return nil, fmt.Errorf("failed to find tar header in TOC %s stream: %v", compression, err)
 	}
 	if h.Name != TOCTarName {
 		return nil, fmt.Errorf("TOC tar entry had name %q; expected %q", h.Name, TOCTarName)
@@ -96,7 +105,7 @@ func OpenStargz(sr *io.SectionReader) (*Reader, error) {
 	if err := json.NewDecoder(io.TeeReader(tr, dgstr.Hash())).Decode(&toc); err != nil {
 		return nil, fmt.Errorf("error decoding TOC JSON: %v", err)
 	}
-	r := &Reader{sr: sr, toc: toc, tocDigest: dgstr.Digest()}
+	r := &Reader{sr: sr, toc: toc, tocDigest: dgstr.Digest(), compression: compression}
 	if err := r.initFields(); err != nil {
 		return nil, fmt.Errorf("failed to initialize fields of entries: %v", err)
 	}
@@ -104,7 +113,17 @@ func OpenStargz(sr *io.SectionReader) (*Reader, error) {
 }
 
 // OpenFooter extracts and parses footer from the given blob.
+// footerSize is ZstdFooterSize if the blob is a zstd Starlight layer.
 func OpenFooter(sr *io.SectionReader) (tocOffset int64, footerSize int64, rErr error) {
+	if sr.Size() >= ZstdFooterSize {
+		var footer [ZstdFooterSize]byte
+		if _, err := sr.ReadAt(footer[:], sr.Size()-ZstdFooterSize); err != nil {
+			return 0, 0, fmt.Errorf("error reading footer: %v", err)
+		}
+		if tocOffset, err := parseZstdFooter(footer[:]); err == nil {
+			return tocOffset, ZstdFooterSize, nil
+		}
+	}
 	if sr.Size() < FooterSize && sr.Size() < legacyFooterSize {
 		return 0, 0, fmt.Errorf("blob size %d is smaller than the footer size", sr.Size())
 	}
@@ -452,14 +471,15 @@ func (fr *fileReader) ReadAt(p []byte, off int64) (n int, err error) {
 		return 0, fmt.Errorf("fileReader.ReadAt.peek: %v", err)
 	}
 
-	gz, err := gzip.NewReader(br)
+	zr, err := newDecompressor(fr.r.compression, br)
 	if err != nil {
-		return 0, fmt.Errorf("fileReader.ReadAt.gzipNewReader: %v", err)
+		return 0, fmt.Errorf("fileReader.ReadAt.newDecompressor: %v", err)
 	}
-	if n, err := io.CopyN(ioutil.Discard, gz, off); n != off || err != nil {
+	defer zr.Close()
+	if n, err := io.CopyN(ioutil.Discard, zr, off); n != off || err != nil {
 		return 0, fmt.Errorf("discard of %d bytes = %v, %v", off, n, err)
 	}
-	return io.ReadFull(gz, p)
+	return io.ReadFull(zr, p)
 }
 
 // A Writer writes stargz files.
@@ -473,37 +493,52 @@ type Writer struct {
 	digestHash hash.Hash // SHA-256 of compressed tar
 
 	closed           bool
-	gz               *gzip.Writer
+	zw               io.WriteCloser // current gzip stream or zstd frame
+	zenc             *zstd.Encoder  // reused for all the zstd frames
 	lastUsername     map[int]string
 	lastGroupname    map[int]string
+	compression      Compression
 	compressionLevel int
 
 	// ChunkSize optionally controls the maximum number of bytes
 	// of data of a regular file that can be written in one gzip
-	// stream before a new gzip stream is started.
+	// stream (or zstd frame) before a new one is started.
 	// Zero means to use a default, currently 4 MiB.
 	ChunkSize int
+
+	// LargeFileThreshold optionally controls which regular files are
+	// split into chunks. Files no larger than the threshold are written
+	// in one gzip stream (or zstd frame) regardless of ChunkSize.
+	// Zero means to use ChunkSize.
+	LargeFileThreshold int64
 }
 
-// currentGzipWriter writes to the current w.gz field, which can
+// currentCompressedWriter writes to the current w.zw field, which can
 // change throughout writing a tar entry.
 //
 // Additionally, it updates w's SHA-256 of the uncompressed bytes
 // of the tar file.
-type currentGzipWriter struct{ w *Writer }
+type currentCompressedWriter struct{ w *Writer }
 
-func (cgw currentGzipWriter) Write(p []byte) (int, error) {
-	cgw.w.diffHash.Write(p)
-	return cgw.w.gz.Write(p)
+func (ccw currentCompressedWriter) Write(p []byte) (int, error) {
+	ccw.w.diffHash.Write(p)
+	return ccw.w.zw.Write(p)
 }
 
 func (w *Writer) chunkSize() int {
 	if w.ChunkSize <= 0 {
-		return 4 << 20
+		return DefaultChunkSize
 	}
 	return w.ChunkSize
 }
 
+func (w *Writer) largeFileThreshold() int64 {
+	if w.LargeFileThreshold <= 0 {
+		return int64(w.chunkSize())
+	}
+	return w.LargeFileThreshold
+}
+
 // NewWriter returns a new stargz writer writing to w.
 //
 // The writer must be closed to write its trailing table of contents.
@@ -516,6 +551,14 @@ func NewWriter(w io.Writer) *Writer {
 //
 // The writer must be closed to write its trailing table of contents.
 func NewWriterLevel(w io.Writer, compressionLevel int) *Writer {
+	return NewWriterCompression(w, CompressionGzip, compressionLevel)
+}
+
+// NewWriterCompression returns a new Starlight layer writer writing to w using the compression algorithm.
+// For zstd, compressionLevel is the zstd level and it is mapped to the closest level of the encoder.
+//
+// The writer must be closed to write its trailing table of contents.
+func NewWriterCompression(w io.Writer, compression Compression, compressionLevel int) *Writer {
 	digest := sha256.New()
 	mw := io.MultiWriter(w, digest)
 	bw := bufio.NewWriter(mw)
@@ -526,6 +569,7 @@ func NewWriterLevel(w io.Writer, compressionLevel int) *Writer {
 		toc:              &jtoc{Version: 1},
 		diffHash:         sha256.New(),
 		digestHash:       digest,
+		compression:      compression,
 		compressionLevel: compressionLevel,
 	}
 }
@@ -538,14 +582,14 @@ func (w *Writer) Close() (digest.Digest, error) {
 	}
 	defer func() { w.closed = true }()
 
-	if err := w.closeGz(); err != nil {
+	if err := w.closeCompressor(); err != nil {
 		return "", err
 	}
 
 	// Write the TOC index.
 	tocOff := w.cw.n
-	w.gz, _ = gzip.NewWriterLevel(w.cw, w.compressionLevel)
-	tw := tar.NewWriter(currentGzipWriter{w})
+	w.condOpenCompressor()
+	tw := tar.NewWriter(currentCompressedWriter{w})
 	tocJSON, err := json.MarshalIndent(w.toc, "", "\t")
 	if err != nil {
 		return "", err
@@ -564,12 +608,16 @@ func (w *Writer) Close() (digest.Digest, error) {
 	if err := tw.Close(); err != nil {
 		return "", err
 	}
-	if err := w.closeGz(); err != nil {
+	if err := w.closeCompressor(); err != nil {
 		return "", err
 	}
 
 	// And a little footer with pointer to the TOC gzip stream.
-	if _, err := w.bw.Write(footerBytes(tocOff)); err != nil {
+	footer := footerBytes
+	if w.compression == CompressionZstd {
+		footer = zstdFooterBytes
+	}
+	if _, err := w.bw.Write(footer(tocOff)); err != nil {
 		return "", err
 	}
 
@@ -580,15 +628,15 @@ func (w *Writer) Close() (digest.Digest, error) {
 	return digest.FromBytes(tocJSON), nil
 }
 
-func (w *Writer) closeGz() error {
+func (w *Writer) closeCompressor() error {
 	if w.closed {
 		return errors.New("write on closed Writer")
 	}
-	if w.gz != nil {
-		if err := w.gz.Close(); err != nil {
+	if w.zw != nil {
+		if err := w.zw.Close(); err != nil {
 			return err
 		}
-		w.gz = nil
+		w.zw = nil
 	}
 	return nil
 }
@@ -609,17 +657,30 @@ func (w *Writer) nameIfChanged(mp *map[int]string, id int, name string) string {
 	return name
 }
 
-func (w *Writer) condOpenGz() {
-	if w.gz == nil {
-		w.gz, _ = gzip.NewWriterLevel(w.cw, w.compressionLevel)
+func (w *Writer) condOpenCompressor() {
+	if w.zw != nil {
+		return
 	}
+	if w.compression != CompressionZstd {
+		w.zw, _ = gzip.NewWriterLevel(w.cw, w.compressionLevel)
+		return
+	}
+	if w.zenc == nil {
+		w.zenc, _ = zstd.NewWriter(w.cw,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(w.compressionLevel)),
+			zstd.WithEncoderConcurrency(1),
+		)
+	} else {
+		w.zenc.Reset(w.cw)
+	}
+	w.zw = w.zenc
 }
 
 // AppendTar reads the tar or tar.gz file from r and appends
 // each of its contents to w.
 //
 // The input r can optionally be gzip compressed but the output will
-// always be gzip compressed.
+// always be compressed using the compression of the writer.
 func (w *Writer) AppendTar(r io.Reader) error {
 	br := bufio.NewReader(r)
 	var tr *tar.Reader
@@ -664,8 +725,8 @@ func (w *Writer) AppendTar(r io.Reader) error {
 			ModTime3339: formatModtime(h.ModTime),
 			Xattrs:      xattrs,
 		}
-		w.condOpenGz()
-		tw := tar.NewWriter(currentGzipWriter{w})
+		w.condOpenCompressor()
+		tw := tar.NewWriter(currentCompressedWriter{w})
 		if err := tw.WriteHeader(h); err != nil {
 			return err
 		}
@@ -712,13 +773,16 @@ func (w *Writer) AppendTar(r io.Reader) error {
 			var prevEnt *TOCEntry
 			prevEnt = nil
 			for written < totalSize {
-				if err := w.closeGz(); err != nil {
+				if err := w.closeCompressor(); err != nil {
 					return err
 				}
 				if prevEnt != nil {
 					prevEnt.CompressedSize = w.cw.n - prevEnt.Offset
 				}
 				chunkSize := int64(w.chunkSize())
+				if totalSize <= w.largeFileThreshold() {
+					chunkSize = totalSize
+				}
 				remain := totalSize - written
 				if remain < chunkSize {
 					chunkSize = remain
@@ -727,9 +791,12 @@ func (w *Writer) AppendTar(r io.Reader) error {
 				}
 				ent.Offset = w.cw.n
 				ent.ChunkOffset = written
+				if w.compression == CompressionZstd {
+					ent.Compression = string(CompressionZstd)
+				}
 				chunkDigest := digest.Canonical.Digester()
 
-				w.condOpenGz()
+				w.condOpenCompressor()
 				didWrite = true
 				teeChunk := io.TeeReader(tee, chunkDigest.Hash())
 				if _, err := io.CopyN(tw, teeChunk, chunkSize); err != nil {
@@ -745,13 +812,13 @@ func (w *Writer) AppendTar(r io.Reader) error {
 				}
 			}
 			if didWrite {
-				if err := w.closeGz(); err != nil {
+				if err := w.closeCompressor(); err != nil {
 					return err
 				}
 				if prevEnt != nil {
 					prevEnt.CompressedSize = w.cw.n - prevEnt.Offset
 				}
-				w.condOpenGz()
+				w.condOpenCompressor()
 			}
 		} else {
 			w.toc.Entries = append(w.toc.Entries, ent)
@@ -772,6 +839,11 @@ func (w *Writer) DiffID() string {
 	return fmt.Sprintf("sha256:%x", w.diffHash.Sum(nil))
 }
 
+// Compression returns the compression algorithm of the writer
+func (w *Writer) Compression() Compression {
+	return w.compression
+}
+
 // Digest returns the SHA-256 of the compressed tar bytes.
 // It is only valid to call Digest after Close
 func (w *Writer) Digest() string {
@@ -781,7 +853,6 @@ func (w *Writer) Digest() string {
 // footerBytes returns the 51 bytes footer.
 func footerBytes(tocOff int64) []byte {
 	buf := bytes.NewBuffer(make([]byte, 0, FooterSize))
-	gz, _ := gzip.NewWriterLevel(buf, gzip.NoCompression) // MUST be NoCompression to keep 51 bytes
 
 	// Extra header indicating the offset of TOCJSON
 	// https://tools.ietf.org/html/rfc1952#section-2.3.1.1
@@ -789,8 +860,15 @@ func footerBytes(tocOff int64) []byte {
 	header[0], header[1] = 'S', 'G'
 	subfield := fmt.Sprintf("%016xSTARGZ", tocOff)
 	binary.LittleEndian.PutUint16(header[2:4], uint16(len(subfield))) // little-endian per RFC1952
-	gz.Header.Extra = append(header, []byte(subfield)...)
-	gz.Close()
+	extra := append(header, []byte(subfield)...)
+
+	// The gzip stream is written by hand because newer versions of compress/flate close an empty
+	// NoCompression stream with a 2-byte block instead of the 5-byte stored block, which changes the size.
+	buf.Write([]byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff}) // ID1 ID2 CM FLG(FEXTRA) MTIME XFL OS
+	_ = binary.Write(buf, binary.LittleEndian, uint16(len(extra)))
+	buf.Write(extra)
+	buf.Write([]byte{0x01, 0x00, 0x00, 0xff, 0xff}) // final empty stored block
+	buf.Write(make([]byte, 8))                      // CRC32 and ISIZE of the empty payload
 	if buf.Len() != FooterSize {
 		panic(fmt.Sprintf("footer buffer = %d, not %d", buf.Len(), FooterSize))
 	}
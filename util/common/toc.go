@@ -85,6 +85,9 @@ const (
 	NoPrefetchLandmark = ".no.prefetch.landmark"
 
 	EmptyFileHash = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// DefaultChunkSize is the maximum size of a chunk of a regular file if the writer does not specify one
+	DefaultChunkSize = 4 << 20
 )
 
 // jtoc is the JSON-serialized table of contents index of the files in the stargz file.
@@ -405,6 +408,9 @@ type TOCEntry struct {
 
 	CompressedSize int64 `json:"compressedSize,omitempty"`
 
+	// Compression is the algorithm compressing the chunk, empty means gzip.
+	Compression string `json:"compression,omitempty"`
+
 	sourceLayer int
 	landmark    int
 
@@ -561,6 +567,7 @@ func (e *TOCEntry) CopyEntry() (c *TOCEntry) {
 		ChunkOffset:    e.ChunkOffset,
 		ChunkSize:      e.ChunkSize,
 		CompressedSize: e.CompressedSize,
+		Compression:    e.Compression,
 		sourceLayer:    e.sourceLayer,
 		landmark:       e.landmark,
 	}
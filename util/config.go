@@ -37,9 +37,18 @@ const (
 	StarlightTOCDigestAnnotation       = "containerd.io/snapshot/remote/starlight/toc.digest"
 	StarlightTOCCreationTimeAnnotation = "containerd.io/snapshot/remote/starlight/toc.timestamp"
 
+	// StarlightCompressionAnnotation, StarlightChunkSizeAnnotation and StarlightLargeFileThresholdAnnotation
+	// record how the convertor has compressed and chunked the layer
+	StarlightCompressionAnnotation        = "containerd.io/snapshot/remote/starlight/compression"
+	StarlightChunkSizeAnnotation          = "containerd.io/snapshot/remote/starlight/chunk.size"
+	StarlightLargeFileThresholdAnnotation = "containerd.io/snapshot/remote/starlight/chunk.threshold"
+
 	// ImageMediaTypeManifestV2 for containerd image TYPE field
 	ImageMediaTypeManifestV2 = "application/vnd.docker.distribution.manifest.v2+json"
 
+	// LayerMediaTypeZstd is the media type of the zstd compressed Starlight layers
+	LayerMediaTypeZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+
 	// ImageLabelPuller and ImageLabelStarlightMetadata are labels for containerd image
 	ImageLabelPuller            = "puller.containerd.io"
 	ImageLabelStarlightMetadata = "metadata.starlight.mc256.dev"
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -27,6 +28,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// zstdCompressionLevel maps to the "better compression" level of the zstd encoder,
+// the best compression level is too slow for large layers
+const zstdCompressionLevel = 9
+
 type StarlightLayer struct {
 	R io.Reader
 
@@ -36,6 +41,9 @@ type StarlightLayer struct {
 	Hash goreg.Hash
 
 	SizeVal int64
+
+	// Compression of the layer, the layer has the gzip media type if it is empty
+	Compression common.Compression
 }
 
 func (l StarlightLayer) Digest() (goreg.Hash, error) {
@@ -51,6 +59,9 @@ func (l StarlightLayer) DiffID() (goreg.Hash, error) {
 }
 
 func (l StarlightLayer) MediaType() (types.MediaType, error) {
+	if l.Compression == common.CompressionZstd {
+		return LayerMediaTypeZstd, nil
+	}
 	return types.DockerLayer, nil
 }
 
@@ -90,13 +101,59 @@ func NewStarlightLayer(f *os.File, stargzWriter *common.Writer) (goreg.Layer, er
 	}
 
 	return StarlightLayer{
-		R:       sr,
-		Diff:    d,
-		Hash:    h,
-		SizeVal: sr.Size(),
+		R:           sr,
+		Diff:        d,
+		Hash:        h,
+		SizeVal:     sr.Size(),
+		Compression: stargzWriter.Compression(),
 	}, nil
 }
 
+// ChunkingPolicy controls how the convertor splits the regular files into chunks. Files larger than
+// LargeFileThreshold are split into chunks of ChunkSize, smaller files are kept in one chunk.
+type ChunkingPolicy struct {
+	ChunkSize          int64
+	LargeFileThreshold int64
+}
+
+// DefaultChunkingPolicy splits the files larger than 4 MiB into 4 MiB chunks
+var DefaultChunkingPolicy = ChunkingPolicy{
+	ChunkSize:          common.DefaultChunkSize,
+	LargeFileThreshold: common.DefaultChunkSize,
+}
+
+// Validate checks the chunk size and the threshold are positive
+func (p ChunkingPolicy) Validate() error {
+	if p.ChunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive, got %d", p.ChunkSize)
+	}
+	if p.LargeFileThreshold <= 0 {
+		return fmt.Errorf("large file threshold must be positive, got %d", p.LargeFileThreshold)
+	}
+	return nil
+}
+
+func (p ChunkingPolicy) String() string {
+	return fmt.Sprintf("chunk=%d,threshold=%d", p.ChunkSize, p.LargeFileThreshold)
+}
+
+// ChunkingPolicyFromAnnotations reads the policy from the annotations of a converted layer,
+// ok is false if the layer was converted without recording the policy.
+func ChunkingPolicyFromAnnotations(annotations map[string]string) (p ChunkingPolicy, ok bool, err error) {
+	cs, hasCs := annotations[StarlightChunkSizeAnnotation]
+	th, hasTh := annotations[StarlightLargeFileThresholdAnnotation]
+	if !hasCs || !hasTh {
+		return ChunkingPolicy{}, false, nil
+	}
+	if p.ChunkSize, err = strconv.ParseInt(cs, 10, 64); err != nil {
+		return ChunkingPolicy{}, false, errors.Wrapf(err, "invalid chunk size annotation")
+	}
+	if p.LargeFileThreshold, err = strconv.ParseInt(th, 10, 64); err != nil {
+		return ChunkingPolicy{}, false, errors.Wrapf(err, "invalid large file threshold annotation")
+	}
+	return p, true, nil
+}
+
 type Convertor struct {
 	// There might be multiple `images` associate with an `index`,
 	// but we only implement image conversion here.
@@ -104,13 +161,18 @@ type Convertor struct {
 	ctx        context.Context
 	optsRemote []remote.Option
 	platforms  string
+
+	compression common.Compression
+	chunking    ChunkingPolicy
 }
 
 func NewConvertor(ctx context.Context, src, dst string, optsSrc, dstSrc []name.Option, optsRemote []remote.Option, platforms string) (c *Convertor, err error) {
 	c = &Convertor{
-		ctx:        ctx,
-		optsRemote: optsRemote,
-		platforms:  platforms,
+		ctx:         ctx,
+		optsRemote:  optsRemote,
+		platforms:   platforms,
+		compression: common.CompressionGzip,
+		chunking:    DefaultChunkingPolicy,
 	}
 	if c.src, err = name.ParseReference(src, optsSrc...); err != nil {
 		return nil, errors.Wrapf(err, "convertor failed to parse source image")
@@ -121,6 +183,20 @@ func NewConvertor(ctx context.Context, src, dst string, optsSrc, dstSrc []name.O
 	return c, nil
 }
 
+// SetCompression sets the compression algorithm of the converted layers, the default is gzip
+func (c *Convertor) SetCompression(compression common.Compression) {
+	c.compression = compression
+}
+
+// SetChunkingPolicy sets how the regular files are split into chunks, the default is DefaultChunkingPolicy
+func (c *Convertor) SetChunkingPolicy(p ChunkingPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	c.chunking = p
+	return nil
+}
+
 func (c *Convertor) String() string {
 	return fmt.Sprintf("Convertor{src=%s, dst=%s}", c.src, c.dst)
 }
@@ -185,8 +261,13 @@ func (c *Convertor) toStarlightLayer(idx, layerIdx int, layers []goreg.Layer,
 		return err
 	}
 	// modified version of stargz writer
-	w := common.NewWriterLevel(f, gzip.BestCompression)
-	// TODO: we could change the chunk size here but let's keep it as 4KB
+	level := gzip.BestCompression
+	if c.compression == common.CompressionZstd {
+		level = zstdCompressionLevel
+	}
+	w := common.NewWriterCompression(f, c.compression, level)
+	w.ChunkSize = int(c.chunking.ChunkSize)
+	w.LargeFileThreshold = c.chunking.LargeFileThreshold
 	if err := w.AppendTar(l); err != nil {
 		return err
 	}
@@ -209,15 +290,38 @@ func (c *Convertor) toStarlightLayer(idx, layerIdx int, layers []goreg.Layer,
 		Layer:   sll,
 		History: history,
 		Annotations: map[string]string{
-			StarlightTOCDigestAnnotation:       tocDigest.String(),
-			StarlightTOCCreationTimeAnnotation: time.Now().Format(time.RFC3339Nano),
-			common.TOCJSONDigestAnnotation:     tocDigest.String(),
+			StarlightTOCDigestAnnotation:          tocDigest.String(),
+			StarlightTOCCreationTimeAnnotation:    time.Now().Format(time.RFC3339Nano),
+			common.TOCJSONDigestAnnotation:        tocDigest.String(),
+			StarlightCompressionAnnotation:        string(c.compression),
+			StarlightChunkSizeAnnotation:          strconv.FormatInt(c.chunking.ChunkSize, 10),
+			StarlightLargeFileThresholdAnnotation: strconv.FormatInt(c.chunking.LargeFileThreshold, 10),
 		},
 	}
 
 	return nil
 }
 
+// ociEmptyImage is an empty image with the OCI manifest and config media types, the converted image inherits them
+type ociEmptyImage struct {
+	goreg.Image
+}
+
+func (i ociEmptyImage) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+func (i ociEmptyImage) Manifest() (*goreg.Manifest, error) {
+	m, err := i.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	m = m.DeepCopy()
+	m.MediaType = types.OCIManifestSchema1
+	m.Config.MediaType = types.OCIConfigJSON
+	return m, nil
+}
+
 func (c *Convertor) convertSingleImage(img goreg.Image) (goreg.Image, error) {
 	// config
 	cfg, err := img.ConfigFile()
@@ -266,7 +370,12 @@ func (c *Convertor) convertSingleImage(img goreg.Image) (goreg.Image, error) {
 	cfg.History = []goreg.History{}
 
 	// Set the configuration file to
-	configuredImage, err := mutate.ConfigFile(empty.Image, cfg)
+	base := empty.Image
+	if c.compression == common.CompressionZstd {
+		// zstd layers are only defined in the OCI image format
+		base = ociEmptyImage{empty.Image}
+	}
+	configuredImage, err := mutate.ConfigFile(base, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -311,6 +420,10 @@ func (c *Convertor) ToStarlightImage() (err error) {
 		if allPlatforms {
 			return true
 		}
+		if p == nil {
+			// entries of an OCI index are not required to have a platform
+			return false
+		}
 		for _, plt := range requestedPlatforms {
 			if plt.Equals(*p) {
 				return true
@@ -327,9 +440,14 @@ func (c *Convertor) ToStarlightImage() (err error) {
 		return errors.Wrapf(err, "failed to read image")
 	}
 
-	if imgDesc.MediaType == types.DockerManifestSchema2 {
+	if !imgDesc.MediaType.IsImage() && !imgDesc.MediaType.IsIndex() {
+		return fmt.Errorf("unsupported media type %s", imgDesc.MediaType)
+	}
+
+	if imgDesc.MediaType.IsImage() {
 		// single manifest image
 		// "application/vnd.docker.distribution.manifest.v2+json"
+		// "application/vnd.oci.image.manifest.v1+json"
 		var (
 			img goreg.Image
 		)
@@ -361,6 +479,7 @@ func (c *Convertor) ToStarlightImage() (err error) {
 	} else {
 		// image index
 		// "application/vnd.docker.distribution.manifest.list.v2+json"
+		// "application/vnd.oci.image.index.v1+json"
 		var (
 			imgIdx, retIdx goreg.ImageIndex
 			idxMan         *goreg.IndexManifest
@@ -381,6 +500,15 @@ func (c *Convertor) ToStarlightImage() (err error) {
 		for _, m := range idxMan.Manifests {
 			m := m
 			idxErrGrp.Go(func() error {
+				if !m.MediaType.IsImage() {
+					// e.g. nested indexes or the attestation manifests created by buildkit
+					log.G(c.ctx).WithFields(logrus.Fields{
+						"digest":    m.Digest.String(),
+						"mediaType": m.MediaType,
+					}).Info("skipped non-image manifest")
+					return nil
+				}
+
 				req := hasPlatform(m.Platform)
 				log.G(c.ctx).WithFields(logrus.Fields{
 					"platform": m.Platform,
@@ -401,6 +529,7 @@ func (c *Convertor) ToStarlightImage() (err error) {
 				var (
 					slImg goreg.Image
 					h     goreg.Hash
+					mt    types.MediaType
 				)
 
 				if slImg, err = c.convertSingleImage(img); err != nil {
@@ -412,13 +541,19 @@ func (c *Convertor) ToStarlightImage() (err error) {
 					return err
 				}
 
+				// the converted image may not have the media type of the original manifest
+				mt, err = slImg.MediaType()
+				if err != nil {
+					return err
+				}
+
 				idxAddendumMux.Lock()
 				defer idxAddendumMux.Unlock()
 
 				retIdx = mutate.AppendManifests(retIdx, mutate.IndexAddendum{
 					Add: slImg,
 					Descriptor: goreg.Descriptor{
-						MediaType:   m.MediaType,
+						MediaType:   mt,
 						Size:        m.Size,
 						Digest:      h,
 						URLs:        m.URLs,
@@ -8,8 +8,14 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	goreg "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/mc256/starlight/test"
+	"github.com/mc256/starlight/util/common"
 )
 
 func TestConvertorConstructor(t *testing.T) {
@@ -166,3 +172,61 @@ func TestToStarlightImageECR(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestChunkingPolicyFromAnnotations(t *testing.T) {
+	p := ChunkingPolicy{ChunkSize: 1 << 20, LargeFileThreshold: 16 << 20}
+	annotations := map[string]string{
+		StarlightChunkSizeAnnotation:          "1048576",
+		StarlightLargeFileThresholdAnnotation: "16777216",
+	}
+	if actual, ok, err := ChunkingPolicyFromAnnotations(annotations); err != nil || !ok || actual != p {
+		t.Errorf("expected %s but got %s (%v, %v)", p, actual, ok, err)
+	}
+
+	if _, ok, err := ChunkingPolicyFromAnnotations(map[string]string{}); err != nil || ok {
+		t.Errorf("expected no policy for old layers but got %v, %v", ok, err)
+	}
+
+	annotations[StarlightChunkSizeAnnotation] = "4MB"
+	if _, _, err := ChunkingPolicyFromAnnotations(annotations); err == nil {
+		t.Error("expected error for invalid chunk size")
+	}
+
+	c := &Convertor{}
+	if err := c.SetChunkingPolicy(ChunkingPolicy{ChunkSize: 0, LargeFileThreshold: 1}); err == nil {
+		t.Error("expected error for zero chunk size")
+	}
+}
+
+func TestConvertSingleImageMediaType(t *testing.T) {
+	for compression, expected := range map[common.Compression]types.MediaType{
+		common.CompressionGzip: types.DockerManifestSchema2,
+		common.CompressionZstd: types.OCIManifestSchema1,
+	} {
+		layer, err := random.Layer(1024, types.DockerLayer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: layer, History: goreg.History{CreatedBy: "test"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := &Convertor{ctx: context.Background(), compression: compression, chunking: DefaultChunkingPolicy}
+		slImg, err := c.convertSingleImage(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := slImg.Manifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.MediaType != expected {
+			t.Errorf("%s: expected manifest media type %s but got %s", compression, expected, m.MediaType)
+		}
+		if compression == common.CompressionZstd && (m.Config.MediaType != types.OCIConfigJSON ||
+			len(m.Layers) != 1 || m.Layers[0].MediaType != LayerMediaTypeZstd) {
+			t.Errorf("expected OCI config and zstd layer but got %s and %v", m.Config.MediaType, m.Layers)
+		}
+	}
+}
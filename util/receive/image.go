@@ -13,7 +13,9 @@ import (
 	"github.com/mc256/starlight/util/common"
 	"golang.org/x/sys/unix"
 	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -33,8 +35,20 @@ func (il ImageLayer) String() string {
 }
 
 type Content struct {
+	// Signal is closed once all the chunks of the content are extracted
 	Signal chan interface{} `json:"-"`
 
+	// chunkSignals[i] is closed once Chunks[i] is extracted, the content could be read partially
+	chunkSignals []chan interface{}
+
+	// mutex serializes writing the chunks, they could come from the delta image or an on-demand fetch
+	mutex sync.Mutex
+	// onDemand is called once if a reader has been waiting for the content longer than onDemandDelay,
+	// it fetches the content out of order instead of waiting for the delta image to reach it
+	onDemand      func()
+	onDemandDelay time.Duration
+	onDemandOnce  sync.Once
+
 	// ------------------------------------------
 	// stack identify which layer should this content be placed, all the files will be referencing the content
 	Stack int64 `json:"t"`
@@ -50,6 +64,131 @@ type Content struct {
 	Digest string `json:"d"`
 }
 
+// InitSignals creates the signals of the content and its chunks, it should be called before the extraction starts
+func (c *Content) InitSignals() {
+	c.Signal = make(chan interface{})
+	c.chunkSignals = make([]chan interface{}, len(c.Chunks))
+	for i := range c.chunkSignals {
+		c.chunkSignals[i] = make(chan interface{})
+	}
+}
+
+// SetChunkReady marks Chunks[idx] as extracted
+func (c *Content) SetChunkReady(idx int) {
+	if idx < len(c.chunkSignals) && !c.IsChunkReady(idx) {
+		close(c.chunkSignals[idx])
+	}
+}
+
+// IsChunkReady returns true if Chunks[idx] has been extracted
+func (c *Content) IsChunkReady(idx int) bool {
+	if idx >= len(c.chunkSignals) {
+		return true
+	}
+	select {
+	case <-c.chunkSignals[idx]:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetReady marks the entire content as extracted
+func (c *Content) SetReady() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	select {
+	case <-c.Signal:
+	default:
+		close(c.Signal)
+	}
+}
+
+// IsReady returns true if the entire content has been extracted
+func (c *Content) IsReady() bool {
+	select {
+	case <-c.Signal:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsAllChunksReady returns true if all the chunks have been extracted
+func (c *Content) IsAllChunksReady() bool {
+	for i := range c.chunkSignals {
+		if !c.IsChunkReady(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lock locks the content before writing its chunks
+func (c *Content) Lock() {
+	c.mutex.Lock()
+}
+
+func (c *Content) Unlock() {
+	c.mutex.Unlock()
+}
+
+// SetOnDemand sets the function that fetches the content out of order, it is called once in a new goroutine
+// if a reader waits longer than delay
+func (c *Content) SetOnDemand(delay time.Duration, fetch func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.onDemand = fetch
+	c.onDemandDelay = delay
+}
+
+// wait blocks until ch is closed, and triggers the on-demand fetch if it takes too long
+func (c *Content) wait(ch chan interface{}) {
+	select {
+	case <-ch:
+		return
+	default:
+	}
+
+	c.mutex.Lock()
+	fetch, delay := c.onDemand, c.onDemandDelay
+	c.mutex.Unlock()
+	if fetch == nil {
+		<-ch
+		return
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ch:
+		return
+	case <-t.C:
+		c.onDemandOnce.Do(func() { go fetch() })
+	}
+	<-ch
+}
+
+// WaitForReady blocks until the entire content is extracted
+func (c *Content) WaitForReady() {
+	c.wait(c.Signal)
+}
+
+// WaitForRange blocks until the chunks covering the bytes in [offset, offset+size) of the content are extracted
+func (c *Content) WaitForRange(offset, size int64) {
+	for i, ch := range c.Chunks {
+		if ch.ChunkSize > 0 && ch.ChunkOffset+ch.ChunkSize <= offset {
+			continue
+		}
+		if ch.ChunkOffset >= offset+size {
+			break
+		}
+		if i < len(c.chunkSignals) {
+			c.wait(c.chunkSignals[i])
+		}
+	}
+}
+
 func (c *Content) GetBaseDir() string {
 	return filepath.Join(c.Digest[7:8], c.Digest[8:10], c.Digest[10:12])
 }
@@ -82,6 +221,9 @@ type ReferencedFile struct {
 	// if Ready is nil or closed, means the file is ready
 	Ready *chan interface{} `json:"-"`
 
+	// content is the content in the payload, it is nil if the file is not in the payload
+	content *Content
+
 	stable   fuseFs.StableAttr
 	children []fs.ReceivedFile
 }
@@ -102,7 +244,21 @@ func (r *ReferencedFile) AppendChild(children fs.ReceivedFile) {
 }
 
 func (r *ReferencedFile) IsReady() bool {
-	return r.Ready == nil
+	if r.Ready == nil {
+		return true
+	}
+	select {
+	case <-*r.Ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetContent links the file to the content in the payload, the file is ready once the content is extracted
+func (r *ReferencedFile) SetContent(c *Content) {
+	r.content = c
+	r.Ready = &c.Signal
 }
 
 func (r *ReferencedFile) InitFuseStableAttr() {
@@ -160,9 +316,25 @@ func (r *ReferencedFile) GetRealPath() string {
 }
 
 func (r *ReferencedFile) WaitForReady() {
+	if r.Ready == nil {
+		return
+	}
+	if r.content != nil {
+		r.content.WaitForReady()
+		return
+	}
 	<-*r.Ready
 }
 
+// WaitForRange blocks until the bytes in [offset, offset+size) of the file are extracted
+func (r *ReferencedFile) WaitForRange(offset, size int64) {
+	if r.content == nil {
+		r.WaitForReady()
+		return
+	}
+	r.content.WaitForRange(offset, size)
+}
+
 func (r *ReferencedFile) IsReferencingRequestedImage() (stack int64, yes bool) {
 	if r.ReferenceFsId != 0 {
 		return 0, false
@@ -177,6 +349,8 @@ func (r *ReferencedFile) IsReferencingRequestedImage() (stack int64, yes bool) {
 	return r.Stack, true
 }
 
+// IsReferencingLocalFilesystem returns the serial of the layer on the client that has the content, it is
+// either a layer of the source image or one of the available layers (DeltaBundle.Available)
 func (r *ReferencedFile) IsReferencingLocalFilesystem() (serial int64, yes bool) {
 	if r.ReferenceFsId != 0 {
 		return r.ReferenceFsId, true
@@ -194,6 +368,10 @@ type FileChunk struct {
 	ChunkOffset    int64 `json:"c"`
 	ChunkSize      int64 `json:"h"`
 	CompressedSize int64 `json:"s"`
+	// Compression of the chunk, empty means gzip
+	Compression string `json:"z,omitempty"`
+	// ChunkDigest is the digest of the uncompressed chunk, empty if the layer was converted by older versions
+	ChunkDigest string `json:"d,omitempty"`
 }
 
 type File struct {
@@ -215,6 +393,14 @@ type DeltaBundle struct {
 	Source      *Image `json:"s"`
 	Destination *Image `json:"d"`
 
+	// Signature is the signature of the requested image verified by the proxy, it is empty if the proxy
+	// does not verify the signatures
+	Signature *common.SignatureAttestation `json:"sig,omitempty"`
+
+	// Available are the other layers on the client (besides the layers of Source),
+	// the files in these layers are treated as existing content as well
+	Available []*ImageLayer `json:"a,omitempty"`
+
 	// contents and BodyLength are computed by Builder.computeDelta()
 	Contents   []*Content `json:"c"`
 	BodyLength int64      `json:"bl"`
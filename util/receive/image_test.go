@@ -0,0 +1,109 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package receive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContent_WaitForRange(t *testing.T) {
+	c := &Content{Chunks: []*FileChunk{
+		{ChunkOffset: 0, ChunkSize: 100},
+		{ChunkOffset: 100, ChunkSize: 100},
+		{ChunkOffset: 200, ChunkSize: 50},
+	}}
+	c.InitSignals()
+
+	waitFor := func(offset, size int64) bool {
+		done := make(chan interface{})
+		go func() {
+			c.WaitForRange(offset, size)
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	}
+
+	if waitFor(0, 10) {
+		t.Fatal("nothing is extracted yet")
+	}
+	c.SetChunkReady(0)
+	if !waitFor(0, 100) {
+		t.Error("the first chunk is extracted")
+	}
+	if waitFor(90, 20) {
+		t.Error("the range spans the second chunk")
+	}
+	c.SetChunkReady(1)
+	c.SetChunkReady(1) // no panic
+	if !waitFor(90, 20) {
+		t.Error("the first two chunks are extracted")
+	}
+	if c.IsChunkReady(2) {
+		t.Error("the last chunk is not extracted")
+	}
+	f := &ReferencedFile{}
+	f.SetContent(c)
+	if f.IsReady() {
+		t.Error("the file is not ready until the content signal is closed")
+	}
+	close(c.Signal)
+	if !f.IsReady() {
+		t.Error("the file should be ready")
+	}
+}
+
+func TestContent_OnDemand(t *testing.T) {
+	c := &Content{Chunks: []*FileChunk{
+		{ChunkOffset: 0, ChunkSize: 100},
+		{ChunkOffset: 100, ChunkSize: 100},
+	}}
+	c.InitSignals()
+
+	requested := make(chan interface{}, 10)
+	c.SetOnDemand(10*time.Millisecond, func() {
+		requested <- nil
+		c.SetChunkReady(0)
+		c.SetChunkReady(1)
+		c.SetReady()
+	})
+
+	f := &ReferencedFile{}
+	f.SetContent(c)
+	done := make(chan interface{})
+	go func() {
+		f.WaitForRange(150, 10)
+		f.WaitForReady()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the on-demand fetch should have been triggered")
+	}
+
+	c.SetReady() // no panic
+	f.WaitForReady()
+	if len(requested) != 1 {
+		t.Errorf("expected the on-demand fetch to be called once but got %d", len(requested))
+	}
+}
@@ -113,13 +113,19 @@ type FileChunk struct {
 	ChunkOffset    int64 `json:"c"`
 	ChunkSize      int64 `json:"h"`
 	CompressedSize int64 `json:"s"`
+	// Compression of the chunk, empty means gzip
+	Compression string `json:"z,omitempty"`
+	// ChunkDigest is the digest of the uncompressed chunk, empty if the layer was converted by older versions
+	ChunkDigest string `json:"d,omitempty"`
 }
 
 type FileChunkParsing struct {
-	Offset         int64 `json:"offset"`
-	ChunkOffset    int64 `json:"chunkOffset"`
-	ChunkSize      int64 `json:"chunkSize"`
-	CompressedSize int64 `json:"compressedSize"`
+	Offset         int64  `json:"offset"`
+	ChunkOffset    int64  `json:"chunkOffset"`
+	ChunkSize      int64  `json:"chunkSize"`
+	CompressedSize int64  `json:"compressedSize"`
+	Compression    string `json:"compression,omitempty"`
+	ChunkDigest    string `json:"chunkDigest,omitempty"`
 }
 
 type File struct {
@@ -149,6 +155,14 @@ type DeltaBundle struct {
 	Source      *Image `json:"s"`
 	Destination *Image `json:"d"`
 
+	// Signature is the signature of the requested image verified by the proxy, it is empty if the proxy
+	// does not verify the signatures
+	Signature *common.SignatureAttestation `json:"sig,omitempty"`
+
+	// Available are the other layers on the client (besides the layers of Source),
+	// the files in these layers are treated as existing content as well
+	Available []*ImageLayer `json:"a,omitempty"`
+
 	// contents and BodyLength are computed by Builder.computeDelta()
 	Contents   []*Content `json:"c"`
 	BodyLength int64      `json:"bl"`
@@ -0,0 +1,208 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// tlsReloadInterval is the minimum interval between two checks of the certificate files
+	tlsReloadInterval = 5 * time.Second
+)
+
+// TLSReloader loads the certificate, key and CA bundle, and reloads them once the files change,
+// so that short-lived certificates could be rotated without restarting.
+// Any of the files could be empty, the certificate and the key must be set together.
+type TLSReloader struct {
+	certFile, keyFile, caFile string
+
+	mutex     sync.RWMutex
+	cert      *tls.Certificate
+	pool      *x509.CertPool
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func (r *TLSReloader) latestModTime() (t time.Time, err error) {
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		s, err := os.Stat(f)
+		if err != nil {
+			return t, err
+		}
+		if s.ModTime().After(t) {
+			t = s.ModTime()
+		}
+	}
+	return t, nil
+}
+
+func (r *TLSReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load certificate")
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		buf, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read CA bundle")
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return fmt.Errorf("no certificate found in CA bundle %s", r.caFile)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cert, r.pool, r.modTime = cert, pool, modTime
+	return nil
+}
+
+// check reloads the files if they have changed, if the new files are invalid (e.g. the certificate has been
+// updated but not the key yet), it keeps using the previous ones
+func (r *TLSReloader) check() {
+	r.mutex.Lock()
+	if time.Since(r.lastCheck) < tlsReloadInterval {
+		r.mutex.Unlock()
+		return
+	}
+	r.lastCheck = time.Now()
+	last := r.modTime
+	r.mutex.Unlock()
+
+	if t, err := r.latestModTime(); err != nil || !t.After(last) {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.L.WithError(err).Warn("failed to reload TLS certificates")
+		return
+	}
+	log.L.WithField("cert", r.certFile).WithField("ca", r.caFile).Info("reloaded TLS certificates")
+}
+
+func (r *TLSReloader) certificate() (*tls.Certificate, error) {
+	r.check()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if r.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return r.cert, nil
+}
+
+// CertPool returns the latest CA bundle, it is nil if no CA bundle is set
+func (r *TLSReloader) CertPool() *x509.CertPool {
+	r.check()
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.pool
+}
+
+// ServerConfig returns a TLS configuration for servers. If the CA bundle is set,
+// clients must present a certificate signed by it (mutual TLS).
+func (r *TLSReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// required by http.Server.ListenAndServeTLS, the certificate is actually served by GetConfigForClient
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return r.certificate()
+				},
+			}
+			if pool := r.CertPool(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS configuration for clients. The server is verified using the CA bundle if
+// it is set, otherwise the system roots. The certificate is presented to the server if it is set.
+func (r *TLSReloader) ClientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+	if r.caFile != "" {
+		// verify the server with the latest CA bundle
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("no certificate presented by the server")
+			}
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         r.CertPool(),
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, c := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(c)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	}
+	return cfg
+}
+
+func NewTLSReloader(certFile, keyFile, caFile string) (*TLSReloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("certificate and key must be set together")
+	}
+	r := &TLSReloader{
+		certFile:  certFile,
+		keyFile:   keyFile,
+		caFile:    caFile,
+		lastCheck: time.Now(),
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
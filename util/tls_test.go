@@ -0,0 +1,144 @@
+/*
+   Copyright The starlight Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func writePem(t *testing.T, p, typ string, b []byte) {
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: b}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCA(t *testing.T, p string) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "starlight test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	writePem(t, p, "CERTIFICATE", der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, certFile, keyFile, cn string, serial int64) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, _ := x509.MarshalECPrivateKey(key)
+	writePem(t, certFile, "CERTIFICATE", der)
+	writePem(t, keyFile, "EC PRIVATE KEY", kb)
+}
+
+func TestTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	f := func(n string) string { return filepath.Join(dir, n) }
+
+	ca := newTestCA(t, f("ca.pem"))
+	ca.issue(t, f("server.pem"), f("server.key"), "proxy", 2)
+	ca.issue(t, f("client.pem"), f("client.key"), "worker", 3)
+
+	sr, err := NewTLSReloader(f("server.pem"), f("server.key"), f("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	server.TLS = sr.ServerConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(r *TLSReloader) (string, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: r.ClientConfig()}}
+		resp, err := c.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 64)
+		n, _ := resp.Body.Read(buf)
+		return string(buf[:n]), nil
+	}
+
+	// mutual TLS
+	cr, err := NewTLSReloader(f("client.pem"), f("client.key"), f("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, err := get(cr); err != nil || cn != "worker" {
+		t.Fatalf("expected worker but got %q (%v)", cn, err)
+	}
+
+	// no client certificate
+	nr, err := NewTLSReloader("", "", f("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(nr); err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+
+	// rotate the client certificate
+	ca.issue(t, f("client.pem"), f("client.key"), "worker-2", 4)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(f("client.pem"), future, future)
+	_ = os.Chtimes(f("client.key"), future, future)
+	cr.lastCheck = time.Time{}
+	if cn, err := get(cr); err != nil || cn != "worker-2" {
+		t.Fatalf("expected worker-2 but got %q (%v)", cn, err)
+	}
+}